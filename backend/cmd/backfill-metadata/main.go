@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"picsite/internal/config"
+	"picsite/internal/models"
+	"picsite/internal/services"
+)
+
+func main() {
+	// 加载配置
+	cfg := config.Load()
+
+	// 初始化数据库
+	if err := services.InitDB(cfg); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// 初始化存储后端
+	if err := services.InitStorage(cfg); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// 查询缺少尺寸、大小或类型信息的照片
+	var photos []models.Photo
+	if err := services.DB.Where("width = 0 OR height = 0 OR file_size = 0 OR mime_type = '' OR mime_type IS NULL").
+		Find(&photos).Error; err != nil {
+		log.Fatalf("Failed to fetch photos: %v", err)
+	}
+
+	fmt.Printf("找到 %d 张需要补全信息的照片\n", len(photos))
+
+	store := services.GetStorage()
+	updatedCount := 0
+	failedCount := 0
+
+	for _, photo := range photos {
+		key := services.ResolveStorageKey(photo.FileKey, photo.FilePath)
+		if key == "" {
+			log.Printf("❌ 照片 %d 无法确定存储位置: %s\n", photo.ID, photo.FilePath)
+			failedCount++
+			continue
+		}
+
+		info, err := services.InspectUpload(store, key)
+		if err != nil {
+			log.Printf("❌ 读取照片 %d 失败: %v\n", photo.ID, err)
+			failedCount++
+			continue
+		}
+
+		updates := map[string]interface{}{
+			"width":        info.Width,
+			"height":       info.Height,
+			"aspect_ratio": info.AspectRatio,
+			"file_size":    info.Size,
+			"mime_type":    info.MimeType,
+		}
+		if err := services.DB.Model(&photo).Updates(updates).Error; err != nil {
+			log.Printf("❌ 更新照片 %d 失败: %v\n", photo.ID, err)
+			failedCount++
+			continue
+		}
+
+		updatedCount++
+		fmt.Printf("✅ 照片 %d - %dx%d, %d 字节, %s\n", photo.ID, info.Width, info.Height, info.Size, info.MimeType)
+	}
+
+	fmt.Printf("\n================================\n")
+	fmt.Printf("总计: %d 张照片\n", len(photos))
+	fmt.Printf("已补全: %d 张照片\n", updatedCount)
+	fmt.Printf("失败: %d 张照片\n", failedCount)
+	fmt.Printf("================================\n")
+}
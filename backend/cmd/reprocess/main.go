@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"picsite/internal/config"
+	"picsite/internal/models"
+	"picsite/internal/services"
+)
+
+func main() {
+	fromID := flag.Uint("from", 0, "只处理 ID 大于等于该值的照片")
+	toID := flag.Uint("to", 0, "只处理 ID 小于等于该值的照片（0 表示不限制）")
+	albumID := flag.Uint("album", 0, "只处理指定相册中的照片")
+	missingOnly := flag.Bool("missing-only", false, "只处理缺少缩略图、尺寸、占位预览、视觉特征或调色板的照片")
+	overwriteEXIF := flag.Bool("overwrite-exif", false, "使用 EXIF 覆盖已填写的拍摄参数（默认只填充空字段）")
+	concurrency := flag.Int("concurrency", 4, "并发处理的照片数量")
+	stateFile := flag.String("state", "reprocess.state", "进度文件，记录已完成的照片 ID")
+	resume := flag.Bool("resume", false, "跳过进度文件中已完成的照片，继续上次中断的处理")
+	flag.Parse()
+
+	// 加载配置
+	cfg := config.Load()
+
+	// 初始化数据库
+	if err := services.InitDB(cfg); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// 初始化存储后端
+	if err := services.InitStorage(cfg); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// 初始化图片处理参数
+	services.InitImageProcessing(cfg)
+
+	// 查询需要处理的照片
+	query := services.DB.Model(&models.Photo{}).Order("photos.id ASC")
+	if *fromID > 0 {
+		query = query.Where("photos.id >= ?", *fromID)
+	}
+	if *toID > 0 {
+		query = query.Where("photos.id <= ?", *toID)
+	}
+	if *albumID > 0 {
+		query = query.Joins("JOIN album_photos ON album_photos.photo_id = photos.id").
+			Where("album_photos.album_id = ?", *albumID)
+	}
+
+	var photos []models.Photo
+	if err := query.Find(&photos).Error; err != nil {
+		log.Fatalf("Failed to fetch photos: %v", err)
+	}
+
+	// 读取上次的进度
+	done := make(map[uint]bool)
+	if *resume {
+		var err error
+		if done, err = loadState(*stateFile); err != nil {
+			log.Fatalf("Failed to read state file: %v", err)
+		}
+		fmt.Printf("从进度文件恢复，已完成 %d 张照片\n", len(done))
+	} else if err := os.Remove(*stateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Failed to reset state file: %v", err)
+	}
+
+	state, err := os.OpenFile(*stateFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Fatalf("Failed to open state file: %v", err)
+	}
+	defer state.Close()
+
+	fmt.Printf("找到 %d 张照片\n", len(photos))
+
+	store := services.GetStorage()
+	opts := services.ProcessOptions{OverwriteEXIF: *overwriteEXIF}
+
+	var (
+		mu             sync.Mutex
+		processedCount int
+		skippedCount   int
+		failedCount    int
+	)
+
+	work := make(chan models.Photo)
+	var wg sync.WaitGroup
+	for i := 0; i < max(*concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for photo := range work {
+				if *missingOnly && !needsProcessing(store, photo) {
+					mu.Lock()
+					skippedCount++
+					fmt.Fprintf(state, "%d\n", photo.ID)
+					mu.Unlock()
+					continue
+				}
+
+				// 清除按需生成的缓存，下次访问时使用新的处理结果
+				if err := services.ClearImageCache(store, photo.ID); err != nil {
+					log.Printf("⚠️  照片 %d 清除缓存失败: %v\n", photo.ID, err)
+				}
+
+				err := services.ProcessPhoto(photo.ID, opts)
+
+				mu.Lock()
+				if err != nil {
+					failedCount++
+					log.Printf("❌ 处理照片 %d 失败: %v\n", photo.ID, err)
+					// 已公开的照片恢复处理前的状态，继续使用上次的处理结果
+					var markErr error
+					if photo.ProcessingStatus == models.PhotoStatusReady {
+						markErr = services.DB.Model(&models.Photo{}).Where("id = ?", photo.ID).
+							Update("processing_status", photo.ProcessingStatus).Error
+					} else {
+						markErr = services.MarkPhotoFailed(photo.ID, err)
+					}
+					if markErr != nil {
+						log.Printf("❌ 更新照片 %d 状态失败: %v\n", photo.ID, markErr)
+					}
+				} else {
+					processedCount++
+					fmt.Fprintf(state, "%d\n", photo.ID)
+					fmt.Printf("✅ 照片 %d 已重新处理\n", photo.ID)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	resumedCount := 0
+	for _, photo := range photos {
+		if done[photo.ID] {
+			resumedCount++
+			continue
+		}
+		work <- photo
+	}
+	close(work)
+	wg.Wait()
+
+	// 全部成功后删除进度文件，下次运行从头开始
+	if failedCount == 0 {
+		state.Close()
+		os.Remove(*stateFile)
+	}
+
+	fmt.Printf("\n================================\n")
+	fmt.Printf("总计: %d 张照片\n", len(photos))
+	fmt.Printf("已处理: %d 张照片\n", processedCount)
+	fmt.Printf("无需处理: %d 张照片\n", skippedCount)
+	if *resume {
+		fmt.Printf("上次已完成: %d 张照片\n", resumedCount)
+	}
+	fmt.Printf("失败: %d 张照片\n", failedCount)
+	if failedCount > 0 {
+		fmt.Printf("可使用 -resume 重新处理失败的照片\n")
+	}
+	fmt.Printf("================================\n")
+}
+
+// needsProcessing 判断照片是否缺少处理结果
+func needsProcessing(store services.Storage, photo models.Photo) bool {
+	if photo.ProcessingStatus != models.PhotoStatusReady ||
+		photo.Width == 0 || photo.Height == 0 || photo.MimeType == "" || photo.BlurHash == "" ||
+		photo.PerceptualHash == "" || photo.ColorHistogram == "" {
+		return true
+	}
+
+	var colors int64
+	services.GetDB().Model(&models.PhotoColor{}).Where("photo_id = ?", photo.ID).Count(&colors)
+	if colors == 0 {
+		return true
+	}
+
+	thumbnailKey := services.ResolveStorageKey(photo.ThumbnailKey, photo.ThumbnailPath)
+	if thumbnailKey == "" {
+		return true
+	}
+	if _, err := store.Stat(thumbnailKey); err != nil {
+		return true
+	}
+	return false
+}
+
+// loadState 读取进度文件中已完成的照片 ID
+func loadState(path string) (map[uint]bool, error) {
+	done := make(map[uint]bool)
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id, err := strconv.ParseUint(strings.TrimSpace(scanner.Text()), 10, 64)
+		if err != nil {
+			continue // 忽略中断时写了一半的行
+		}
+		done[uint(id)] = true
+	}
+	return done, scanner.Err()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+
+	"picsite/internal/config"
+	"picsite/internal/models"
+	"picsite/internal/services"
+)
+
+// 水印配置（WATERMARK_*）变更后重新生成所有公开的图片：清除按需生成的缓存，
+// 重新生成缩略图和公开的原图副本，eager 模式下重新生成各尺寸版本。
+// 去掉水印配置后运行可以移除已有的水印
+func main() {
+	fromID := flag.Uint("from", 0, "只处理 ID 大于等于该值的照片")
+	toID := flag.Uint("to", 0, "只处理 ID 小于等于该值的照片（0 表示不限制）")
+	albumID := flag.Uint("album", 0, "只处理指定相册中的照片")
+	concurrency := flag.Int("concurrency", 4, "并发处理的照片数量")
+	flag.Parse()
+
+	// 加载配置
+	cfg := config.Load()
+
+	// 初始化数据库
+	if err := services.InitDB(cfg); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// 初始化存储后端
+	if err := services.InitStorage(cfg); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// 初始化图片处理参数和水印
+	services.InitImageProcessing(cfg)
+	if services.WatermarkConfigured() {
+		fmt.Printf("💧 当前水印位置: %s，不透明度: %.2f，宽度比例: %.2f\n",
+			services.ImageConfig.Watermark.Position, services.ImageConfig.Watermark.Opacity, services.ImageConfig.Watermark.Scale)
+	} else {
+		fmt.Printf("⚠️  未配置水印，将移除已有的水印\n")
+	}
+
+	// 查询已处理完成的照片，其他照片处理时会使用当前的水印配置
+	query := services.DB.Model(&models.Photo{}).
+		Where("photos.processing_status = ?", models.PhotoStatusReady).
+		Order("photos.id ASC")
+	if *fromID > 0 {
+		query = query.Where("photos.id >= ?", *fromID)
+	}
+	if *toID > 0 {
+		query = query.Where("photos.id <= ?", *toID)
+	}
+	if *albumID > 0 {
+		query = query.Joins("JOIN album_photos ON album_photos.photo_id = photos.id").
+			Where("album_photos.album_id = ?", *albumID)
+	}
+
+	var photoIDs []uint
+	if err := query.Pluck("photos.id", &photoIDs).Error; err != nil {
+		log.Fatalf("Failed to fetch photos: %v", err)
+	}
+
+	fmt.Printf("找到 %d 张照片\n", len(photoIDs))
+
+	var (
+		mu             sync.Mutex
+		processedCount int
+		failedCount    int
+	)
+
+	work := make(chan uint)
+	var wg sync.WaitGroup
+	for i := 0; i < max(*concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				err := services.RewatermarkPhoto(id)
+
+				mu.Lock()
+				if err != nil {
+					failedCount++
+					log.Printf("❌ 照片 %d 重新生成失败: %v\n", id, err)
+				} else {
+					processedCount++
+					fmt.Printf("✅ 照片 %d 已重新生成\n", id)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, id := range photoIDs {
+		work <- id
+	}
+	close(work)
+	wg.Wait()
+
+	fmt.Printf("\n================================\n")
+	fmt.Printf("总计: %d 张照片\n", len(photoIDs))
+	fmt.Printf("已重新生成: %d 张照片\n", processedCount)
+	fmt.Printf("失败: %d 张照片\n", failedCount)
+	fmt.Printf("================================\n")
+}
@@ -24,19 +24,40 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// 初始化存储后端
+	if err := services.InitStorage(cfg); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// 初始化图片处理参数
+	services.InitImageProcessing(cfg)
+
+	// 启动后台任务队列（缩略图、尺寸版本、图片信息提取）
+	services.InitJobQueue(cfg)
+	services.GetJobQueue().Start()
+
 	// 创建 Gin 路由
 	r := gin.Default()
 
 	// 添加 CORS 中间件
 	r.Use(middleware.CORSMiddleware())
 
-	// 静态文件服务
-	r.Static("/uploads", "./uploads")
+	photoHandler := handlers.NewPhotoHandler()
+
+	// 静态文件服务（仅本地存储，S3 存储的文件通过其公开地址访问）
+	// private/ 下的原图和附属文件不对外提供
+	if _, ok := services.GetStorage().(*services.LocalStorage); ok {
+		r.GET(services.LocalStorageURLPrefix+"/*filepath", photoHandler.ServeImage)
+		r.HEAD(services.LocalStorageURLPrefix+"/*filepath", photoHandler.ServeImage)
+	}
+
+	// 按需生成图片（签名参数）
+	imageHandler := handlers.NewImageHandler()
+	r.GET("/img/:id", imageHandler.Serve)
 
 	// API 路由
 	api := r.Group("/api")
 	{
-		photoHandler := handlers.NewPhotoHandler()
 		albumHandler := handlers.NewAlbumHandler()
 		authHandler := handlers.NewAuthHandler(cfg)
 
@@ -48,11 +69,14 @@ func main() {
 			auth.POST("/logout", authHandler.Logout)
 		}
 
-		// 照片相关路由（公开）
+		// 照片相关路由（公开，登录后返回所有照片的拍摄位置）
 		photos := api.Group("/photos")
+		photos.Use(middleware.OptionalAuthMiddleware(cfg.JWTSecret))
 		{
 			photos.GET("", photoHandler.GetAll)
+			photos.GET("/map", photoHandler.Map)
 			photos.GET("/:id", photoHandler.GetByID)
+			photos.GET("/:id/similar", photoHandler.Similar)
 			photos.POST("/:id/view", photoHandler.IncrementView)
 		}
 
@@ -62,14 +86,19 @@ func main() {
 		{
 			photosAdmin.POST("", photoHandler.Create)
 			photosAdmin.PUT("/:id", photoHandler.Update)
+			photosAdmin.PUT("/:id/watermark", photoHandler.SetWatermark)
 			photosAdmin.DELETE("/:id", photoHandler.Delete)
+			photosAdmin.GET("/:id/original", photoHandler.Original)
+			photosAdmin.GET("/:id/metadata", photoHandler.Metadata)
 			photosAdmin.DELETE("/batch", photoHandler.BatchDelete)
 			photosAdmin.PATCH("/batch/tags", photoHandler.BatchUpdateTags)
 			photosAdmin.PATCH("/batch/featured", photoHandler.BatchUpdateFeatured)
+			photosAdmin.PATCH("/batch/shot-date", photoHandler.BatchShiftDates)
 		}
 
 		// 相册相关路由（公开）
 		albums := api.Group("/albums")
+		albums.Use(middleware.OptionalAuthMiddleware(cfg.JWTSecret))
 		{
 			albums.GET("", albumHandler.GetAll)
 			albums.GET("/:id", albumHandler.GetByID)
@@ -82,6 +111,7 @@ func main() {
 		{
 			albumsAdmin.POST("", albumHandler.Create)
 			albumsAdmin.PUT("/:id", albumHandler.Update)
+			albumsAdmin.PUT("/:id/watermark", albumHandler.SetWatermark)
 			albumsAdmin.DELETE("/:id", albumHandler.Delete)
 			albumsAdmin.POST("/:id/photos", albumHandler.AddPhotoToAlbum)
 			albumsAdmin.DELETE("/:id/photos/:photo_id", albumHandler.RemovePhotoFromAlbum)
@@ -89,6 +119,25 @@ func main() {
 			albumsAdmin.DELETE("/:id/password", albumHandler.RemovePassword)
 		}
 
+		// 后台任务（需要认证）
+		jobHandler := handlers.NewJobHandler()
+		jobs := api.Group("/jobs")
+		jobs.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+		{
+			jobs.GET("", jobHandler.GetAll)
+			jobs.GET("/:id", jobHandler.GetByID)
+			jobs.POST("/:id/retry", jobHandler.Retry)
+		}
+
+		// 近似重复照片（需要认证）
+		duplicateHandler := handlers.NewDuplicateHandler()
+		admin := api.Group("/admin")
+		admin.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+		{
+			admin.GET("/duplicates", duplicateHandler.GetAll)
+			admin.POST("/duplicates/merge", duplicateHandler.Merge)
+		}
+
 		// 文件上传（需要认证）
 		api.POST("/upload", middleware.AuthMiddleware(cfg.JWTSecret), photoHandler.UploadFile)
 
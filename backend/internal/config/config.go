@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -9,6 +11,50 @@ type Config struct {
 	DBPath     string
 	UploadPath string
 	JWTSecret  string
+
+	// 存储后端: local 或 s3
+	StorageBackend  string
+	S3Endpoint      string
+	S3Region        string
+	S3Bucket        string
+	S3AccessKey     string
+	S3SecretKey     string
+	S3UseSSL        bool
+	S3PublicURL     string
+	S3PrivateBucket string
+
+	// 图片处理
+	RenditionWidths  []int
+	RenditionFormats []string
+	JPEGQuality      int
+	WebPQuality      int
+	AVIFQuality      int
+
+	// 按需生成图片（/img 接口）
+	RenditionMode     string
+	ImageSigningKey   string
+	ImageMaxDimension int
+
+	// 公开原图的元数据处理方式：keep、strip_gps、strip_all
+	MetadataPrivacy string
+
+	// 上传校验：最大像素数和解码后占用的最大内存（MB）
+	UploadMaxPixels    int
+	UploadMaxDecodedMB int
+
+	// 水印：文字或 PNG 图片，只加在公开的尺寸版本和原图副本上
+	WatermarkText     string
+	WatermarkImage    string // PNG 文件路径，同时设置时优先于文字
+	WatermarkFont     string // 文字使用的 TrueType/OpenType 字体路径，默认使用内置的 Go Bold（不含中文字形）
+	WatermarkPosition string
+	WatermarkOpacity  float64
+	WatermarkScale    float64 // 水印宽度占图片宽度的比例
+	WatermarkMinSize  int     // 长边小于该值的图片不加水印，缩略图除外
+
+	// 后台任务队列
+	JobWorkers      int
+	JobMaxAttempts  int
+	JobRetryBackoff int // 首次重试等待秒数，之后每次翻倍
 }
 
 func Load() *Config {
@@ -17,6 +63,43 @@ func Load() *Config {
 		DBPath:     getEnv("DB_PATH", "./picsite.db"),
 		UploadPath: getEnv("UPLOAD_PATH", "./uploads"),
 		JWTSecret:  getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+
+		StorageBackend:  getEnv("STORAGE_BACKEND", "local"),
+		S3Endpoint:      getEnv("S3_ENDPOINT", ""),
+		S3Region:        getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:        getEnv("S3_BUCKET", ""),
+		S3AccessKey:     getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:     getEnv("S3_SECRET_KEY", ""),
+		S3UseSSL:        getEnv("S3_USE_SSL", "true") == "true",
+		S3PublicURL:     getEnv("S3_PUBLIC_URL", ""),
+		S3PrivateBucket: getEnv("S3_PRIVATE_BUCKET", ""),
+
+		RenditionWidths:  getEnvInts("RENDITION_WIDTHS", []int{200, 400, 800, 1600, 2400}),
+		RenditionFormats: getEnvList("RENDITION_FORMATS", []string{"jpeg", "webp"}),
+		JPEGQuality:      getEnvInt("JPEG_QUALITY", 85),
+		WebPQuality:      getEnvInt("WEBP_QUALITY", 80),
+		AVIFQuality:      getEnvInt("AVIF_QUALITY", 60),
+
+		RenditionMode:     getEnv("RENDITION_MODE", "on_demand"),
+		ImageSigningKey:   getEnv("IMAGE_SIGNING_KEY", ""),
+		ImageMaxDimension: getEnvInt("IMAGE_MAX_DIMENSION", 4096),
+
+		MetadataPrivacy: getEnv("METADATA_PRIVACY", "strip_gps"),
+
+		UploadMaxPixels:    getEnvInt("UPLOAD_MAX_PIXELS", 100_000_000),
+		UploadMaxDecodedMB: getEnvInt("UPLOAD_MAX_DECODED_MB", 512),
+
+		WatermarkText:     getEnv("WATERMARK_TEXT", ""),
+		WatermarkImage:    getEnv("WATERMARK_IMAGE", ""),
+		WatermarkFont:     getEnv("WATERMARK_FONT", ""),
+		WatermarkPosition: getEnv("WATERMARK_POSITION", "bottom-right"),
+		WatermarkOpacity:  getEnvFloat("WATERMARK_OPACITY", 0.5),
+		WatermarkScale:    getEnvFloat("WATERMARK_SCALE", 0.2),
+		WatermarkMinSize:  getEnvInt("WATERMARK_MIN_SIZE", 600),
+
+		JobWorkers:      getEnvInt("JOB_WORKERS", 2),
+		JobMaxAttempts:  getEnvInt("JOB_MAX_ATTEMPTS", 3),
+		JobRetryBackoff: getEnvInt("JOB_RETRY_BACKOFF", 30),
 	}
 }
 
@@ -26,3 +109,51 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvInts 解析逗号分隔的整数列表，例如 "200,400,800"
+func getEnvInts(key string, defaultValue []int) []int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return defaultValue
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// getEnvList 解析逗号分隔的字符串列表，例如 "jpeg,webp"
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.ToLower(strings.TrimSpace(part)); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
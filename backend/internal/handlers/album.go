@@ -21,7 +21,9 @@ func NewAlbumHandler() *AlbumHandler {
 func (h *AlbumHandler) GetAll(c *gin.Context) {
 	var albums []models.Album
 
-	query := services.GetDB().Model(&models.Album{}).Preload("Photos")
+	query := services.GetDB().Model(&models.Album{}).
+		Preload("Photos.Renditions", orderRenditions).
+		Preload("Photos.Palette", orderPalette)
 
 	// 排序
 	query = query.Order("created_at DESC")
@@ -38,6 +40,12 @@ func (h *AlbumHandler) GetAll(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range albums {
+		services.AttachRenditions(albums[i].Photos)
+		if !isAuthenticated(c) {
+			services.RedactLocation(albums[i].Photos)
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": albums,
@@ -54,7 +62,7 @@ func (h *AlbumHandler) GetByID(c *gin.Context) {
 	id := c.Param("id")
 	var album models.Album
 
-	if err := services.GetDB().Preload("Photos").First(&album, id).Error; err != nil {
+	if err := services.GetDB().Preload("Photos.Renditions", orderRenditions).Preload("Photos.Palette", orderPalette).First(&album, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
 		return
 	}
@@ -88,6 +96,10 @@ func (h *AlbumHandler) GetByID(c *gin.Context) {
 			return
 		}
 	}
+	services.AttachRenditions(album.Photos)
+	if !isAuthenticated(c) {
+		services.RedactLocation(album.Photos)
+	}
 
 	c.JSON(http.StatusOK, album)
 }
@@ -123,8 +135,8 @@ func (h *AlbumHandler) Update(c *gin.Context) {
 		return
 	}
 
-	// 更新字段
-	services.GetDB().Model(&album).Updates(updateData)
+	// 更新字段（水印开关通过 SetWatermark 修改）
+	services.GetDB().Model(&album).Omit("watermark_disabled").Updates(updateData)
 
 	c.JSON(http.StatusOK, album)
 }
@@ -138,11 +150,18 @@ func (h *AlbumHandler) Delete(c *gin.Context) {
 		return
 	}
 
+	// 关闭了水印的相册删除后，其中的照片需要重新加水印
+	var photoIDs []uint
+	if album.WatermarkDisabled {
+		photoIDs = albumPhotoIDs(album.ID)
+	}
+
 	// 删除数据库记录（会自动删除关联表中的记录）
 	if err := services.GetDB().Delete(&album).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	enqueueWatermark(photoIDs...)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Album deleted successfully"})
 }
@@ -170,6 +189,9 @@ func (h *AlbumHandler) AddPhotoToAlbum(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if albumWatermarkDisabled(albumPhoto.AlbumID) {
+		enqueueWatermark(albumPhoto.PhotoID)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Photo added to album successfully"})
 }
@@ -184,6 +206,9 @@ func (h *AlbumHandler) RemovePhotoFromAlbum(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if albumWatermarkDisabled(parseUint(albumID)) {
+		enqueueWatermark(parseUint(photoID))
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Photo removed from album successfully"})
 }
@@ -284,6 +309,52 @@ func (h *AlbumHandler) RemovePassword(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "密码已移除"})
 }
 
+// SetWatermark 设置相册中的照片公开的图片是否加水印，变更后在后台重新生成相册中的照片
+//
+// 照片所在的任何一个相册关闭水印时，该照片都不加水印
+func (h *AlbumHandler) SetWatermark(c *gin.Context) {
+	var request struct {
+		WatermarkDisabled *bool `json:"watermark_disabled" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var album models.Album
+	if err := services.GetDB().First(&album, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+
+	if album.WatermarkDisabled != *request.WatermarkDisabled {
+		if err := services.GetDB().Model(&album).Update("watermark_disabled", *request.WatermarkDisabled).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		enqueueWatermark(albumPhotoIDs(album.ID)...)
+	}
+
+	c.JSON(http.StatusOK, album)
+}
+
+// albumPhotoIDs 返回相册中所有照片的 ID
+func albumPhotoIDs(albumID uint) []uint {
+	var ids []uint
+	services.GetDB().Model(&models.AlbumPhoto{}).Where("album_id = ?", albumID).Pluck("photo_id", &ids)
+	return ids
+}
+
+// albumWatermarkDisabled 相册是否关闭了水印，相册不存在时返回 false
+func albumWatermarkDisabled(albumID uint) bool {
+	var album models.Album
+	if err := services.GetDB().Select("watermark_disabled").First(&album, albumID).Error; err != nil {
+		return false
+	}
+	return album.WatermarkDisabled
+}
+
 func parseUint(s string) uint {
 	val, _ := strconv.ParseUint(s, 10, 32)
 	return uint(val)
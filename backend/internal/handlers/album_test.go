@@ -3,12 +3,15 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"image"
 	"net/http"
 	"net/http/httptest"
 	"picsite/internal/models"
 	"picsite/internal/services"
 	"picsite/internal/utils"
 	"testing"
+
+	"github.com/gin-gonic/gin"
 )
 
 func TestAlbumHandler_GetAll(t *testing.T) {
@@ -76,6 +79,45 @@ func TestAlbumHandler_GetAll(t *testing.T) {
 			t.Errorf("Expected total 3, got %v", pagination["total"])
 		}
 	})
+
+	t.Run("hide photo location for anonymous users", func(t *testing.T) {
+		lat, lng := 35.0, 139.0
+		photo := models.Photo{Title: "Located", FilePath: "/located.jpg", Latitude: &lat, Longitude: &lng}
+		db.Create(&photo)
+		var album models.Album
+		db.Where("name = ?", "Album 3").First(&album)
+		db.Create(&models.AlbumPhoto{AlbumID: album.ID, PhotoID: photo.ID})
+
+		get := func(router *gin.Engine) models.Photo {
+			req, _ := http.NewRequest(http.MethodGet, "/albums", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var response struct {
+				Data []models.Album `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			for _, a := range response.Data {
+				if a.ID == album.ID && len(a.Photos) == 1 {
+					return a.Photos[0]
+				}
+			}
+			t.Fatalf("Expected album with one photo, got %s", w.Body.String())
+			return models.Photo{}
+		}
+
+		// 默认抹去 GPS，未登录时不返回坐标
+		if response := get(router); response.Latitude != nil || response.Longitude != nil {
+			t.Errorf("Expected location to be hidden, got %v, %v", response.Latitude, response.Longitude)
+		} else if len(response.Renditions) == 0 {
+			t.Error("Expected renditions to be attached")
+		}
+
+		authed := setupAuthedTestRouter()
+		authed.GET("/albums", handler.GetAll)
+		if response := get(authed); response.Latitude == nil || *response.Latitude != lat {
+			t.Errorf("Expected location for authenticated user, got %v", response.Latitude)
+		}
+	})
 }
 
 func TestAlbumHandler_GetByID(t *testing.T) {
@@ -465,3 +507,99 @@ func TestAlbumHandler_RemovePhotoFromAlbum(t *testing.T) {
 		}
 	})
 }
+
+func TestAlbumHandler_SetWatermark(t *testing.T) {
+	original := services.ImageConfig
+	defer func() { services.ImageConfig = original }()
+	services.ImageConfig.Watermark.Mark = image.NewNRGBA(image.Rect(0, 0, 10, 10))
+
+	db := setupTestDB(t)
+	services.DB = db
+	setupTestQueue()
+	handler := NewAlbumHandler()
+	router := setupTestRouter()
+	router.PUT("/albums/:id", handler.Update)
+	router.PUT("/albums/:id/watermark", handler.SetWatermark)
+	router.POST("/albums/:id/photos", handler.AddPhotoToAlbum)
+
+	album := models.Album{Name: "Clients"}
+	db.Create(&album)
+	for i := 0; i < 2; i++ {
+		photo := models.Photo{Title: "Photo", FilePath: "/uploads/photo.jpg"}
+		db.Create(&photo)
+		db.Create(&models.AlbumPhoto{AlbumID: album.ID, PhotoID: photo.ID})
+	}
+	other := models.Photo{Title: "Other", FilePath: "/uploads/other.jpg"}
+	db.Create(&other)
+
+	put := func(url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	countJobs := func() int64 {
+		var count int64
+		db.Model(&models.Job{}).Where("type = ?", services.JobTypeWatermarkPhoto).Count(&count)
+		return count
+	}
+
+	t.Run("general update ignores watermark", func(t *testing.T) {
+		w := put("/albums/1", `{"name": "Clients", "watermark_disabled": true}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var updated models.Album
+		db.First(&updated, album.ID)
+		if updated.WatermarkDisabled {
+			t.Error("Expected watermark_disabled to be changed only through SetWatermark")
+		}
+	})
+
+	t.Run("disable watermark", func(t *testing.T) {
+		w := put("/albums/1/watermark", `{"watermark_disabled": true}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var updated models.Album
+		db.First(&updated, album.ID)
+		if !updated.WatermarkDisabled {
+			t.Error("Expected watermark to be disabled")
+		}
+		// 相册中的每张照片都需要重新生成
+		if count := countJobs(); count != 2 {
+			t.Errorf("Expected 2 watermark jobs, got %d", count)
+		}
+
+		// 设置没有变化时不重新生成
+		put("/albums/1/watermark", `{"watermark_disabled": true}`)
+		if count := countJobs(); count != 2 {
+			t.Errorf("Expected no new jobs, got %d", count)
+		}
+	})
+
+	t.Run("add photo to album without watermark", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]uint{"photo_id": other.ID})
+		req, _ := http.NewRequest(http.MethodPost, "/albums/1/photos", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if count := countJobs(); count != 3 {
+			t.Errorf("Expected a watermark job for the added photo, got %d jobs", count)
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		if w := put("/albums/1/watermark", `{}`); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		if w := put("/albums/999/watermark", `{"watermark_disabled": false}`); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
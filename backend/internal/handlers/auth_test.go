@@ -27,7 +27,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	}
 
 	// 自动迁移
-	err = db.AutoMigrate(&models.Photo{}, &models.Album{}, &models.User{}, &models.AlbumPhoto{})
+	err = services.AutoMigrate(db)
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"picsite/internal/models"
+	"picsite/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DuplicateHandler 近似重复照片处理器
+type DuplicateHandler struct{}
+
+// NewDuplicateHandler 创建近似重复照片处理器
+func NewDuplicateHandler() *DuplicateHandler {
+	return &DuplicateHandler{}
+}
+
+// GetAll 按感知哈希的汉明距离列出近似重复的照片组，threshold 为距离阈值（0-32）
+func (h *DuplicateHandler) GetAll(c *gin.Context) {
+	threshold := services.DefaultDuplicateThreshold
+	if value := c.Query("threshold"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > services.MaxDuplicateThreshold {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "threshold 必须在 0 到 32 之间"})
+			return
+		}
+		threshold = n
+	}
+
+	groups, err := services.FindNearDuplicates(threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ids []uint
+	for _, group := range groups {
+		ids = append(ids, group.PhotoIDs...)
+	}
+	photos := make(map[uint]models.Photo, len(ids))
+	if len(ids) > 0 {
+		var found []models.Photo
+		if err := services.GetDB().Where("id IN ?", ids).
+			Preload("Renditions", orderRenditions).Preload("Palette", orderPalette).
+			Find(&found).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		services.AttachRenditions(found)
+		if !isAuthenticated(c) {
+			services.RedactLocation(found)
+		}
+		for _, photo := range found {
+			photos[photo.ID] = photo
+		}
+	}
+
+	data := make([]gin.H, 0, len(groups))
+	for _, group := range groups {
+		members := make([]models.Photo, 0, len(group.PhotoIDs))
+		for _, id := range group.PhotoIDs {
+			members = append(members, photos[id])
+		}
+		data = append(data, gin.H{"distance": group.MaxDistance, "photos": members})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"threshold": threshold,
+		"total":     len(groups),
+		"data":      data,
+	})
+}
+
+// Merge 合并近似重复的照片：保留 keep_id，把 photo_ids 中照片的相册和标签转移过来后删除这些照片
+func (h *DuplicateHandler) Merge(c *gin.Context) {
+	var request struct {
+		KeepID   uint   `json:"keep_id" binding:"required"`
+		PhotoIDs []uint `json:"photo_ids" binding:"required,min=1,max=100"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误，最多支持合并100张照片"})
+		return
+	}
+	if slices.Contains(request.PhotoIDs, request.KeepID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "photo_ids 不能包含保留的照片"})
+		return
+	}
+	slices.Sort(request.PhotoIDs)
+	request.PhotoIDs = slices.Compact(request.PhotoIDs)
+
+	keep, merged, err := services.MergePhotos(request.KeepID, request.PhotoIDs)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并失败"})
+		return
+	}
+
+	// 删除被合并照片的文件
+	for _, photo := range merged {
+		deletePhotoFiles(photo)
+	}
+	// 保留的照片所在的相册可能变化，水印设置随之变化
+	enqueueWatermark(keep.ID)
+
+	// 与其他接口一样返回尺寸版本和调色板
+	if err := services.GetDB().Preload("Renditions", orderRenditions).Preload("Palette", orderPalette).
+		First(keep, keep.ID).Error; err != nil {
+		fmt.Printf("Failed to reload merged photo %d: %v\n", keep.ID, err)
+	}
+	services.AttachPhotoRenditions(keep)
+	if !isAuthenticated(c) {
+		services.RedactPhotoLocation(keep)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "合并成功",
+		"merged":  len(merged),
+		"photo":   keep,
+	})
+}
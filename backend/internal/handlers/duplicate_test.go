@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"picsite/internal/models"
+	"picsite/internal/services"
+)
+
+func TestDuplicateHandler(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	root := setupTestStorage(t)
+	handler := NewDuplicateHandler()
+	router := setupTestRouter()
+	router.GET("/admin/duplicates", handler.GetAll)
+	router.POST("/admin/duplicates/merge", handler.Merge)
+
+	lat, lng := 31.23, 121.47
+	photos := []models.Photo{
+		{Title: "Original", FilePath: "/uploads/a.jpg", FileKey: "a.jpg", PerceptualHash: "00000000000000ff", Tags: "travel,night",
+			Latitude: &lat, Longitude: &lng},
+		{Title: "Re-export", FilePath: "/uploads/b.jpg", FileKey: "b.jpg", PerceptualHash: "00000000000000fe", Tags: "night,city"},
+		{Title: "Crop", FilePath: "/uploads/c.jpg", FileKey: "c.jpg", PerceptualHash: "00000000000003ff"},
+		{Title: "Other", FilePath: "/uploads/d.jpg", FileKey: "d.jpg", PerceptualHash: "ffffffffffff0000"},
+		{Title: "Unprocessed", FilePath: "/uploads/e.jpg", FileKey: "e.jpg"},
+	}
+	for i := range photos {
+		db.Create(&photos[i])
+		os.WriteFile(filepath.Join(root, photos[i].FileKey), []byte("image"), 0o644)
+	}
+
+	albums := []models.Album{{Name: "Trip"}, {Name: "Best"}}
+	for i := range albums {
+		db.Create(&albums[i])
+	}
+	db.Create(&models.AlbumPhoto{AlbumID: albums[0].ID, PhotoID: photos[0].ID})
+	db.Create(&models.AlbumPhoto{AlbumID: albums[0].ID, PhotoID: photos[1].ID})
+	db.Create(&models.AlbumPhoto{AlbumID: albums[1].ID, PhotoID: photos[1].ID, SortOrder: 3})
+	db.Model(&albums[1]).Update("cover_photo_id", photos[1].ID)
+
+	type groupResponse struct {
+		Threshold int `json:"threshold"`
+		Data      []struct {
+			Distance int            `json:"distance"`
+			Photos   []models.Photo `json:"photos"`
+		} `json:"data"`
+	}
+	get := func(url string) (*httptest.ResponseRecorder, groupResponse) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response groupResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+	merge := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/duplicates/merge", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("group near duplicates", func(t *testing.T) {
+		w, response := get("/admin/duplicates")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if response.Threshold != services.DefaultDuplicateThreshold || len(response.Data) != 1 {
+			t.Fatalf("Expected one group at default threshold, got %+v", response)
+		}
+		group := response.Data[0]
+		if len(group.Photos) != 3 || group.Photos[0].ID != photos[0].ID || group.Photos[2].ID != photos[2].ID || group.Distance != 3 {
+			t.Errorf("Unexpected group: %+v", group)
+		}
+		// 与其他列表接口一样附带尺寸版本并隐藏拍摄位置
+		if len(group.Photos[0].Renditions) == 0 {
+			t.Error("Expected renditions on grouped photos")
+		}
+		if group.Photos[0].Latitude != nil || group.Photos[0].Longitude != nil {
+			t.Errorf("Expected location to be redacted, got %v, %v", group.Photos[0].Latitude, group.Photos[0].Longitude)
+		}
+	})
+
+	t.Run("threshold narrows groups", func(t *testing.T) {
+		_, response := get("/admin/duplicates?threshold=1")
+		if len(response.Data) != 1 || len(response.Data[0].Photos) != 2 {
+			t.Errorf("Expected only the re-export pair, got %+v", response.Data)
+		}
+		if w, _ := get("/admin/duplicates?threshold=64"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for invalid threshold, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("reject invalid merge", func(t *testing.T) {
+		if w := merge(`{"keep_id": 1, "photo_ids": [1, 2]}`); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d when keeping a merged photo, got %d", http.StatusBadRequest, w.Code)
+		}
+		if w := merge(`{"keep_id": 1, "photo_ids": [999]}`); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for missing photo, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("merge keeps one photo", func(t *testing.T) {
+		w := merge(`{"keep_id": 1, "photo_ids": [2, 2]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var response struct {
+			Photo models.Photo `json:"photo"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if len(response.Photo.Renditions) == 0 || response.Photo.Latitude != nil {
+			t.Errorf("Expected renditions and redacted location on kept photo, got %+v", response.Photo)
+		}
+
+		var keep models.Photo
+		db.First(&keep, photos[0].ID)
+		if keep.Tags != "travel,night,city" {
+			t.Errorf("Expected merged tags, got %q", keep.Tags)
+		}
+		if err := db.First(&models.Photo{}, photos[1].ID).Error; err == nil {
+			t.Error("Expected merged photo to be deleted")
+		}
+		if _, err := os.Stat(filepath.Join(root, photos[1].FileKey)); !os.IsNotExist(err) {
+			t.Errorf("Expected merged photo file to be deleted, got %v", err)
+		}
+
+		var memberships []models.AlbumPhoto
+		db.Order("album_id").Find(&memberships)
+		if len(memberships) != 2 ||
+			memberships[0] != (models.AlbumPhoto{AlbumID: albums[0].ID, PhotoID: keep.ID}) ||
+			memberships[1] != (models.AlbumPhoto{AlbumID: albums[1].ID, PhotoID: keep.ID, SortOrder: 3}) {
+			t.Errorf("Expected album memberships to move to kept photo, got %+v", memberships)
+		}
+		var album models.Album
+		db.First(&album, albums[1].ID)
+		if album.CoverPhotoID == nil || *album.CoverPhotoID != keep.ID {
+			t.Errorf("Expected album cover to move to kept photo, got %v", album.CoverPhotoID)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"picsite/internal/models"
+	"picsite/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageHandler 按需生成图片处理器
+type ImageHandler struct{}
+
+// NewImageHandler 创建按需生成图片处理器
+func NewImageHandler() *ImageHandler {
+	return &ImageHandler{}
+}
+
+// Serve 按签名参数生成指定尺寸和格式的图片，结果缓存在存储后端
+//
+// GET /img/:id?w=&h=&fit=&fmt=&q=&v=&s=
+func (h *ImageHandler) Serve(c *gin.Context) {
+	photoID := parseUint(c.Param("id"))
+
+	params, err := services.ParseImageParams(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "图片参数错误"})
+		return
+	}
+
+	if !services.VerifyImageSignature(photoID, params, c.Query("s")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "图片签名无效"})
+		return
+	}
+
+	// 未处理完成或处理失败的照片不公开，与 /uploads 一致
+	var photo models.Photo
+	if err := services.GetDB().First(&photo, photoID).Error; err != nil || photo.ProcessingStatus != models.PhotoStatusReady {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+
+	// 照片的图片已重新生成（如水印变更），旧地址跳转到当前版本
+	if params.Version != photo.RenditionVersion {
+		params.Version = photo.RenditionVersion
+		c.Redirect(http.StatusFound, services.ImageURL(photo.ID, params))
+		return
+	}
+
+	store := services.GetStorage()
+	cacheKey := params.CacheKey(photoID)
+
+	// 优先返回缓存
+	data, err := services.ReadObject(store, cacheKey)
+	if err != nil {
+		if !errors.Is(err, services.ErrObjectNotFound) {
+			fmt.Printf("Failed to read cached image: %v\n", err)
+		}
+
+		data, err = services.RenderImage(store, services.ResolveStorageKey(photo.FileKey, photo.FilePath), params, services.WatermarkEnabled(photo))
+		if err != nil {
+			fmt.Printf("Failed to render image: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成图片失败"})
+			return
+		}
+
+		if err := store.Put(cacheKey, bytes.NewReader(data), int64(len(data)), services.FormatContentType(params.Format)); err != nil {
+			fmt.Printf("Failed to cache image: %v\n", err)
+		}
+	}
+
+	// 签名参数包含图片版本，相同地址的结果不会变化，可以长期缓存
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Data(http.StatusOK, services.FormatContentType(params.Format), data)
+}
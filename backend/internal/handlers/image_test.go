@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"picsite/internal/models"
+	"picsite/internal/services"
+)
+
+func TestImageHandler_Serve(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	root := setupTestStorage(t)
+	services.ImageConfig.SigningKey = "test-signing-key"
+	handler := NewImageHandler()
+	router := setupTestRouter()
+	router.GET("/img/:id", handler.Serve)
+
+	data := testImageJPEG(t, 800, 600)
+	if err := os.WriteFile(filepath.Join(root, "original.jpg"), data, 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+	photo := models.Photo{Title: "Original", FilePath: "/uploads/original.jpg", FileKey: "original.jpg"}
+	db.Create(&photo)
+
+	params := services.ImageParams{Width: 200}
+
+	t.Run("serve signed image", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, services.ImageURL(photo.ID, params), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+			t.Errorf("Expected image/jpeg, got %s", ct)
+		}
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if cfg.Width != 200 || cfg.Height != 150 {
+			t.Errorf("Expected 200x150, got %dx%d", cfg.Width, cfg.Height)
+		}
+
+		// 结果应写入缓存
+		cacheKey := services.ImageParams{Width: 200, Fit: services.FitInside, Format: services.FormatJPEG}.CacheKey(photo.ID)
+		if _, err := os.Stat(filepath.Join(root, cacheKey)); err != nil {
+			t.Errorf("Expected cached image at %s: %v", cacheKey, err)
+		}
+	})
+
+	t.Run("serve from cache after original removed", func(t *testing.T) {
+		os.Remove(filepath.Join(root, "original.jpg"))
+
+		req, _ := http.NewRequest(http.MethodGet, services.ImageURL(photo.ID, params), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected cached response with status %d, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("redirect stale version", func(t *testing.T) {
+		// 水印变更等重新生成后，旧地址跳转到当前版本，不再返回旧的缓存
+		db.Model(&photo).Update("rendition_version", 2)
+		req, _ := http.NewRequest(http.MethodGet, services.ImageURL(photo.ID, services.ImageParams{Width: 300, Version: 1}), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusFound, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != services.ImageURL(photo.ID, services.ImageParams{Width: 300, Version: 2}) {
+			t.Errorf("Expected redirect to current version, got %s", location)
+		}
+	})
+
+	t.Run("unprocessed photo", func(t *testing.T) {
+		for _, status := range []string{models.PhotoStatusPending, models.PhotoStatusFailed} {
+			pending := models.Photo{Title: "Pending", FileKey: "original.jpg", ProcessingStatus: status}
+			db.Create(&pending)
+
+			req, _ := http.NewRequest(http.MethodGet, services.ImageURL(pending.ID, params), nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Expected status %d for %s photo, got %d", http.StatusNotFound, status, w.Code)
+			}
+		}
+	})
+
+	t.Run("reject invalid signature", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/img/1?w=1600&s=forged", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+	})
+
+	t.Run("reject invalid parameters", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/img/1?w=abc", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("non-existent photo", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, services.ImageURL(999, params), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"picsite/internal/models"
+	"picsite/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobHandler 后台任务处理器
+type JobHandler struct{}
+
+// NewJobHandler 创建后台任务处理器
+func NewJobHandler() *JobHandler {
+	return &JobHandler{}
+}
+
+// GetAll 获取任务列表，支持按 status、type、photo_id 筛选
+func (h *JobHandler) GetAll(c *gin.Context) {
+	var jobs []models.Job
+
+	query := services.GetDB().Model(&models.Job{})
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if jobType := c.Query("type"); jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+	if photoID := c.Query("photo_id"); photoID != "" {
+		query = query.Where("photo_id = ?", photoID)
+	}
+
+	query = query.Order("id DESC")
+
+	// 分页
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	offset := (page - 1) * pageSize
+
+	var total int64
+	query.Count(&total)
+
+	if err := query.Offset(offset).Limit(pageSize).Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": jobs,
+		"pagination": gin.H{
+			"page":       page,
+			"page_size":  pageSize,
+			"total":      total,
+			"total_page": (total + int64(pageSize) - 1) / int64(pageSize),
+		},
+	})
+}
+
+// GetByID 获取单个任务
+func (h *JobHandler) GetByID(c *gin.Context) {
+	var job models.Job
+	if err := services.GetDB().First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Retry 重新执行失败的任务
+func (h *JobHandler) Retry(c *gin.Context) {
+	job, err := services.GetJobQueue().Retry(parseUint(c.Param("id")))
+	if errors.Is(err, services.ErrJobNotRetryable) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "只能重试失败的任务"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 照片重新回到等待处理状态
+	if job.Type == services.JobTypeProcessPhoto {
+		services.GetDB().Model(&models.Photo{}).Where("id = ?", job.PhotoID).
+			Update("processing_status", models.PhotoStatusPending)
+	}
+
+	c.JSON(http.StatusOK, job)
+}
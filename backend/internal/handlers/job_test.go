@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"picsite/internal/models"
+	"picsite/internal/services"
+)
+
+func TestJobHandler(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	setupTestQueue()
+	handler := NewJobHandler()
+	router := setupTestRouter()
+	router.GET("/jobs", handler.GetAll)
+	router.GET("/jobs/:id", handler.GetByID)
+	router.POST("/jobs/:id/retry", handler.Retry)
+
+	photo := models.Photo{Title: "Broken", FilePath: "/broken.jpg", ProcessingStatus: models.PhotoStatusFailed}
+	db.Create(&photo)
+
+	now := time.Now()
+	failed := models.Job{Type: services.JobTypeProcessPhoto, PhotoID: photo.ID, Status: models.JobStatusFailed, Attempts: 3, MaxAttempts: 3, LastError: "boom", RunAt: now}
+	done := models.Job{Type: services.JobTypeProcessPhoto, PhotoID: 99, Status: models.JobStatusDone, Attempts: 1, MaxAttempts: 3, RunAt: now}
+	db.Create(&failed)
+	db.Create(&done)
+
+	t.Run("list jobs filtered by status", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/jobs?status=failed", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var response struct {
+			Data []models.Job `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if len(response.Data) != 1 || response.Data[0].ID != failed.ID || response.Data[0].LastError != "boom" {
+			t.Errorf("Expected only the failed job, got %+v", response.Data)
+		}
+	})
+
+	t.Run("get job by id", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/jobs/"+strconv.Itoa(int(done.ID)), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+
+		req, _ = http.NewRequest(http.MethodGet, "/jobs/9999", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("retry failed job", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/jobs/"+strconv.Itoa(int(failed.ID))+"/retry", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var job models.Job
+		db.First(&job, failed.ID)
+		if job.Status != models.JobStatusPending || job.Attempts != 0 {
+			t.Errorf("Expected job to be pending again, got %+v", job)
+		}
+
+		db.First(&photo, photo.ID)
+		if photo.ProcessingStatus != models.PhotoStatusPending {
+			t.Errorf("Expected photo to be pending again, got %s", photo.ProcessingStatus)
+		}
+	})
+
+	t.Run("retry job that has not failed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/jobs/"+strconv.Itoa(int(done.ID))+"/retry", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
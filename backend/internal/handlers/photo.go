@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
 	"picsite/internal/models"
 	"picsite/internal/services"
@@ -13,8 +19,16 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
 )
 
+// squareTolerance 宽高比与 1 的差值在此范围内的照片视为方图
+const squareTolerance = 0.01
+
+// maxSidecarSize .xmp 附属文件的大小限制
+const maxSidecarSize = 1 << 20
+
 type PhotoHandler struct{}
 
 func NewPhotoHandler() *PhotoHandler {
@@ -48,6 +62,11 @@ func (h *PhotoHandler) GetAll(c *gin.Context) {
 		query = query.Where("location LIKE ?", "%"+location+"%")
 	}
 
+	// 按颜色标签筛选，不区分大小写
+	if label := c.Query("label"); label != "" {
+		query = query.Where("LOWER(label) = LOWER(?)", label)
+	}
+
 	if year := c.Query("year"); year != "" {
 		query = query.Where("year = ?", year)
 	}
@@ -56,6 +75,92 @@ func (h *PhotoHandler) GetAll(c *gin.Context) {
 		query = query.Where("camera_model LIKE ?", "%"+camera+"%")
 	}
 
+	if cameraMake := c.Query("make"); cameraMake != "" {
+		query = query.Where("camera_make LIKE ?", "%"+cameraMake+"%")
+	}
+
+	if lensMake := c.Query("lens_make"); lensMake != "" {
+		query = query.Where("lens_make LIKE ?", "%"+lensMake+"%")
+	}
+
+	// 按调色板颜色筛选（color=#rrggbb，tolerance 为允许的色差 ΔE）
+	if value := c.Query("color"); value != "" {
+		r, g, b, err := services.ParseHexColor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "color 格式错误，应为 #rrggbb"})
+			return
+		}
+		tolerance := services.DefaultColorTolerance
+		if value := c.Query("tolerance"); value != "" {
+			tolerance, err = strconv.ParseFloat(value, 64)
+			if err != nil || !(tolerance >= 0 && tolerance <= services.MaxColorTolerance) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance 必须在 0 到 100 之间"})
+				return
+			}
+		}
+		condition, args := services.PaletteColorCondition(r, g, b, tolerance)
+		query = query.Where(condition, args...)
+	}
+
+	// 筛选缩略图和各尺寸版本经过 sRGB 转换的照片
+	if converted := c.Query("color_converted"); converted != "" {
+		query = query.Where("color_converted = ?", converted == "true")
+	}
+
+	// 按拍摄参数精确筛选
+	for _, column := range []string{"flash", "metering_mode", "white_balance", "exposure_program"} {
+		if value := c.Query(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+
+	// 按焦距范围筛选（毫米），优先使用等效 35mm 焦距
+	for param, op := range map[string]string{"focal_min": ">=", "focal_max": "<="} {
+		if value := c.Query(param); value != "" {
+			focal, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param + " 参数错误"})
+				return
+			}
+			query = query.Where("COALESCE(NULLIF(focal_length_35mm, 0), focal_length) "+op+" ?", focal)
+		}
+	}
+
+	// 按曝光补偿筛选（EV），允许 ±0.05 的误差以匹配 1/3 EV 的取值
+	if value := c.Query("exposure_bias"); value != "" {
+		bias, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "exposure_bias 参数错误"})
+			return
+		}
+		query = query.Where("exposure_bias BETWEEN ? AND ?", bias-0.05, bias+0.05)
+	}
+
+	// 按画面方向筛选（宽高比在 1±squareTolerance 内视为方图，未知尺寸的照片不参与）
+	switch c.Query("orientation") {
+	case "landscape":
+		query = query.Where("aspect_ratio > ?", 1+squareTolerance)
+	case "portrait":
+		query = query.Where("aspect_ratio > 0 AND aspect_ratio < ?", 1-squareTolerance)
+	case "square":
+		query = query.Where("aspect_ratio BETWEEN ? AND ?", 1-squareTolerance, 1+squareTolerance)
+	}
+
+	// 按地图范围筛选（bbox=minLng,minLat,maxLng,maxLat）
+	if bbox := c.Query("bbox"); bbox != "" {
+		b, err := services.ParseBBox(bbox)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bbox 格式错误，应为 minLng,minLat,maxLng,maxLat"})
+			return
+		}
+		query = applyBBox(query, b)
+		if !isAuthenticated(c) {
+			// 未登录用户只能按公开了拍摄位置的照片筛选，避免通过范围查询推断坐标
+			condition, args := services.PublicLocationCondition()
+			query = query.Where(condition, args...)
+		}
+	}
+
 	// 排序
 	query = query.Order("created_at DESC")
 
@@ -67,10 +172,14 @@ func (h *PhotoHandler) GetAll(c *gin.Context) {
 	var total int64
 	query.Count(&total)
 
-	if err := query.Offset(offset).Limit(pageSize).Find(&photos).Error; err != nil {
+	if err := query.Offset(offset).Limit(pageSize).Preload("Renditions", orderRenditions).Preload("Palette", orderPalette).Find(&photos).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	services.AttachRenditions(photos)
+	if !isAuthenticated(c) {
+		services.RedactLocation(photos)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": photos,
@@ -83,18 +192,141 @@ func (h *PhotoHandler) GetAll(c *gin.Context) {
 	})
 }
 
+// Map 返回带 GPS 坐标的照片位置，用于地图展示
+//
+// 可选参数 bbox 限制范围，zoom 指定地图缩放级别时按网格聚合相邻的照片
+func (h *PhotoHandler) Map(c *gin.Context) {
+	query := services.GetDB().Model(&models.Photo{}).
+		Where("latitude IS NOT NULL AND longitude IS NOT NULL")
+
+	if bbox := c.Query("bbox"); bbox != "" {
+		b, err := services.ParseBBox(bbox)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bbox 格式错误，应为 minLng,minLat,maxLng,maxLat"})
+			return
+		}
+		query = applyBBox(query, b)
+	}
+
+	// 未登录用户只能看到公开了拍摄位置的照片
+	if !isAuthenticated(c) {
+		condition, args := services.PublicLocationCondition()
+		query = query.Where(condition, args...)
+	}
+
+	points := []services.GeoPoint{}
+	if err := query.Order("id ASC").
+		Select("id, title, latitude, longitude, thumbnail_path, dominant_color").
+		Find(&points).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	clusters := []services.GeoCluster{}
+	if zoomParam := c.Query("zoom"); zoomParam != "" {
+		zoom, err := strconv.Atoi(zoomParam)
+		if err != nil || zoom < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "zoom 参数错误"})
+			return
+		}
+		clusters, points = services.ClusterGeoPoints(points, zoom)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusters": clusters,
+		"points":   points,
+		"total":    len(points) + clusterTotal(clusters),
+	})
+}
+
+func clusterTotal(clusters []services.GeoCluster) int {
+	total := 0
+	for _, cluster := range clusters {
+		total += cluster.Count
+	}
+	return total
+}
+
+// applyBBox 只保留坐标在边界框内的照片，支持跨越 180 度经线的范围
+func applyBBox(query *gorm.DB, b services.BBox) *gorm.DB {
+	query = query.Where("latitude BETWEEN ? AND ?", b.MinLat, b.MaxLat)
+	if b.CrossesAntimeridian() {
+		return query.Where("(longitude >= ? OR longitude <= ?)", b.MinLng, b.MaxLng)
+	}
+	return query.Where("longitude BETWEEN ? AND ?", b.MinLng, b.MaxLng)
+}
+
 func (h *PhotoHandler) GetByID(c *gin.Context) {
 	id := c.Param("id")
 	var photo models.Photo
 
-	if err := services.GetDB().First(&photo, id).Error; err != nil {
+	if err := services.GetDB().Preload("Renditions", orderRenditions).Preload("Palette", orderPalette).First(&photo, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
 		return
 	}
+	services.AttachPhotoRenditions(&photo)
+	if !isAuthenticated(c) {
+		services.RedactPhotoLocation(&photo)
+	}
 
 	c.JSON(http.StatusOK, photo)
 }
 
+// Similar 返回与照片在构图和颜色上最相似的公开照片，limit 为数量（默认 12，最多 50）
+func (h *PhotoHandler) Similar(c *gin.Context) {
+	limit := services.DefaultSimilarLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > services.MaxSimilarLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit 必须在 1 到 50 之间"})
+			return
+		}
+		limit = n
+	}
+
+	var photo models.Photo
+	if err := services.GetDB().First(&photo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+
+	similar, err := services.FindSimilarPhotos(photo, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := make([]uint, 0, len(similar))
+	for _, s := range similar {
+		ids = append(ids, s.ID)
+	}
+	var photos []models.Photo
+	if len(ids) > 0 {
+		if err := services.GetDB().Where("id IN ?", ids).Preload("Renditions", orderRenditions).Preload("Palette", orderPalette).Find(&photos).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		services.AttachRenditions(photos)
+		if !isAuthenticated(c) {
+			services.RedactLocation(photos)
+		}
+	}
+	byID := make(map[uint]models.Photo, len(photos))
+	for _, p := range photos {
+		byID[p.ID] = p
+	}
+
+	// 按相似度排序返回
+	data := make([]gin.H, 0, len(similar))
+	for _, s := range similar {
+		if p, ok := byID[s.ID]; ok {
+			data = append(data, gin.H{"score": math.Round(s.Score*1000) / 1000, "photo": p})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
 func (h *PhotoHandler) Create(c *gin.Context) {
 	// 解析表单数据
 	title := c.PostForm("title")
@@ -107,6 +339,9 @@ func (h *PhotoHandler) Create(c *gin.Context) {
 	aperture := c.PostForm("aperture")
 	shutterSpeed := c.PostForm("shutter_speed")
 	iso, _ := strconv.Atoi(c.PostForm("iso"))
+	tags := c.PostForm("tags")
+	author := c.PostForm("author")
+	copyright := c.PostForm("copyright")
 
 	// 处理文件上传
 	file, err := c.FormFile("file")
@@ -115,100 +350,141 @@ func (h *PhotoHandler) Create(c *gin.Context) {
 		return
 	}
 
-	// 验证文件类型
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	allowedExts := map[string]bool{
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".webp": true,
-	}
-	if !allowedExts[ext] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的文件类型，仅支持 jpg, jpeg, png, webp"})
+	// 按文件内容校验类型、大小和像素数，不信任扩展名和客户端声明的大小
+	data, info, err := readUpload(file)
+	if err != nil {
+		respondUploadError(c, err)
 		return
 	}
 
-	// 验证文件大小 (10MB)
-	maxSize := int64(10 * 1024 * 1024)
-	if file.Size > maxSize {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小超过限制 (最大 10MB)"})
+	// 可选的 .xmp 附属文件（Lightroom 等软件导出）
+	sidecar, err := c.FormFile("xmp")
+	if err != nil && err != http.ErrMissingFile {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取 XMP 文件失败"})
 		return
 	}
+	if sidecar != nil {
+		if strings.ToLower(filepath.Ext(sidecar.Filename)) != ".xmp" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "附属文件仅支持 .xmp"})
+			return
+		}
+		if sidecar.Size > maxSidecarSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "XMP 文件大小超过限制 (最大 1MB)"})
+			return
+		}
+	}
 
-	// 生成文件名
-	filename := fmt.Sprintf("%d_%s", time.Now().Unix(), file.Filename)
-	uploadPath := "./uploads/" + filename
-
-	// 确保上传目录存在
-	if err := os.MkdirAll("./uploads", os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload directory"})
+	// 内容完全相同的照片只保留一张
+	hash := services.ContentHash(data)
+	if existing, ok := findDuplicatePhoto(services.GetDB(), hash); ok {
+		respondDuplicate(c, existing)
 		return
 	}
 
+	// 原图按内容哈希分目录保存，文件名带有随机后缀，同名文件不会互相覆盖
+	key := services.ContentKey(hash, info.Ext)
+	store := services.GetStorage()
+
 	// 保存文件
-	if err := c.SaveUploadedFile(file, uploadPath); err != nil {
+	if err := store.Put(key, bytes.NewReader(data), int64(len(data)), info.MimeType); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
 	}
 
-	// 提取 EXIF 信息
-	exifData := services.ExtractEXIFFromUpload(uploadPath)
-
-	// 生成缩略图
-	thumbnailPath, err := services.GenerateThumbnailFromUpload(uploadPath)
-	if err != nil {
-		fmt.Printf("Failed to generate thumbnail: %v\n", err)
-		// 不阻止上传，继续保存记录
+	var sidecarKey string
+	if sidecar != nil {
+		sidecarKey = strings.TrimSuffix(key, filepath.Ext(key)) + ".xmp"
+		if err := saveUploadedFile(store, sidecar, sidecarKey); err != nil {
+			store.Delete(key)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			return
+		}
 	}
 
-	// 使用 EXIF 数据填充表单数据（如果表单中没有提供）
-	if cameraModel == "" && exifData.CameraModel != "" {
-		cameraModel = exifData.CameraModel
-	}
-	if lens == "" && exifData.Lens != "" {
-		lens = exifData.Lens
-	}
-	if aperture == "" && exifData.Aperture != "" {
-		aperture = exifData.Aperture
-	}
-	if shutterSpeed == "" && exifData.ShutterSpeed != "" {
-		shutterSpeed = exifData.ShutterSpeed
-	}
-	if iso == 0 && exifData.ISO != 0 {
-		iso = exifData.ISO
-	}
+	// 上传时填写的拍摄日期优先于 EXIF 中的日期
 	var shotDateValue *time.Time
-	if shotDate == "" && exifData.ShotDate != nil {
-		shotDateValue = exifData.ShotDate
-		if year == 0 {
-			year = exifData.ShotDate.Year()
+	if shotDate != "" {
+		if t, err := time.Parse("2006-01-02", shotDate); err == nil {
+			shotDateValue = &t
 		}
 	}
 
-	// 创建照片记录
+	// 创建照片记录，图片信息、EXIF、缩略图等由后台任务填充；
+	// 公开地址由后台任务按元数据和水印设置生成，处理完成前原图不对外提供
 	photo := models.Photo{
-		Title:         title,
-		Description:   description,
-		FilePath:      "/" + strings.TrimPrefix(uploadPath, "./"),
-		ThumbnailPath: "/" + strings.TrimPrefix(thumbnailPath, "./"),
-		Location:      location,
-		ShotDate:      shotDateValue,
-		Year:          year,
-		CameraModel:   cameraModel,
-		Lens:          lens,
-		Aperture:      aperture,
-		ShutterSpeed:  shutterSpeed,
-		ISO:           iso,
-	}
-
-	if err := services.GetDB().Create(&photo).Error; err != nil {
+		Title:            title,
+		Description:      description,
+		FileKey:          key,
+		SidecarKey:       sidecarKey,
+		ContentHash:      hash,
+		OriginalFilename: file.Filename,
+		FileSize:         int64(len(data)),
+		Location:         location,
+		ShotDate:         shotDateValue,
+		Year:             year,
+		CameraModel:      cameraModel,
+		Lens:             lens,
+		Aperture:         aperture,
+		ShutterSpeed:     shutterSpeed,
+		ISO:              iso,
+		Tags:             tags,
+		Author:           author,
+		Copyright:        copyright,
+		ProcessingStatus: models.PhotoStatusPending,
+	}
+
+	queue := services.GetJobQueue()
+	var duplicate *models.Photo
+	err = services.GetDB().Transaction(func(tx *gorm.DB) error {
+		// 同时上传相同文件时只保留先提交的一张
+		if existing, ok := findDuplicatePhoto(tx, hash); ok {
+			duplicate = &existing
+			return errDuplicatePhoto
+		}
+		if err := tx.Create(&photo).Error; err != nil {
+			return err
+		}
+		_, err := queue.EnqueueTx(tx, services.JobTypeProcessPhoto, photo.ID)
+		return err
+	})
+	if err != nil && duplicate == nil {
+		// 并发上传相同文件时由唯一索引拒绝后提交的一张
+		if existing, ok := findDuplicatePhoto(services.GetDB(), hash); ok {
+			duplicate = &existing
+		}
+	}
+	if err != nil {
+		// 每次上传的 key 都不同，重复上传时删除刚保存的文件不会影响已有照片
+		store.Delete(key)
+		if sidecarKey != "" {
+			store.Delete(sidecarKey)
+		}
+		if duplicate != nil {
+			respondDuplicate(c, *duplicate)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	queue.Notify()
+	services.AttachPhotoRenditions(&photo)
 
 	c.JSON(http.StatusCreated, photo)
 }
 
+// originalURLExpiry 原图临时下载地址的有效期
+const originalURLExpiry = 15 * time.Minute
+
+// editablePhotoFields 可以通过 Update 修改的字段，图片信息、处理状态和存储路径等由服务端生成，不允许修改
+var editablePhotoFields = []string{
+	"title", "description", "location", "author", "copyright", "tags", "rating", "label", "is_featured",
+	"latitude", "longitude", "altitude", "shot_date", "shot_date_offset", "year",
+	"camera_make", "camera_model", "lens_make", "lens", "focal_length", "focal_length_35mm",
+	"aperture", "shutter_speed", "iso", "exposure_bias", "exposure_program", "metering_mode",
+	"white_balance", "flash", "metadata_privacy",
+}
+
+// Update 更新照片信息，只修改请求中出现的可编辑字段（可以把字段设为空值）
 func (h *PhotoHandler) Update(c *gin.Context) {
 	id := c.Param("id")
 	var photo models.Photo
@@ -219,17 +495,100 @@ func (h *PhotoHandler) Update(c *gin.Context) {
 	}
 
 	var updateData models.Photo
-	if err := c.ShouldBindJSON(&updateData); err != nil {
+	var fields map[string]json.RawMessage
+	if err := c.ShouldBindBodyWith(&updateData, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := c.ShouldBindBodyWith(&fields, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if updateData.Rating < -1 || updateData.Rating > 5 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rating 只能是 -1 到 5"})
+		return
+	}
+	if _, ok := services.ParseUTCOffset(updateData.ShotDateOffset); updateData.ShotDateOffset != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shot_date_offset 格式错误，例如 \"+08:00\""})
+		return
+	}
+	if updateData.MetadataPrivacy != "" && !services.IsValidMetadataPrivacy(updateData.MetadataPrivacy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metadata_privacy 只能是 keep、strip_gps 或 strip_all"})
+		return
+	}
+
+	var columns []string
+	for _, field := range editablePhotoFields {
+		if _, ok := fields[field]; ok {
+			columns = append(columns, field)
+		}
+	}
+
+	// 客户端可能把获取到的整张照片提交回来，只更新可编辑的字段；水印开关通过 SetWatermark 修改
+	privacy := services.EffectiveMetadataPrivacy(photo)
+	if len(columns) > 0 {
+		if err := services.GetDB().Model(&photo).Select(columns).Updates(&updateData).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		services.GetDB().First(&photo, photo.ID)
+	}
+
+	// 元数据设置变更后重新生成公开的原图，尚未处理完成的照片处理时会使用新的设置
+	if photo.ProcessingStatus == models.PhotoStatusReady && services.EffectiveMetadataPrivacy(photo) != privacy {
+		updates, err := services.PublishOriginal(services.GetStorage(), photo)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新公开原图失败: " + err.Error()})
+			return
+		}
+		services.GetDB().Model(&photo).Updates(updates)
+	}
+
+	c.JSON(http.StatusOK, photo)
+}
+
+// SetWatermark 设置照片公开的图片是否加水印，变更后在后台重新生成尺寸版本和公开的原图
+func (h *PhotoHandler) SetWatermark(c *gin.Context) {
+	var request struct {
+		WatermarkDisabled *bool `json:"watermark_disabled" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
 
-	// 更新字段
-	services.GetDB().Model(&photo).Updates(updateData)
+	var photo models.Photo
+	if err := services.GetDB().First(&photo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+
+	if photo.WatermarkDisabled != *request.WatermarkDisabled {
+		if err := services.GetDB().Model(&photo).Update("watermark_disabled", *request.WatermarkDisabled).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		enqueueWatermark(photo.ID)
+	}
 
 	c.JSON(http.StatusOK, photo)
 }
 
+// enqueueWatermark 照片的水印设置变更后在后台重新生成公开的图片，未配置水印时不需要处理
+func enqueueWatermark(photoIDs ...uint) {
+	if !services.WatermarkConfigured() || len(photoIDs) == 0 {
+		return
+	}
+	queue := services.GetJobQueue()
+	for _, id := range photoIDs {
+		if _, err := queue.Enqueue(services.JobTypeWatermarkPhoto, id); err != nil {
+			fmt.Printf("Failed to enqueue watermark job for photo %d: %v\n", id, err)
+		}
+	}
+	queue.Notify()
+}
+
 func (h *PhotoHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 	var photo models.Photo
@@ -239,14 +598,8 @@ func (h *PhotoHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	// 删除文件
-	if photo.FilePath != "" {
-		filePath := "." + photo.FilePath
-		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-			// 文件删除失败，但继续删除数据库记录
-			fmt.Printf("Failed to delete file: %v\n", err)
-		}
-	}
+	// 删除文件（失败时继续删除数据库记录）
+	deletePhotoFiles(photo)
 
 	// 删除数据库记录
 	if err := services.GetDB().Delete(&photo).Error; err != nil {
@@ -277,18 +630,7 @@ func (h *PhotoHandler) BatchDelete(c *gin.Context) {
 
 	// 删除文件
 	for _, photo := range photos {
-		if photo.FilePath != "" {
-			filePath := "." + photo.FilePath
-			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-				fmt.Printf("Failed to delete file: %v\n", err)
-			}
-		}
-		if photo.ThumbnailPath != "" {
-			thumbnailPath := "." + photo.ThumbnailPath
-			if err := os.Remove(thumbnailPath); err != nil && !os.IsNotExist(err) {
-				fmt.Printf("Failed to delete thumbnail: %v\n", err)
-			}
-		}
+		deletePhotoFiles(photo)
 	}
 
 	// 批量删除数据库记录
@@ -358,6 +700,81 @@ func (h *PhotoHandler) BatchUpdateFeatured(c *gin.Context) {
 	})
 }
 
+// BatchShiftDates 批量调整拍摄时间，用于修正相机时钟或时区设置错误的照片
+//
+// shift 为 Go 时长格式（如 "-1h30m"），拍摄时间整体平移；offset 为拍摄时区（如 "+09:00"），
+// 保持本地时间不变，只修改时区。两者同时指定时先平移再设置时区，没有拍摄时间的照片会被跳过
+func (h *PhotoHandler) BatchShiftDates(c *gin.Context) {
+	var request struct {
+		IDs    []uint `json:"ids" binding:"required,min=1,max=100"`
+		Shift  string `json:"shift"`
+		Offset string `json:"offset"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var shift time.Duration
+	if request.Shift != "" {
+		d, err := time.ParseDuration(request.Shift)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "shift 格式错误，例如 \"-1h30m\""})
+			return
+		}
+		shift = d
+	}
+	var zone *time.Location
+	if request.Offset != "" {
+		offset, ok := services.ParseUTCOffset(request.Offset)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset 格式错误，例如 \"+08:00\""})
+			return
+		}
+		zone = time.FixedZone("", offset)
+	}
+	if shift == 0 && zone == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shift 和 offset 至少需要指定一个"})
+		return
+	}
+
+	updated := 0
+	err := services.GetDB().Transaction(func(tx *gorm.DB) error {
+		var photos []models.Photo
+		if err := tx.Where("id IN ? AND shot_date IS NOT NULL", request.IDs).Find(&photos).Error; err != nil {
+			return err
+		}
+
+		for _, photo := range photos {
+			shotDate := photo.ShotDate.Add(shift)
+			updates := map[string]interface{}{}
+			if zone != nil {
+				shotDate = time.Date(shotDate.Year(), shotDate.Month(), shotDate.Day(),
+					shotDate.Hour(), shotDate.Minute(), shotDate.Second(), shotDate.Nanosecond(), zone)
+				updates["shot_date_offset"] = request.Offset
+			}
+			updates["shot_date"] = shotDate
+			updates["year"] = shotDate.Year()
+
+			if err := tx.Model(&photo).Updates(updates).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "批量调整拍摄时间成功",
+		"updated": updated,
+	})
+}
+
 func (h *PhotoHandler) IncrementView(c *gin.Context) {
 	id := c.Param("id")
 
@@ -378,72 +795,269 @@ func (h *PhotoHandler) UploadFile(c *gin.Context) {
 		return
 	}
 
-	// 验证文件类型
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	allowedExts := map[string]bool{
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".webp": true,
-	}
-	if !allowedExts[ext] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的文件类型，仅支持 jpg, jpeg, png, webp"})
+	// 按文件内容校验类型、大小和像素数，不信任扩展名和客户端声明的大小
+	data, info, err := readUpload(file)
+	if err != nil {
+		respondUploadError(c, err)
 		return
 	}
 
-	// 验证文件大小 (10MB)
-	maxSize := int64(10 * 1024 * 1024)
-	if file.Size > maxSize {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小超过限制 (最大 10MB)"})
+	// 文件直接公开，按全局设置先处理元数据
+	data, ext, err := services.ScrubUpload(data, info.Ext)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to process image"})
 		return
 	}
+	mimeType := info.MimeType
+	if ext != info.Ext {
+		mimeType = http.DetectContentType(data)
+	}
 
-	// 生成文件名
-	filename := fmt.Sprintf("%d_%s", time.Now().Unix(), file.Filename)
-	uploadPath := "./uploads/" + filename
+	// 按内容哈希保存，重复上传相同的文件返回同一个地址
+	hash := services.ContentHash(data)
+	key := services.FileContentKey(hash, ext)
+	store := services.GetStorage()
 
-	// 确保上传目录存在
-	if err := os.MkdirAll("./uploads", os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload directory"})
-		return
+	// 保存文件
+	if _, err := store.Stat(key); err != nil {
+		if err := store.Put(key, bytes.NewReader(data), int64(len(data)), mimeType); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			return
+		}
 	}
 
-	// 保存文件
-	dst, err := os.Create(uploadPath)
+	c.JSON(http.StatusOK, gin.H{
+		"file_path":     store.URL(key),
+		"original_name": file.Filename,
+		"content_hash":  hash,
+		"size":          len(data),
+		"mime_type":     mimeType,
+		"width":         info.Width,
+		"height":        info.Height,
+	})
+}
+
+// readUpload 读取并校验上传的图片，见 services.ReadUpload
+func readUpload(file *multipart.FileHeader) ([]byte, *services.UploadInfo, error) {
+	src, err := file.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
+		return nil, nil, err
+	}
+	defer src.Close()
+
+	return services.ReadUpload(src, file.Filename)
+}
+
+// errDuplicatePhoto 内容相同的照片已存在
+var errDuplicatePhoto = errors.New("duplicate photo")
+
+// findDuplicatePhoto 查找原图内容哈希相同的照片
+func findDuplicatePhoto(db *gorm.DB, hash string) (models.Photo, bool) {
+	var photo models.Photo
+	err := db.Where("content_hash = ?", hash).First(&photo).Error
+	return photo, err == nil
+}
+
+// respondDuplicate 返回 409，指向已存在的照片
+func respondDuplicate(c *gin.Context, existing models.Photo) {
+	services.AttachPhotoRenditions(&existing)
+	c.JSON(http.StatusConflict, gin.H{
+		"error":    fmt.Sprintf("相同的照片已存在 (ID %d)", existing.ID),
+		"code":     services.UploadErrDuplicate,
+		"photo_id": existing.ID,
+		"photo":    existing,
+	})
+}
+
+// respondUploadError 返回上传校验失败的原因，校验错误带有 code 字段
+func respondUploadError(c *gin.Context, err error) {
+	var uploadErr *services.UploadError
+	if errors.As(err, &uploadErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": uploadErr.Message, "code": uploadErr.Code})
 		return
 	}
-	defer dst.Close()
+	c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败"})
+}
 
+// saveUploadedFile 将上传的文件写入存储后端
+func saveUploadedFile(store services.Storage, file *multipart.FileHeader, key string) error {
 	src, err := file.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
-		return
+		return err
 	}
 	defer src.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(file.Filename)))
+	return store.Put(key, src, file.Size, contentType)
+}
+
+// orderRenditions 按宽度升序加载尺寸版本
+func orderRenditions(db *gorm.DB) *gorm.DB {
+	return db.Order("width ASC")
+}
+
+// orderPalette 按占比从高到低加载调色板
+func orderPalette(db *gorm.DB) *gorm.DB {
+	return db.Order("position ASC")
+}
+
+// deletePhotoFiles 从存储后端删除照片原图、缩略图和尺寸版本，并删除对应的尺寸版本和元数据记录
+func deletePhotoFiles(photo models.Photo) {
+	store := services.GetStorage()
+
+	services.GetDB().Where("photo_id = ?", photo.ID).Delete(&models.PhotoMetadata{})
+
+	var renditions []models.PhotoRendition
+	services.GetDB().Where("photo_id = ?", photo.ID).Find(&renditions)
+	for _, rendition := range renditions {
+		if err := store.Delete(rendition.Key); err != nil {
+			fmt.Printf("Failed to delete rendition: %v\n", err)
+		}
+	}
+	services.GetDB().Where("photo_id = ?", photo.ID).Delete(&models.PhotoRendition{})
+	services.GetDB().Where("photo_id = ?", photo.ID).Delete(&models.PhotoColor{})
+
+	// 删除按需生成的缓存
+	if err := services.ClearImageCache(store, photo.ID); err != nil {
+		fmt.Printf("Failed to delete cached images: %v\n", err)
+	}
+
+	if key := services.ResolveStorageKey(photo.FileKey, photo.FilePath); key != "" {
+		if err := store.Delete(key); err != nil {
+			fmt.Printf("Failed to delete file: %v\n", err)
+		}
+	}
+	if photo.PublicKey != "" {
+		if err := store.Delete(photo.PublicKey); err != nil {
+			fmt.Printf("Failed to delete public copy: %v\n", err)
+		}
+	}
+	if photo.SidecarKey != "" {
+		if err := store.Delete(photo.SidecarKey); err != nil {
+			fmt.Printf("Failed to delete xmp sidecar: %v\n", err)
+		}
+	}
+	if key := services.ResolveStorageKey(photo.ThumbnailKey, photo.ThumbnailPath); key != "" {
+		if err := store.Delete(key); err != nil {
+			fmt.Printf("Failed to delete thumbnail: %v\n", err)
+		}
+	}
+}
+
+// ServeImage 公开访问存储中的文件
+//
+// 原图只有在处理完成、且按设置原样公开（没有公开副本）时才对外提供；
+// 等待处理、处理失败或已生成公开副本的原图以及 .xmp 附属文件不对外提供，管理员通过 Original 和 Metadata 查看
+func (h *PhotoHandler) ServeImage(c *gin.Context) {
+	key := strings.TrimPrefix(path.Clean("/"+c.Param("filepath")), "/")
+
+	// 私有目录下的原图、附属文件和 RAW 原图只能由登录用户通过 /api/photos/:id/original 下载
+	if services.IsPrivateKey(key) || services.IsRAW(path.Ext(key)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	var private int64
+	services.GetDB().Model(&models.Photo{}).
+		Where("(file_key = ? AND NOT (processing_status = ? AND public_key = '' AND file_path = ?)) OR sidecar_key = ?",
+			key, models.PhotoStatusReady, services.GetStorage().URL(key), key).
+		Count(&private)
+	if private > 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	serveObject(c, key, "")
+}
+
+// Original 下载照片原图（包含完整的元数据，需要认证）
+func (h *PhotoHandler) Original(c *gin.Context) {
+	var photo models.Photo
+	if err := services.GetDB().First(&photo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+
+	key := services.ResolveStorageKey(photo.FileKey, photo.FilePath)
+	if key == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	// 按上传时的文件名下载
+	filename := photo.OriginalFilename
+	if filename == "" {
+		filename = path.Base(key)
+	}
+
+	// 对象存储中的原图不公开，重定向到临时下载地址
+	if presigner, ok := services.GetStorage().(services.PresignedStorage); ok {
+		url, err := presigner.PresignedURL(key, originalURLExpiry, filename)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+	serveObject(c, key, filename)
+}
+
+// Metadata 返回照片的完整元数据（EXIF 等全部标签，需要认证）
+func (h *PhotoHandler) Metadata(c *gin.Context) {
+	var photo models.Photo
+	if err := services.GetDB().First(&photo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+		return
+	}
+
+	var metadata models.PhotoMetadata
+	if err := services.GetDB().First(&metadata, "photo_id = ?", photo.ID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "元数据尚未提取，请等待处理完成或重新处理该照片"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"file_path":     "/" + uploadPath,
-		"original_name": file.Filename,
-		"size":          file.Size,
+		"photo_id":   metadata.PhotoID,
+		"metadata":   json.RawMessage(metadata.Data),
+		"updated_at": metadata.UpdatedAt,
 	})
 }
 
-func (h *PhotoHandler) ServeImage(c *gin.Context) {
-	filePath := c.Param("filepath")
-	fullPath := filepath.Join("./uploads", filePath)
+// serveObject 从存储后端输出文件，filename 不为空时作为附件下载
+func serveObject(c *gin.Context, key, filename string) {
+	store := services.GetStorage()
 
 	// 检查文件是否存在
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	info, err := store.Stat(key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	rc, err := store.Get(key)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
 		return
 	}
+	defer rc.Close()
+
+	if filename != "" {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+
+	// 本地文件支持 Range 和条件请求
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		c.Header("Content-Type", info.ContentType)
+		http.ServeContent(c.Writer, c.Request, "", info.LastModified, rs)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, rc, nil)
+}
 
-	c.File(fullPath)
+// isAuthenticated 请求是否携带了有效的登录令牌（由 AuthMiddleware 或 OptionalAuthMiddleware 写入）
+func isAuthenticated(c *gin.Context) bool {
+	_, ok := c.Get("userID")
+	return ok
 }
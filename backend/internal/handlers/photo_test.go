@@ -2,14 +2,89 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"picsite/internal/config"
 	"picsite/internal/models"
 	"picsite/internal/services"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
+// setupTestStorage 使用临时目录作为本地存储
+func setupTestStorage(t *testing.T) string {
+	root := t.TempDir()
+	services.Store = services.NewLocalStorage(root, services.LocalStorageURLPrefix)
+	return root
+}
+
+// setupTestQueue 初始化任务队列（不启动 worker），测试中通过 RunPending 同步执行任务
+func setupTestQueue() *services.JobQueue {
+	services.InitJobQueue(&config.Config{})
+	return services.GetJobQueue()
+}
+
+// setupAuthedTestRouter 创建模拟已登录用户的测试路由
+func setupAuthedTestRouter() *gin.Engine {
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) { c.Set("userID", uint(1)) })
+	return router
+}
+
+// testImageJPEG 生成指定尺寸的测试 JPEG 图片
+func testImageJPEG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// uploadFile multipart 请求中的一个文件
+type uploadFile struct {
+	field, filename string
+	data            []byte
+}
+
+// newUploadRequest 构造带图片文件的 multipart 请求
+func newUploadRequest(t *testing.T, url, filename string, fields map[string]string) *http.Request {
+	return newMultipartRequest(t, url, fields, uploadFile{"file", filename, testImageJPEG(t, 800, 600)})
+}
+
+// newMultipartRequest 构造带任意文件的 multipart 请求
+func newMultipartRequest(t *testing.T, url string, fields map[string]string, files ...uploadFile) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	for _, file := range files {
+		part, err := writer.CreateFormFile(file.field, file.filename)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		part.Write(file.data)
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
 func TestPhotoHandler_GetAll(t *testing.T) {
 	db := setupTestDB(t)
 	services.DB = db
@@ -134,6 +209,168 @@ func TestPhotoHandler_GetAll(t *testing.T) {
 		}
 	})
 
+	t.Run("filter by orientation", func(t *testing.T) {
+		shapes := []models.Photo{
+			{Title: "Wide", FilePath: "/wide.jpg", Width: 300, Height: 200, AspectRatio: 1.5},
+			{Title: "Tall", FilePath: "/tall.jpg", Width: 200, Height: 300, AspectRatio: 0.6667},
+			{Title: "Square", FilePath: "/square.jpg", Width: 200, Height: 200, AspectRatio: 1},
+		}
+		for _, photo := range shapes {
+			db.Create(&photo)
+		}
+		defer db.Where("title IN ?", []string{"Wide", "Tall", "Square"}).Delete(&models.Photo{})
+
+		for orientation, want := range map[string]string{"landscape": "Wide", "portrait": "Tall", "square": "Square"} {
+			req, _ := http.NewRequest(http.MethodGet, "/photos?orientation="+orientation, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+
+			data := response["data"].([]interface{})
+			if len(data) != 1 || data[0].(map[string]interface{})["title"] != want {
+				t.Errorf("Expected only %s for orientation=%s, got %v", want, orientation, data)
+			}
+		}
+	})
+
+	t.Run("filter by shooting parameters", func(t *testing.T) {
+		bias := -0.67
+		zero := 0.0
+		shot := []models.Photo{
+			{Title: "Portrait", FilePath: "/p.jpg", CameraMake: "Canon", LensMake: "Sigma", FocalLength: 56, FocalLength35mm: 85,
+				ExposureBias: &bias, Flash: "fired", MeteringMode: "spot", WhiteBalance: "manual", ExposureProgram: "aperture_priority",
+				ColorSpace: "Display P3", ColorConverted: true, Label: "Red"},
+			{Title: "Wide", FilePath: "/w.jpg", CameraMake: "Nikon", FocalLength: 24, Label: "Green",
+				ExposureBias: &zero, Flash: "not_fired", MeteringMode: "pattern", WhiteBalance: "auto", ExposureProgram: "manual"},
+		}
+		for i := range shot {
+			db.Create(&shot[i])
+			defer db.Delete(&shot[i])
+		}
+
+		tests := map[string]string{
+			"make=canon":                         "Portrait",
+			"lens_make=Sigma":                    "Portrait",
+			"flash=not_fired":                    "Wide",
+			"metering_mode=spot":                 "Portrait",
+			"white_balance=auto":                 "Wide",
+			"exposure_program=aperture_priority": "Portrait",
+			"focal_min=50":                       "Portrait", // 使用等效焦距 85mm
+			"focal_min=20&focal_max=60":          "Wide",
+			"exposure_bias=-0.7":                 "Portrait",
+			"exposure_bias=0":                    "Wide",
+			"color_converted=true":               "Portrait",
+			"label=red":                          "Portrait",
+		}
+		for params, want := range tests {
+			req, _ := http.NewRequest(http.MethodGet, "/photos?"+params, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			data := response["data"].([]interface{})
+			if len(data) != 1 || data[0].(map[string]interface{})["title"] != want {
+				t.Errorf("Expected only %s for %s, got %v", want, params, data)
+			}
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "/photos?focal_min=abc", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for invalid focal_min, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("filter by palette color", func(t *testing.T) {
+		colored := []models.Photo{
+			{Title: "Sunset", FilePath: "/sunset.jpg", Palette: []models.PhotoColor{
+				services.NewPhotoColor(0xe8, 0x6a, 0x2c, 0.6), services.NewPhotoColor(0x20, 0x20, 0x40, 0.4)}},
+			{Title: "Sea", FilePath: "/sea.jpg", Palette: []models.PhotoColor{
+				services.NewPhotoColor(0x1e, 0x5a, 0x9c, 0.7), services.NewPhotoColor(0xf0, 0xf0, 0xf0, 0.3)}},
+		}
+		for i := range colored {
+			for j := range colored[i].Palette {
+				colored[i].Palette[j].Position = j
+			}
+			db.Create(&colored[i])
+			defer db.Delete(&colored[i])
+		}
+
+		tests := map[string][]string{
+			"color=%23e0702a":             {"Sunset"},
+			"color=e0702a&tolerance=1":    {},
+			"color=%231e5a9c":             {"Sea"},
+			"color=%23808080&tolerance=5": {},
+		}
+		for params, want := range tests {
+			req, _ := http.NewRequest(http.MethodGet, "/photos?"+params, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var response struct {
+				Data []models.Photo `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			var titles []string
+			for _, photo := range response.Data {
+				titles = append(titles, photo.Title)
+			}
+			if len(titles) != len(want) || (len(want) > 0 && titles[0] != want[0]) {
+				t.Errorf("Expected %v for %s, got %v", want, params, titles)
+			}
+			if len(response.Data) > 0 && (len(response.Data[0].Palette) != 2 || response.Data[0].Palette[0].Weight < response.Data[0].Palette[1].Weight) {
+				t.Errorf("Expected palette ordered by weight, got %+v", response.Data[0].Palette)
+			}
+		}
+
+		for _, params := range []string{"color=red", "color=%23e0702a&tolerance=-1"} {
+			req, _ := http.NewRequest(http.MethodGet, "/photos?"+params, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, params, w.Code)
+			}
+		}
+	})
+
+	t.Run("filter by bounding box", func(t *testing.T) {
+		lat, lng := 39.9042, 116.4074
+		located := models.Photo{Title: "Located", FilePath: "/located.jpg", Latitude: &lat, Longitude: &lng, MetadataPrivacy: services.MetadataKeep}
+		db.Create(&located)
+		defer db.Delete(&located)
+		// 默认不公开拍摄位置的照片不能被未登录用户按范围筛选
+		hidden := models.Photo{Title: "Hidden", FilePath: "/hidden.jpg", Latitude: &lat, Longitude: &lng}
+		db.Create(&hidden)
+		defer db.Delete(&hidden)
+
+		req, _ := http.NewRequest(http.MethodGet, "/photos?bbox=116,39,117,40", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		data := response["data"].([]interface{})
+		if len(data) != 1 || data[0].(map[string]interface{})["title"] != "Located" {
+			t.Errorf("Expected only the located photo, got %v", data)
+		}
+		if data[0].(map[string]interface{})["latitude"] == nil {
+			t.Error("Expected public location to be returned")
+		}
+
+		req, _ = http.NewRequest(http.MethodGet, "/photos?bbox=116,39", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for invalid bbox, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
 	t.Run("pagination", func(t *testing.T) {
 		req, _ := http.NewRequest(http.MethodGet, "/photos?page=1&page_size=2", nil)
 		w := httptest.NewRecorder()
@@ -161,6 +398,95 @@ func TestPhotoHandler_GetAll(t *testing.T) {
 	})
 }
 
+func TestPhotoHandler_Map(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	handler := NewPhotoHandler()
+	router := setupAuthedTestRouter()
+	router.GET("/photos/map", handler.Map)
+
+	coords := [][2]float64{
+		{39.9042, 116.4074}, // 北京
+		{39.9142, 116.4174},
+		{31.2304, 121.4737},  // 上海
+		{-33.8688, 151.2093}, // 悉尼
+	}
+	for i, c := range coords {
+		lat, lng := c[0], c[1]
+		db.Create(&models.Photo{Title: "Geo " + strconv.Itoa(i), FilePath: "/geo.jpg", Latitude: &lat, Longitude: &lng})
+	}
+	db.Create(&models.Photo{Title: "No GPS", FilePath: "/nogps.jpg"})
+
+	type mapResponse struct {
+		Clusters []services.GeoCluster `json:"clusters"`
+		Points   []services.GeoPoint   `json:"points"`
+		Total    int                   `json:"total"`
+	}
+	get := func(t *testing.T, url string) mapResponse {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var response mapResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	t.Run("all geotagged photos", func(t *testing.T) {
+		response := get(t, "/photos/map")
+		if len(response.Points) != 4 || len(response.Clusters) != 0 || response.Total != 4 {
+			t.Errorf("Expected 4 points without clusters, got %+v", response)
+		}
+	})
+
+	t.Run("clustered by zoom", func(t *testing.T) {
+		response := get(t, "/photos/map?zoom=5")
+		if len(response.Clusters) != 1 || response.Clusters[0].Count != 2 {
+			t.Errorf("Expected Beijing photos in one cluster, got %+v", response.Clusters)
+		}
+		if len(response.Points) != 2 || response.Total != 4 {
+			t.Errorf("Expected 2 single points, got %+v", response.Points)
+		}
+	})
+
+	t.Run("bounding box across the antimeridian", func(t *testing.T) {
+		response := get(t, "/photos/map?bbox=150,-40,-170,-30")
+		if len(response.Points) != 1 || response.Points[0].Title != "Geo 3" {
+			t.Errorf("Expected only Sydney, got %+v", response.Points)
+		}
+	})
+
+	t.Run("anonymous users only see public locations", func(t *testing.T) {
+		lat, lng := 48.8566, 2.3522
+		public := models.Photo{Title: "Public", FilePath: "/public.jpg", Latitude: &lat, Longitude: &lng, MetadataPrivacy: services.MetadataKeep}
+		db.Create(&public)
+		defer db.Delete(&public)
+
+		anonymous := setupTestRouter()
+		anonymous.GET("/photos/map", handler.Map)
+		req, _ := http.NewRequest(http.MethodGet, "/photos/map", nil)
+		w := httptest.NewRecorder()
+		anonymous.ServeHTTP(w, req)
+
+		var response mapResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if len(response.Points) != 1 || response.Points[0].Title != "Public" {
+			t.Errorf("Expected only the public photo, got %+v", response.Points)
+		}
+	})
+
+	t.Run("invalid zoom", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/photos/map?zoom=abc", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
+
 func TestPhotoHandler_GetByID(t *testing.T) {
 	db := setupTestDB(t)
 	services.DB = db
@@ -208,6 +534,404 @@ func TestPhotoHandler_GetByID(t *testing.T) {
 			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
 		}
 	})
+
+	t.Run("location hidden from anonymous users", func(t *testing.T) {
+		lat, lng := 39.9042, 116.4074
+		located := models.Photo{Title: "Located", FilePath: "/located.jpg", Latitude: &lat, Longitude: &lng}
+		db.Create(&located)
+
+		get := func(router *gin.Engine) models.Photo {
+			req, _ := http.NewRequest(http.MethodGet, "/photos/"+strconv.Itoa(int(located.ID)), nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var response models.Photo
+			json.Unmarshal(w.Body.Bytes(), &response)
+			return response
+		}
+
+		if response := get(router); response.Latitude != nil || response.Longitude != nil {
+			t.Errorf("Expected location to be hidden, got %v, %v", response.Latitude, response.Longitude)
+		}
+
+		authed := setupAuthedTestRouter()
+		authed.GET("/photos/:id", handler.GetByID)
+		if response := get(authed); response.Latitude == nil || *response.Latitude != lat {
+			t.Errorf("Expected location for authenticated user, got %v", response.Latitude)
+		}
+	})
+}
+
+func TestPhotoHandler_Similar(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.GET("/photos/:id/similar", handler.Similar)
+
+	// histogram 生成全部像素落在同一格的颜色直方图
+	histogram := func(bin int) string {
+		h := make([]byte, 64)
+		h[bin] = 255
+		return hex.EncodeToString(h)
+	}
+	photos := []models.Photo{
+		{Title: "Sunset", PerceptualHash: "00000000000000ff", ColorHistogram: histogram(48)},
+		{Title: "Sunset crop", PerceptualHash: "00000000000001ff", ColorHistogram: histogram(48)},
+		{Title: "Sunset in color", PerceptualHash: "ffffffff00000000", ColorHistogram: histogram(48)},
+		{Title: "Forest", PerceptualHash: "ffffffff00000000", ColorHistogram: histogram(12)},
+		{Title: "Private sunset", PerceptualHash: "00000000000000ff", ColorHistogram: histogram(48)},
+		{Title: "Processing", PerceptualHash: "00000000000000ff", ColorHistogram: histogram(48), ProcessingStatus: models.PhotoStatusPending},
+		{Title: "No features"},
+	}
+	for i := range photos {
+		photos[i].FilePath = "/uploads/" + strconv.Itoa(i) + ".jpg"
+		db.Create(&photos[i])
+	}
+	album := models.Album{Name: "Family", IsProtected: true}
+	db.Create(&album)
+	db.Create(&models.AlbumPhoto{AlbumID: album.ID, PhotoID: photos[4].ID})
+
+	get := func(url string) (*httptest.ResponseRecorder, []uint) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response struct {
+			Data []struct {
+				Score float64      `json:"score"`
+				Photo models.Photo `json:"photo"`
+			} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		var ids []uint
+		for _, item := range response.Data {
+			ids = append(ids, item.Photo.ID)
+		}
+		return w, ids
+	}
+
+	t.Run("ranks public photos by similarity", func(t *testing.T) {
+		w, ids := get("/photos/" + strconv.Itoa(int(photos[0].ID)) + "/similar")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		// 构图和颜色都不同的照片不会返回
+		want := []uint{photos[1].ID, photos[2].ID}
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("Expected %v, got %v", want, ids)
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		if _, ids := get("/photos/" + strconv.Itoa(int(photos[0].ID)) + "/similar?limit=1"); len(ids) != 1 || ids[0] != photos[1].ID {
+			t.Errorf("Expected only the closest photo, got %v", ids)
+		}
+		if w, _ := get("/photos/" + strconv.Itoa(int(photos[0].ID)) + "/similar?limit=0"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for invalid limit, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("photo without features", func(t *testing.T) {
+		if w, ids := get("/photos/" + strconv.Itoa(int(photos[6].ID)) + "/similar"); w.Code != http.StatusOK || len(ids) != 0 {
+			t.Errorf("Expected empty result, got %d %v", w.Code, ids)
+		}
+	})
+
+	t.Run("missing photo", func(t *testing.T) {
+		if w, _ := get("/photos/999/similar"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
+
+func TestPhotoHandler_Create(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	root := setupTestStorage(t)
+	queue := setupTestQueue()
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.POST("/photos", handler.Create)
+	router.GET("/photos/:id", handler.GetByID)
+
+	t.Run("create photo stores files in upload path", func(t *testing.T) {
+		services.ImageConfig.RenditionMode = services.RenditionModeEager
+		defer func() { services.ImageConfig.RenditionMode = services.RenditionModeOnDemand }()
+
+		req := newUploadRequest(t, "/photos", "test.jpg", map[string]string{"title": "Uploaded", "camera_model": "Form Camera", "author": "Form Author"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+
+		// 处理在后台任务中完成，响应时照片处于等待处理状态
+		var response models.Photo
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if response.ProcessingStatus != models.PhotoStatusPending {
+			t.Errorf("Expected pending status in response, got %s", response.ProcessingStatus)
+		}
+
+		var job models.Job
+		if err := db.Where("photo_id = ?", response.ID).First(&job).Error; err != nil {
+			t.Fatalf("Expected processing job to be enqueued: %v", err)
+		}
+		if job.Type != services.JobTypeProcessPhoto || job.Status != models.JobStatusPending {
+			t.Errorf("Unexpected job: %+v", job)
+		}
+
+		if n := queue.RunPending(); n != 1 {
+			t.Fatalf("Expected 1 job to run, got %d", n)
+		}
+
+		var photo models.Photo
+		db.Preload("Renditions", orderRenditions).First(&photo, response.ID)
+		if photo.ProcessingStatus != models.PhotoStatusReady {
+			t.Errorf("Expected ready status after processing, got %s (%s)", photo.ProcessingStatus, photo.ProcessingError)
+		}
+		if _, err := os.Stat(filepath.Join(root, photo.FileKey)); err != nil {
+			t.Errorf("Expected original under upload path: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, photo.ThumbnailKey)); err != nil {
+			t.Errorf("Expected thumbnail under upload path: %v", err)
+		}
+		// 默认抹去 GPS，公开地址指向处理后的副本，原图保持不变
+		if photo.PublicKey == "" || photo.FilePath != "/uploads/"+photo.PublicKey {
+			t.Errorf("Expected file path to point to public copy, got %s (%s)", photo.FilePath, photo.PublicKey)
+		}
+		if _, err := os.Stat(filepath.Join(root, photo.PublicKey)); err != nil {
+			t.Errorf("Expected public copy under upload path: %v", err)
+		}
+		if photo.Width != 800 || photo.Height != 600 {
+			t.Errorf("Expected dimensions 800x600, got %dx%d", photo.Width, photo.Height)
+		}
+		if photo.MimeType != "image/jpeg" || photo.FileSize == 0 || photo.AspectRatio != 1.3333 {
+			t.Errorf("Unexpected file info: %s, %d bytes, ratio %v", photo.MimeType, photo.FileSize, photo.AspectRatio)
+		}
+		if photo.BlurHash == "" || photo.LQIP == "" || photo.DominantColor == "" {
+			t.Errorf("Expected placeholder fields to be set, got %q, %q, %q", photo.BlurHash, photo.LQIP, photo.DominantColor)
+		}
+		if photo.CameraModel != "Form Camera" {
+			t.Errorf("Expected form camera model to be kept, got %s", photo.CameraModel)
+		}
+		if photo.Author != "Form Author" {
+			t.Errorf("Expected form author to be saved, got %s", photo.Author)
+		}
+		// 原图按内容哈希分目录保存，文件名带有随机后缀，同时保留上传时的文件名
+		if photo.ContentHash == "" || !strings.HasPrefix(photo.FileKey, "private/originals/"+photo.ContentHash[:2]+"/"+photo.ContentHash+"-") {
+			t.Errorf("Expected content-addressed key, got %s (%s)", photo.FileKey, photo.ContentHash)
+		}
+		if strings.Contains(w.Body.String(), photo.ContentHash) {
+			t.Error("Expected content hash not to be returned")
+		}
+		if photo.OriginalFilename != "test.jpg" {
+			t.Errorf("Expected original filename to be kept, got %q", photo.OriginalFilename)
+		}
+
+		if len(photo.Renditions) == 0 {
+			t.Fatal("Expected renditions to be generated")
+		}
+		last := photo.Renditions[len(photo.Renditions)-1]
+		if last.Width != 800 {
+			t.Errorf("Expected largest rendition to be capped at original width 800, got %d", last.Width)
+		}
+	})
+
+	t.Run("create photo advertises on-demand renditions", func(t *testing.T) {
+		req := newMultipartRequest(t, "/photos", map[string]string{"title": "Lazy"},
+			uploadFile{"file", "lazy.jpg", testImageJPEG(t, 640, 480)})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+
+		// 处理完成前 /img 返回 404，不提供按需生成地址
+		var response models.Photo
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if len(response.Renditions) != 0 {
+			t.Errorf("Expected no renditions before processing, got %v", response.Renditions)
+		}
+
+		queue.RunPending()
+
+		req, _ = http.NewRequest(http.MethodGet, "/photos/"+strconv.Itoa(int(response.ID)), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var processed models.Photo
+		json.Unmarshal(w.Body.Bytes(), &processed)
+		if len(processed.Renditions) == 0 {
+			t.Fatal("Expected renditions after processing")
+		}
+		if !strings.HasPrefix(processed.Renditions[0].URL, "/img/") {
+			t.Errorf("Expected on-demand rendition url, got %s", processed.Renditions[0].URL)
+		}
+
+		var stored int64
+		db.Model(&models.PhotoRendition{}).Where("photo_id = ?", response.ID).Count(&stored)
+		if stored != 0 {
+			t.Errorf("Expected no pre-generated renditions, got %d", stored)
+		}
+	})
+
+	t.Run("reject duplicate upload", func(t *testing.T) {
+		var existing models.Photo
+		db.Where("original_filename = ?", "test.jpg").First(&existing)
+
+		// 文件名不同但内容相同
+		req := newUploadRequest(t, "/photos", "copy.jpg", map[string]string{"title": "Copy"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Code    string       `json:"code"`
+			PhotoID uint         `json:"photo_id"`
+			Photo   models.Photo `json:"photo"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusConflict || response.Code != services.UploadErrDuplicate {
+			t.Fatalf("Expected status %d with duplicate code, got %d. Body: %s", http.StatusConflict, w.Code, w.Body.String())
+		}
+		if response.PhotoID != existing.ID || response.Photo.ID != existing.ID {
+			t.Errorf("Expected duplicate to point to photo %d, got %d", existing.ID, response.PhotoID)
+		}
+
+		var count int64
+		db.Model(&models.Photo{}).Where("content_hash = ?", existing.ContentHash).Count(&count)
+		if count != 1 {
+			t.Errorf("Expected a single photo for the content, got %d", count)
+		}
+		if _, err := os.Stat(filepath.Join(root, existing.FileKey)); err != nil {
+			t.Errorf("Expected existing original to be kept: %v", err)
+		}
+	})
+
+	t.Run("same filename does not overwrite", func(t *testing.T) {
+		var keys []string
+		for _, size := range []int{300, 200} {
+			req := newMultipartRequest(t, "/photos", map[string]string{"title": "Same Name"},
+				uploadFile{"file", "IMG_0001.jpg", testImageJPEG(t, size, size)})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+			}
+			var response models.Photo
+			json.Unmarshal(w.Body.Bytes(), &response)
+			var photo models.Photo
+			db.First(&photo, response.ID)
+			keys = append(keys, photo.FileKey)
+		}
+		queue.RunPending()
+
+		if keys[0] == keys[1] {
+			t.Fatalf("Expected distinct keys for same filename, got %s", keys[0])
+		}
+		for _, key := range keys {
+			if _, err := os.Stat(filepath.Join(root, key)); err != nil {
+				t.Errorf("Expected %s to exist: %v", key, err)
+			}
+		}
+	})
+
+	t.Run("reject unsupported file type", func(t *testing.T) {
+		req := newUploadRequest(t, "/photos", "test.gif", map[string]string{"title": "Bad"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("reject content that does not match", func(t *testing.T) {
+		tests := map[string]uploadFile{
+			services.UploadErrInvalidContent: {"file", "setup.jpg", append([]byte("MZ\x90\x00"), make([]byte, 64)...)},
+			services.UploadErrTypeMismatch:   {"file", "photo.png", testImageJPEG(t, 10, 10)},
+		}
+		for code, file := range tests {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, newMultipartRequest(t, "/photos", map[string]string{"title": "Bad"}, file))
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if w.Code != http.StatusBadRequest || response["code"] != code {
+				t.Errorf("Expected %s, got %d %v", code, w.Code, response)
+			}
+		}
+	})
+
+	t.Run("heic keeps master and publishes jpeg", func(t *testing.T) {
+		data, err := os.ReadFile("../services/testdata/sample.heic")
+		if err != nil {
+			t.Fatalf("Failed to read sample HEIC: %v", err)
+		}
+		req := newMultipartRequest(t, "/photos", map[string]string{"title": "iPhone"},
+			uploadFile{"file", "IMG_0001.HEIC", data})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		queue.RunPending()
+
+		var response models.Photo
+		json.Unmarshal(w.Body.Bytes(), &response)
+		var photo models.Photo
+		db.First(&photo, response.ID)
+		if photo.ProcessingStatus != models.PhotoStatusReady {
+			t.Fatalf("Expected ready status, got %s (%s)", photo.ProcessingStatus, photo.ProcessingError)
+		}
+		if filepath.Ext(photo.FileKey) != ".heic" || filepath.Ext(photo.PublicKey) != ".jpg" {
+			t.Errorf("Expected HEIC master and JPEG public copy, got %s, %s", photo.FileKey, photo.PublicKey)
+		}
+		if photo.Width != 512 || photo.Height != 512 {
+			t.Errorf("Expected 512x512, got %dx%d", photo.Width, photo.Height)
+		}
+	})
+
+	t.Run("xmp sidecar fills blank fields", func(t *testing.T) {
+		sidecar := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="5">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Lightroom Title</rdf:li></rdf:Alt></dc:title>
+<dc:subject><rdf:Bag><rdf:li>travel</rdf:li><rdf:li>night</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+		req := newMultipartRequest(t, "/photos", map[string]string{"tags": "form-tag"},
+			uploadFile{"file", "sidecar.jpg", testImageJPEG(t, 100, 100)},
+			uploadFile{"xmp", "sidecar.xmp", []byte(sidecar)})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		queue.RunPending()
+
+		var response models.Photo
+		json.Unmarshal(w.Body.Bytes(), &response)
+		var photo models.Photo
+		db.First(&photo, response.ID)
+		if photo.Title != "Lightroom Title" || photo.Rating != 5 {
+			t.Errorf("Expected title and rating from sidecar, got %q, %d", photo.Title, photo.Rating)
+		}
+		if photo.Tags != "form-tag" {
+			t.Errorf("Expected form tags to be kept, got %q", photo.Tags)
+		}
+		if _, err := os.Stat(filepath.Join(root, photo.SidecarKey)); photo.SidecarKey == "" || err != nil {
+			t.Errorf("Expected sidecar to be stored, got %q: %v", photo.SidecarKey, err)
+		}
+	})
+
+	t.Run("reject non-xmp sidecar", func(t *testing.T) {
+		req := newMultipartRequest(t, "/photos", nil,
+			uploadFile{"file", "a.jpg", testImageJPEG(t, 10, 10)},
+			uploadFile{"xmp", "a.txt", []byte("hello")})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
 }
 
 func TestPhotoHandler_Update(t *testing.T) {
@@ -255,6 +979,66 @@ func TestPhotoHandler_Update(t *testing.T) {
 		}
 	})
 
+	t.Run("update shooting parameters", func(t *testing.T) {
+		body := `{"camera_make":"Fujifilm","focal_length":23,"focal_length_35mm":35,"exposure_bias":0,"flash":"no_flash","metering_mode":"average"}`
+		req, _ := http.NewRequest(http.MethodPut, "/photos/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var updatedPhoto models.Photo
+		db.First(&updatedPhoto, 1)
+		if updatedPhoto.CameraMake != "Fujifilm" || updatedPhoto.FocalLength != 23 || updatedPhoto.FocalLength35mm != 35 {
+			t.Errorf("Unexpected camera fields: %+v", updatedPhoto)
+		}
+		if updatedPhoto.ExposureBias == nil || *updatedPhoto.ExposureBias != 0 {
+			t.Errorf("Expected exposure bias 0 to be saved, got %v", updatedPhoto.ExposureBias)
+		}
+		if updatedPhoto.Flash != "no_flash" || updatedPhoto.MeteringMode != "average" {
+			t.Errorf("Unexpected flash/metering: %s, %s", updatedPhoto.Flash, updatedPhoto.MeteringMode)
+		}
+	})
+
+	t.Run("ignores server generated fields", func(t *testing.T) {
+		db.Model(&models.Photo{}).Where("id = ?", 1).Updates(map[string]interface{}{
+			"processing_status": models.PhotoStatusReady,
+			"width":             800,
+			"blur_hash":         "LEHV6nWB2yk8",
+			"is_featured":       true,
+		})
+
+		// 客户端在处理完成前获取的照片原样提交回来
+		body := `{"title":"Stale Copy","processing_status":"pending","width":0,"height":0,"blurhash":"",` +
+			`"file_path":"/uploads/other.jpg","perceptual_hash":"ffffffffffffffff","is_featured":false}`
+		req, _ := http.NewRequest(http.MethodPut, "/photos/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var updatedPhoto models.Photo
+		db.First(&updatedPhoto, 1)
+		if updatedPhoto.ProcessingStatus != models.PhotoStatusReady || updatedPhoto.Width != 800 ||
+			updatedPhoto.BlurHash == "" || updatedPhoto.FilePath != "/test/photo.jpg" || updatedPhoto.PerceptualHash != "" {
+			t.Errorf("Expected server generated fields to be kept, got %+v", updatedPhoto)
+		}
+		// 请求中出现的可编辑字段即使是零值也会更新
+		if updatedPhoto.Title != "Stale Copy" || updatedPhoto.IsFeatured {
+			t.Errorf("Expected editable fields to be updated, got title=%q featured=%v", updatedPhoto.Title, updatedPhoto.IsFeatured)
+		}
+		// 未出现的字段保持不变
+		if updatedPhoto.Year != 2024 || updatedPhoto.CameraMake != "Fujifilm" {
+			t.Errorf("Expected omitted fields to be kept, got year=%d make=%q", updatedPhoto.Year, updatedPhoto.CameraMake)
+		}
+	})
+
 	t.Run("update non-existent photo", func(t *testing.T) {
 		updateData := map[string]string{
 			"title": "Updated Title",
@@ -273,6 +1057,323 @@ func TestPhotoHandler_Update(t *testing.T) {
 	})
 }
 
+func TestPhotoHandler_UploadFile(t *testing.T) {
+	root := setupTestStorage(t)
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.POST("/upload", handler.UploadFile)
+
+	t.Run("stores validated image", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newUploadRequest(t, "/upload", "cover.jpg", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if response["mime_type"] != "image/jpeg" || response["width"] != float64(800) || response["content_hash"] == "" {
+			t.Errorf("Unexpected response: %v", response)
+		}
+		if _, err := os.Stat(filepath.Join(root, strings.TrimPrefix(response["file_path"].(string), "/uploads/"))); err != nil {
+			t.Errorf("Expected file to be stored: %v", err)
+		}
+	})
+
+	t.Run("strips location metadata", func(t *testing.T) {
+		// 在 SOI 之后插入带 GPS 的 XMP 段
+		payload := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta exif:GPSLatitude=\"39,54N\"/>")
+		segment := append([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+		jpegData := testImageJPEG(t, 10, 10)
+		data := append(append(append([]byte{}, jpegData[:2]...), segment...), jpegData[2:]...)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newMultipartRequest(t, "/upload", nil, uploadFile{"file", "location.jpg", data}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		stored, err := os.ReadFile(filepath.Join(root, strings.TrimPrefix(response["file_path"].(string), "/uploads/")))
+		if err != nil {
+			t.Fatalf("Expected file to be stored: %v", err)
+		}
+		if bytes.Contains(stored, []byte("GPSLatitude")) {
+			t.Error("Expected location metadata to be removed")
+		}
+	})
+
+	t.Run("rejects polyglot", func(t *testing.T) {
+		data := append(testImageJPEG(t, 10, 10), []byte("<?php echo 1; ?>")...)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newMultipartRequest(t, "/upload", nil, uploadFile{"file", "avatar.jpg", data}))
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusBadRequest || response["code"] != services.UploadErrPolyglot {
+			t.Errorf("Expected %s, got %d %v", services.UploadErrPolyglot, w.Code, response)
+		}
+	})
+}
+
+// presignedStorage 为本地存储加上临时下载地址，模拟对象存储
+type presignedStorage struct {
+	services.Storage
+}
+
+func (s presignedStorage) PresignedURL(key string, expiry time.Duration, filename string) (string, error) {
+	return "https://signed.example.com/" + key + "?filename=" + filename, nil
+}
+
+func TestPhotoHandler_MetadataPrivacy(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	root := setupTestStorage(t)
+	queue := setupTestQueue()
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.POST("/photos", handler.Create)
+	router.PUT("/photos/:id", handler.Update)
+	router.GET("/photos/:id/original", handler.Original)
+	router.GET("/uploads/*filepath", handler.ServeImage)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequest(t, "/photos", "private.jpg", map[string]string{"title": "Private"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	queue.RunPending()
+
+	var photo models.Photo
+	db.Order("id DESC").First(&photo)
+	if photo.PublicKey == "" {
+		t.Fatal("Expected public copy to be generated")
+	}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("master is not served publicly", func(t *testing.T) {
+		if w := get("/uploads/" + photo.FileKey); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for master, got %d", http.StatusNotFound, w.Code)
+		}
+		if w := get("/uploads/x/../" + photo.FileKey); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for master via dot segments, got %d", http.StatusNotFound, w.Code)
+		}
+		if w := get("/uploads/" + photo.PublicKey); w.Code != http.StatusOK {
+			t.Errorf("Expected status %d for public copy, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("xmp sidecar is not served publicly", func(t *testing.T) {
+		os.WriteFile(filepath.Join(root, "private.xmp"), []byte("<x:xmpmeta/>"), 0o644)
+		db.Model(&photo).Update("sidecar_key", "private.xmp")
+		if w := get("/uploads/private.xmp"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for sidecar, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("raw is not served publicly", func(t *testing.T) {
+		os.WriteFile(filepath.Join(root, "shot.NEF"), []byte("II*\x00"), 0o644)
+		if w := get("/uploads/shot.NEF"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for raw, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("original download", func(t *testing.T) {
+		w := get("/photos/" + strconv.Itoa(int(photo.ID)) + "/original")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		master, _ := os.ReadFile(filepath.Join(root, photo.FileKey))
+		if !bytes.Equal(w.Body.Bytes(), master) {
+			t.Error("Expected original download to match master")
+		}
+		if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment") || !strings.Contains(disposition, "private.jpg") {
+			t.Errorf("Expected attachment with original filename, got %q", disposition)
+		}
+	})
+
+	t.Run("original download redirects to presigned url", func(t *testing.T) {
+		local := services.Store
+		services.Store = presignedStorage{local}
+		defer func() { services.Store = local }()
+
+		w := get("/photos/" + strconv.Itoa(int(photo.ID)) + "/original")
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusFound, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != "https://signed.example.com/"+photo.FileKey+"?filename=private.jpg" {
+			t.Errorf("Unexpected redirect: %s", location)
+		}
+	})
+
+	t.Run("invalid metadata privacy", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPut, "/photos/"+strconv.Itoa(int(photo.ID)), strings.NewReader(`{"metadata_privacy":"none"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("switching to keep publishes an exact copy", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPut, "/photos/"+strconv.Itoa(int(photo.ID)), strings.NewReader(`{"metadata_privacy":"keep"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		// 私有目录下的原图不直接公开，原样复制一份
+		var updated models.Photo
+		db.First(&updated, photo.ID)
+		if updated.PublicKey == "" || updated.FilePath != "/uploads/"+updated.PublicKey {
+			t.Fatalf("Expected public copy, got %s (%s)", updated.FilePath, updated.PublicKey)
+		}
+		master, _ := os.ReadFile(filepath.Join(root, updated.FileKey))
+		public, _ := os.ReadFile(filepath.Join(root, updated.PublicKey))
+		if !bytes.Equal(master, public) {
+			t.Error("Expected public copy to match master in keep mode")
+		}
+		if w := get("/uploads/" + updated.FileKey); w.Code != http.StatusNotFound {
+			t.Errorf("Expected master not to be served, got %d", w.Code)
+		}
+	})
+}
+
+func TestPhotoHandler_ServeImageUnprocessed(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	root := setupTestStorage(t)
+	queue := setupTestQueue()
+	original := services.ImageConfig
+	defer func() { services.ImageConfig = original }()
+	services.ImageConfig.MetadataPrivacy = services.MetadataKeep
+
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.POST("/photos", handler.Create)
+	router.GET("/uploads/*filepath", handler.ServeImage)
+
+	get := func(key string) int {
+		req, _ := http.NewRequest(http.MethodGet, "/uploads/"+key, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("uploaded master", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newUploadRequest(t, "/photos", "pending.jpg", map[string]string{"title": "Pending"}))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		var photo models.Photo
+		json.Unmarshal(w.Body.Bytes(), &photo)
+		db.First(&photo, photo.ID)
+
+		// 处理完成前原图不对外提供
+		if photo.FilePath != "" {
+			t.Errorf("Expected no public path before processing, got %s", photo.FilePath)
+		}
+		if !strings.HasPrefix(photo.FileKey, services.PrivatePrefix) {
+			t.Errorf("Expected master under private prefix, got %s", photo.FileKey)
+		}
+		if code := get(photo.FileKey); code != http.StatusNotFound {
+			t.Errorf("Expected status %d for pending master, got %d", http.StatusNotFound, code)
+		}
+
+		// 处理完成后只公开副本和缩略图，原图仍不对外提供
+		queue.RunPending()
+		db.First(&photo, photo.ID)
+		if photo.PublicKey == "" || strings.HasPrefix(photo.PublicKey, services.PrivatePrefix) || strings.HasPrefix(photo.ThumbnailKey, services.PrivatePrefix) {
+			t.Fatalf("Expected public files outside private prefix, got %s and %s", photo.PublicKey, photo.ThumbnailKey)
+		}
+		if code := get(photo.PublicKey); code != http.StatusOK {
+			t.Errorf("Expected status %d for public copy, got %d", http.StatusOK, code)
+		}
+		if code := get(photo.FileKey); code != http.StatusNotFound {
+			t.Errorf("Expected status %d for processed master, got %d", http.StatusNotFound, code)
+		}
+	})
+
+	t.Run("failed legacy master", func(t *testing.T) {
+		// 早期上传的原图不在私有目录下，keep 时直接公开
+		os.WriteFile(filepath.Join(root, "legacy.jpg"), testImageJPEG(t, 800, 600), 0o644)
+		photo := models.Photo{Title: "Legacy", FileKey: "legacy.jpg", FilePath: "/uploads/legacy.jpg", ProcessingStatus: models.PhotoStatusReady}
+		db.Create(&photo)
+		if code := get(photo.FileKey); code != http.StatusOK {
+			t.Errorf("Expected status %d for published master, got %d", http.StatusOK, code)
+		}
+
+		// 重新处理失败后原图不再对外提供
+		if err := services.MarkPhotoFailed(photo.ID, errors.New("decode failed")); err != nil {
+			t.Fatalf("MarkPhotoFailed failed: %v", err)
+		}
+		db.First(&photo, photo.ID)
+		if photo.FilePath != "" {
+			t.Errorf("Expected public path to be cleared, got %s", photo.FilePath)
+		}
+		if code := get(photo.FileKey); code != http.StatusNotFound {
+			t.Errorf("Expected status %d for failed master, got %d", http.StatusNotFound, code)
+		}
+	})
+}
+
+func TestPhotoHandler_Metadata(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.GET("/photos/:id/metadata", handler.Metadata)
+
+	processed := models.Photo{Title: "Processed", FilePath: "/p.jpg"}
+	pending := models.Photo{Title: "Pending", FilePath: "/q.jpg"}
+	db.Create(&processed)
+	db.Create(&pending)
+	db.Create(&models.PhotoMetadata{PhotoID: processed.ID, Data: `{"exif":{"IFD":{"Model":"Test Camera"}}}`})
+
+	t.Run("returns stored metadata", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/photos/"+strconv.Itoa(int(processed.ID))+"/metadata", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var response struct {
+			PhotoID  uint                                    `json:"photo_id"`
+			Metadata map[string]map[string]map[string]string `json:"metadata"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if response.PhotoID != processed.ID || response.Metadata["exif"]["IFD"]["Model"] != "Test Camera" {
+			t.Errorf("Unexpected response: %s", w.Body.String())
+		}
+	})
+
+	for name, url := range map[string]string{
+		"photo without metadata": "/photos/" + strconv.Itoa(int(pending.ID)) + "/metadata",
+		"non-existent photo":     "/photos/999/metadata",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+			}
+		})
+	}
+}
+
 func TestPhotoHandler_Delete(t *testing.T) {
 	db := setupTestDB(t)
 	services.DB = db
@@ -317,6 +1418,35 @@ func TestPhotoHandler_Delete(t *testing.T) {
 			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
 		}
 	})
+
+	t.Run("delete photo removes stored files", func(t *testing.T) {
+		root := setupTestStorage(t)
+		for _, name := range []string{"stored.jpg", "stored_thumb.jpg"} {
+			if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+		}
+		stored := models.Photo{
+			Title:         "Stored",
+			FilePath:      "/uploads/stored.jpg",
+			ThumbnailPath: "/uploads/stored_thumb.jpg",
+			FileKey:       "stored.jpg",
+		}
+		db.Create(&stored)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/photos/"+strconv.Itoa(int(stored.ID)), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		for _, name := range []string{"stored.jpg", "stored_thumb.jpg"} {
+			if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be deleted", name)
+			}
+		}
+	})
 }
 
 func TestPhotoHandler_BatchDelete(t *testing.T) {
@@ -468,6 +1598,75 @@ func TestPhotoHandler_BatchUpdateFeatured(t *testing.T) {
 	})
 }
 
+func TestPhotoHandler_BatchShiftDates(t *testing.T) {
+	db := setupTestDB(t)
+	services.DB = db
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.PATCH("/photos/batch/shot-date", handler.BatchShiftDates)
+
+	shotDate := time.Date(2023, 12, 31, 23, 30, 0, 0, time.UTC)
+	photos := []models.Photo{
+		{Title: "Photo 1", FilePath: "/photo1.jpg", ShotDate: &shotDate, Year: 2023},
+		{Title: "Photo 2", FilePath: "/photo2.jpg"},
+	}
+	for i := range photos {
+		if err := db.Create(&photos[i]).Error; err != nil {
+			t.Fatalf("Failed to create test photo: %v", err)
+		}
+	}
+
+	shift := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPatch, "/photos/batch/shot-date", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("shift and set offset", func(t *testing.T) {
+		w := shift(map[string]interface{}{"ids": []uint{photos[0].ID, photos[1].ID}, "shift": "1h", "offset": "+09:00"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if response["updated"] != 1.0 {
+			t.Errorf("Expected only the photo with a shot date to be updated, got %v", response["updated"])
+		}
+
+		var photo models.Photo
+		db.First(&photo, photos[0].ID)
+		if got := photo.ShotDate.Format(time.RFC3339); got != "2024-01-01T00:30:00+09:00" {
+			t.Errorf("Expected shifted local time with new offset, got %s", got)
+		}
+		if photo.ShotDateOffset != "+09:00" || photo.Year != 2024 {
+			t.Errorf("Expected offset and year to be updated, got %q %d", photo.ShotDateOffset, photo.Year)
+		}
+
+		var skipped models.Photo
+		db.First(&skipped, photos[1].ID)
+		if skipped.ShotDate != nil {
+			t.Errorf("Expected photo without shot date to be skipped, got %v", skipped.ShotDate)
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, body := range []map[string]interface{}{
+			{"ids": []uint{photos[0].ID}},
+			{"ids": []uint{photos[0].ID}, "shift": "1 hour"},
+			{"ids": []uint{photos[0].ID}, "offset": "+25:00"},
+			{"ids": []uint{}, "shift": "1h"},
+		} {
+			if w := shift(body); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d for %v, got %d", http.StatusBadRequest, body, w.Code)
+			}
+		}
+	})
+}
+
 func TestPhotoHandler_IncrementView(t *testing.T) {
 	db := setupTestDB(t)
 	services.DB = db
@@ -516,3 +1715,82 @@ func TestPhotoHandler_IncrementView(t *testing.T) {
 		}
 	})
 }
+
+func TestPhotoHandler_SetWatermark(t *testing.T) {
+	original := services.ImageConfig
+	defer func() { services.ImageConfig = original }()
+
+	db := setupTestDB(t)
+	services.DB = db
+	setupTestStorage(t)
+	queue := setupTestQueue()
+	handler := NewPhotoHandler()
+	router := setupTestRouter()
+	router.PUT("/photos/:id/watermark", handler.SetWatermark)
+
+	data := testImageJPEG(t, 800, 600)
+	services.GetStorage().Put("photo.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg")
+	photo := models.Photo{Title: "Photo", FilePath: "/uploads/photo.jpg", FileKey: "photo.jpg", MetadataPrivacy: services.MetadataKeep}
+	db.Create(&photo)
+
+	put := func(url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	countJobs := func() int64 {
+		var count int64
+		db.Model(&models.Job{}).Where("type = ?", services.JobTypeWatermarkPhoto).Count(&count)
+		return count
+	}
+
+	t.Run("watermark not configured", func(t *testing.T) {
+		services.ImageConfig.Watermark.Mark = nil
+		w := put("/photos/1/watermark", `{"watermark_disabled": true}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var updated models.Photo
+		db.First(&updated, photo.ID)
+		if !updated.WatermarkDisabled {
+			t.Error("Expected watermark to be disabled")
+		}
+		// 未配置水印时不需要重新生成
+		if count := countJobs(); count != 0 {
+			t.Errorf("Expected no watermark jobs, got %d", count)
+		}
+	})
+
+	t.Run("enable watermark", func(t *testing.T) {
+		services.ImageConfig.Watermark.Mark = image.NewNRGBA(image.Rect(0, 0, 10, 10))
+		w := put("/photos/1/watermark", `{"watermark_disabled": false}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var response models.Photo
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if response.WatermarkDisabled {
+			t.Error("Expected watermark_disabled false in response")
+		}
+		if count := countJobs(); count != 1 {
+			t.Fatalf("Expected 1 watermark job, got %d", count)
+		}
+		// 原样公开的原图加水印后改为公开副本
+		queue.RunPending()
+		db.First(&photo, photo.ID)
+		if photo.PublicKey != "photo_public.jpg" || photo.FilePath != "/uploads/photo_public.jpg" {
+			t.Errorf("Expected watermarked public copy, got %s (%s)", photo.FilePath, photo.PublicKey)
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		if w := put("/photos/1/watermark", `{"watermark_disabled": "yes"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		if w := put("/photos/999/watermark", `{"watermark_disabled": true}`); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
@@ -134,3 +134,47 @@ func TestAuthMiddleware_SetsContext(t *testing.T) {
 		t.Errorf("Expected role 'admin', got %v", contextRole)
 	}
 }
+
+func TestOptionalAuthMiddleware(t *testing.T) {
+	router := setupTestRouterForMiddleware()
+	secret := "test-secret-key"
+
+	validToken, err := utils.GenerateToken(1, "admin", "admin", secret)
+	if err != nil {
+		t.Fatalf("Failed to generate test token: %v", err)
+	}
+
+	router.GET("/public", OptionalAuthMiddleware(secret), func(c *gin.Context) {
+		_, authenticated := c.Get("userID")
+		c.JSON(http.StatusOK, gin.H{"authenticated": authenticated})
+	})
+
+	tests := []struct {
+		name       string
+		authHeader string
+		expected   string
+	}{
+		{"no token", "", `{"authenticated":false}`},
+		{"invalid token", "Bearer invalid-token", `{"authenticated":false}`},
+		{"valid token", "Bearer " + validToken, `{"authenticated":true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/public", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if w.Body.String() != tt.expected {
+				t.Errorf("Expected body %s, got %s", tt.expected, w.Body.String())
+			}
+		})
+	}
+}
@@ -6,39 +6,156 @@ import (
 	"gorm.io/gorm"
 )
 
+// 照片处理状态
+const (
+	PhotoStatusPending    = "pending"    // 等待后台处理
+	PhotoStatusProcessing = "processing" // 正在处理
+	PhotoStatusReady      = "ready"      // 处理完成
+	PhotoStatusFailed     = "failed"     // 处理失败（重试次数已用尽）
+)
+
 type Photo struct {
-	ID            uint           `json:"id" gorm:"primaryKey"`
-	Title         string         `json:"title" gorm:"not null"`
-	Description   string         `json:"description"`
-	FilePath      string         `json:"file_path" gorm:"not null"`
-	ThumbnailPath string         `json:"thumbnail_path"`
-	Location      string         `json:"location"`
-	ShotDate      *time.Time     `json:"shot_date"`
-	Year          int            `json:"year"`
-	CameraModel   string         `json:"camera_model"`
-	Lens          string         `json:"lens"`
-	Aperture      string         `json:"aperture"`
-	ShutterSpeed  string         `json:"shutter_speed"`
-	ISO           int            `json:"iso"`
-	Tags          string         `json:"tags"` // JSON array stored as string
-	IsFeatured    bool           `json:"is_featured" gorm:"default:false"`
-	ViewCount     int            `json:"view_count" gorm:"default:0"`
-	CreatedAt     time.Time      `json:"created_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                uint           `json:"id" gorm:"primaryKey"`
+	Title             string         `json:"title" gorm:"not null"`
+	Description       string         `json:"description"`
+	FilePath          string         `json:"file_path" gorm:"not null"`
+	ThumbnailPath     string         `json:"thumbnail_path"`
+	FileKey           string         `json:"-"`                                       // 原图在存储后端中的 key
+	ThumbnailKey      string         `json:"-"`                                       // 缩略图在存储后端中的 key
+	SidecarKey        string         `json:"-"`                                       // 上传时附带的 .xmp 附属文件 key
+	PublicKey         string         `json:"-"`                                       // 抹去元数据后公开的原图副本 key，为空时直接公开原图
+	ContentHash       string         `json:"-"`                                       // 原图内容的 SHA-256（十六进制），未删除的照片中唯一，用于检测重复上传，不对外返回
+	OriginalFilename  string         `json:"original_filename"`                       // 上传时的文件名
+	PerceptualHash    string         `json:"perceptual_hash"`                         // 缩略图的差异哈希（64 位十六进制），用于查找近似重复的照片
+	ColorHistogram    string         `json:"-"`                                       // 缩略图的颜色直方图（64 格十六进制），用于查找相似的照片
+	MetadataPrivacy   string         `json:"metadata_privacy"`                        // 公开原图的元数据处理方式：keep、strip_gps、strip_all，为空时使用全局配置
+	WatermarkDisabled bool           `json:"watermark_disabled" gorm:"default:false"` // 公开的图片不加水印
+	RenditionVersion  int            `json:"-" gorm:"default:0"`                      // 公开图片的版本，重新处理或重新生成水印后递增，用于 /img 地址
+	Width             int            `json:"width"`                                   // 按 EXIF 方向校正后的显示宽度
+	Height            int            `json:"height"`                                  // 按 EXIF 方向校正后的显示高度
+	AspectRatio       float64        `json:"aspect_ratio"`
+	FileSize          int64          `json:"file_size"`
+	MimeType          string         `json:"mime_type"`
+	ColorSpace        string         `json:"color_space"`     // 嵌入的 ICC 配置文件名称，如 "Display P3"，为空表示没有配置文件（按 sRGB 处理）
+	ColorConverted    bool           `json:"color_converted"` // 缩略图和各尺寸版本是否从 color_space 转换为 sRGB
+	BlurHash          string         `json:"blurhash"`
+	LQIP              string         `json:"lqip" gorm:"column:lqip;type:text"` // data URI 形式的低质量预览图
+	DominantColor     string         `json:"dominant_color"`                    // #rrggbb
+	ProcessingStatus  string         `json:"processing_status" gorm:"default:ready;index"`
+	ProcessingError   string         `json:"processing_error,omitempty"`
+	Location          string         `json:"location"`
+	Author            string         `json:"author"`                 // 作者，可从 IPTC By-line 读取
+	Copyright         string         `json:"copyright"`              // 版权声明，可从 IPTC CopyrightNotice 读取
+	Latitude          *float64       `json:"latitude" gorm:"index"`  // GPS 十进制度数，南纬为负
+	Longitude         *float64       `json:"longitude" gorm:"index"` // GPS 十进制度数，西经为负
+	Altitude          *float64       `json:"altitude"`               // 海拔（米）
+	ShotDate          *time.Time     `json:"shot_date"`
+	ShotDateOffset    string         `json:"shot_date_offset"` // 拍摄时区相对 UTC 的偏移，如 "+08:00"，为空表示时区未知（shot_date 按 UTC 保存本地时间）
+	Year              int            `json:"year"`
+	CameraMake        string         `json:"camera_make"`
+	CameraModel       string         `json:"camera_model"`
+	LensMake          string         `json:"lens_make"`
+	Lens              string         `json:"lens"`
+	FocalLength       float64        `json:"focal_length"`                                      // 焦距（毫米）
+	FocalLength35mm   int            `json:"focal_length_35mm" gorm:"column:focal_length_35mm"` // 等效 35mm 焦距（毫米）
+	Aperture          string         `json:"aperture"`
+	ShutterSpeed      string         `json:"shutter_speed"`
+	ISO               int            `json:"iso"`
+	ExposureBias      *float64       `json:"exposure_bias"`           // 曝光补偿（EV）
+	ExposureProgram   string         `json:"exposure_program"`        // manual、normal、aperture_priority、shutter_priority 等
+	MeteringMode      string         `json:"metering_mode"`           // average、center_weighted、spot、multi_spot、pattern、partial、other
+	WhiteBalance      string         `json:"white_balance"`           // auto、manual
+	Flash             string         `json:"flash"`                   // fired、not_fired、no_flash
+	Tags              string         `json:"tags"`                    // JSON array stored as string
+	Rating            int            `json:"rating" gorm:"default:0"` // 0-5 星，-1 表示已拒绝
+	Label             string         `json:"label" gorm:"index"`      // 颜色标签，如 Red
+	IsFeatured        bool           `json:"is_featured" gorm:"default:false"`
+	ViewCount         int            `json:"view_count" gorm:"default:0"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index"`
+
+	Renditions []PhotoRendition `json:"renditions,omitempty" gorm:"foreignKey:PhotoID"`
+	Palette    []PhotoColor     `json:"palette,omitempty" gorm:"foreignKey:PhotoID"`
+}
+
+// PhotoMetadata 照片的完整元数据，处理照片时从文件中读取
+//
+// Data 为 JSON 文本，按来源分组（如 {"exif": {"IFD/Exif": {"FNumber": 2.8}}}），
+// 可以用 SQLite 的 json_extract 直接查询而不需要重新读取文件
+type PhotoMetadata struct {
+	PhotoID   uint      `json:"photo_id" gorm:"primaryKey;autoIncrement:false"`
+	Data      string    `json:"-" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PhotoRendition 照片的响应式尺寸版本，可直接用于 srcset
+//
+// 预生成（eager）模式下保存在数据库中；按需（on_demand）模式下不落库，
+// 由接口返回指向 /img 的签名地址
+type PhotoRendition struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	PhotoID   uint      `json:"-" gorm:"index;not null"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height,omitempty"`
+	Format    string    `json:"format"` // jpeg, webp, avif
+	Size      int64     `json:"size,omitempty"`
+	Key       string    `json:"-"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"-"`
+}
+
+// PhotoColor 照片调色板中的一种颜色，处理照片时从缩略图中提取
+//
+// 同时保存 CIELAB 坐标，按颜色筛选时直接在数据库中计算色差
+type PhotoColor struct {
+	ID       uint    `json:"-" gorm:"primaryKey"`
+	PhotoID  uint    `json:"-" gorm:"index;not null"`
+	Position int     `json:"-"`      // 按占比从高到低的顺序
+	Color    string  `json:"color"`  // #rrggbb
+	Weight   float64 `json:"weight"` // 在画面中的占比（0-1）
+	LabL     float64 `json:"-"`
+	LabA     float64 `json:"-"`
+	LabB     float64 `json:"-"`
+}
+
+// 后台任务状态
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// Job 持久化的后台任务
+type Job struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Type        string     `json:"type" gorm:"not null;index"`
+	PhotoID     uint       `json:"photo_id" gorm:"index"`
+	Status      string     `json:"status" gorm:"not null;index"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	LastError   string     `json:"last_error"`
+	RunAt       time.Time  `json:"run_at" gorm:"index"` // 最早可执行时间，重试时按退避时间推后
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
 type Album struct {
-	ID           uint           `json:"id" gorm:"primaryKey"`
-	Name         string         `json:"name" gorm:"not null"`
-	Description  string         `json:"description"`
-	CoverPhotoID *uint          `json:"cover_photo_id"`
-	Password     string         `json:"-"` // 密码不返回给前端
-	IsProtected  bool           `json:"is_protected" gorm:"default:false"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
-	Photos       []Photo        `json:"photos" gorm:"many2many:album_photos;"`
+	ID                uint           `json:"id" gorm:"primaryKey"`
+	Name              string         `json:"name" gorm:"not null"`
+	Description       string         `json:"description"`
+	CoverPhotoID      *uint          `json:"cover_photo_id"`
+	Password          string         `json:"-"` // 密码不返回给前端
+	IsProtected       bool           `json:"is_protected" gorm:"default:false"`
+	WatermarkDisabled bool           `json:"watermark_disabled" gorm:"default:false"` // 相册中的照片公开的图片不加水印
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index"`
+	Photos            []Photo        `json:"photos" gorm:"many2many:album_photos;"`
 }
 
 type AlbumPhoto struct {
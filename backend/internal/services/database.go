@@ -1,6 +1,9 @@
 package services
 
 import (
+	"fmt"
+	"strings"
+
 	"picsite/internal/config"
 	"picsite/internal/models"
 
@@ -13,7 +16,7 @@ var DB *gorm.DB
 
 func InitDB(cfg *config.Config) error {
 	var err error
-	DB, err = gorm.Open(sqlite.Open(cfg.DBPath), &gorm.Config{
+	DB, err = gorm.Open(sqlite.Open(sqliteDSN(cfg.DBPath)), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
 	if err != nil {
@@ -21,11 +24,64 @@ func InitDB(cfg *config.Config) error {
 	}
 
 	// 自动迁移
-	err = DB.AutoMigrate(&models.Photo{}, &models.Album{}, &models.User{}, &models.AlbumPhoto{})
-	if err != nil {
+	return AutoMigrate(DB)
+}
+
+// sqliteDSN 为数据库路径加上 busy_timeout，后台任务和请求同时写入时等待锁而不是直接报错
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "?") {
+		return path
+	}
+	return path + "?_pragma=busy_timeout(5000)"
+}
+
+// AutoMigrate 迁移所有数据表
+func AutoMigrate(db *gorm.DB) error {
+	if err := clearDuplicateContentHashes(db); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(
+		&models.Photo{},
+		&models.PhotoRendition{},
+		&models.PhotoColor{},
+		&models.PhotoMetadata{},
+		&models.Job{},
+		&models.Album{},
+		&models.User{},
+		&models.AlbumPhoto{},
+	); err != nil {
 		return err
 	}
 
+	// 内容哈希在未删除的照片中唯一，同时上传相同的文件时只有一张能写入；替换之前的普通索引
+	if db.Migrator().HasIndex(&models.Photo{}, "idx_photos_content_hash") {
+		if err := db.Migrator().DropIndex(&models.Photo{}, "idx_photos_content_hash"); err != nil {
+			return err
+		}
+	}
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + contentHashIndex +
+		" ON photos(content_hash) WHERE deleted_at IS NULL AND content_hash <> ''").Error
+}
+
+// contentHashIndex photos.content_hash 上的唯一索引
+const contentHashIndex = "idx_photos_content_hash_unique"
+
+// clearDuplicateContentHashes 创建 content_hash 唯一索引前，清空内容重复的照片（保留最早的一张）的内容哈希
+func clearDuplicateContentHashes(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.Photo{}, "content_hash") || migrator.HasIndex(&models.Photo{}, contentHashIndex) {
+		return nil
+	}
+
+	result := db.Exec("UPDATE photos SET content_hash = '' WHERE deleted_at IS NULL AND content_hash <> '' AND id NOT IN " +
+		"(SELECT MIN(id) FROM photos WHERE deleted_at IS NULL AND content_hash <> '' GROUP BY content_hash)")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		fmt.Printf("Cleared content hash of %d duplicate photos\n", result.RowsAffected)
+	}
 	return nil
 }
 
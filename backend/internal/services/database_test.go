@@ -21,7 +21,7 @@ func TestInitDB(t *testing.T) {
 		}
 
 		// 测试自动迁移
-		err = DB.AutoMigrate(&models.Photo{}, &models.Album{}, &models.User{}, &models.AlbumPhoto{})
+		err = AutoMigrate(DB)
 		if err != nil {
 			t.Fatalf("Failed to migrate database: %v", err)
 		}
@@ -30,6 +30,15 @@ func TestInitDB(t *testing.T) {
 		if !DB.Migrator().HasTable(&models.Photo{}) {
 			t.Error("Expected Photos table to exist")
 		}
+		if !DB.Migrator().HasTable(&models.PhotoRendition{}) {
+			t.Error("Expected PhotoRenditions table to exist")
+		}
+		if !DB.Migrator().HasTable(&models.PhotoMetadata{}) {
+			t.Error("Expected PhotoMetadata table to exist")
+		}
+		if !DB.Migrator().HasTable(&models.Job{}) {
+			t.Error("Expected Jobs table to exist")
+		}
 		if !DB.Migrator().HasTable(&models.Album{}) {
 			t.Error("Expected Albums table to exist")
 		}
@@ -73,3 +82,55 @@ func TestGetDB(t *testing.T) {
 		}
 	})
 }
+
+func TestContentHashUnique(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if err := AutoMigrate(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	first := models.Photo{Title: "first", ContentHash: "abc"}
+	if err := db.Create(&first).Error; err != nil {
+		t.Fatalf("Failed to create photo: %v", err)
+	}
+	if err := db.Create(&models.Photo{Title: "copy", ContentHash: "abc"}).Error; err == nil {
+		t.Error("Expected duplicate content hash to be rejected")
+	}
+
+	// 没有内容哈希的照片和已删除的照片不受限制
+	for i := 0; i < 2; i++ {
+		if err := db.Create(&models.Photo{Title: "legacy"}).Error; err != nil {
+			t.Errorf("Expected photos without content hash to be allowed: %v", err)
+		}
+	}
+	db.Delete(&first)
+	if err := db.Create(&models.Photo{Title: "again", ContentHash: "abc"}).Error; err != nil {
+		t.Errorf("Expected content hash of deleted photo to be reusable: %v", err)
+	}
+
+	t.Run("existing duplicates", func(t *testing.T) {
+		// 早期的数据库中可能已有重复的内容哈希，迁移时保留最早的一张
+		db.Exec("DROP INDEX " + contentHashIndex)
+		older := models.Photo{Title: "older", ContentHash: "def"}
+		newer := models.Photo{Title: "newer", ContentHash: "def"}
+		db.Create(&older)
+		db.Create(&newer)
+
+		if err := AutoMigrate(db); err != nil {
+			t.Fatalf("Failed to migrate database: %v", err)
+		}
+		db.First(&older, older.ID)
+		db.First(&newer, newer.ID)
+		if older.ContentHash != "def" || newer.ContentHash != "" {
+			t.Errorf("Expected only the oldest photo to keep its hash, got %q and %q", older.ContentHash, newer.ContentHash)
+		}
+		if !db.Migrator().HasIndex(&models.Photo{}, contentHashIndex) {
+			t.Error("Expected unique index to be created")
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"picsite/internal/models"
+
+	"github.com/disintegration/imaging"
+	"gorm.io/gorm"
+)
+
+// 近似重复检测参数
+const (
+	DefaultDuplicateThreshold = 10 // 默认的汉明距离阈值（64 位中不同的位数）
+	MaxDuplicateThreshold     = 32 // 超过一半的位不同已经与随机图片无异
+	dHashSize                 = 8
+)
+
+// PerceptualHash 计算图片的差异哈希（dHash），以 16 位十六进制返回
+//
+// 图片缩小为 9x8 的灰度图后逐行比较相邻像素的亮度，得到 64 位哈希。
+// 缩放、重新压缩、轻微裁剪和调色后哈希基本不变，可按汉明距离判断是否为同一张照片
+func PerceptualHash(img image.Image) string {
+	small := imaging.Grayscale(imaging.Resize(img, dHashSize+1, dHashSize, imaging.Box))
+
+	var hash uint64
+	for y := 0; y < dHashSize; y++ {
+		for x := 0; x < dHashSize; x++ {
+			hash <<= 1
+			if small.Pix[small.PixOffset(x, y)] < small.Pix[small.PixOffset(x+1, y)] {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// HammingDistance 返回两个感知哈希不同的位数，任意一个无法解析时返回 -1
+func HammingDistance(a, b string) int {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// PhotoHash 参与近似重复分组的照片
+type PhotoHash struct {
+	ID             uint
+	PerceptualHash string
+}
+
+// DuplicateGroup 一组近似重复的照片
+type DuplicateGroup struct {
+	PhotoIDs    []uint // 按 ID 升序
+	MaxDistance int    // 组内两两之间最大的汉明距离
+}
+
+// GroupNearDuplicates 把汉明距离不超过 threshold 的照片分为一组
+//
+// 分组可以传递：A 与 B 相近、B 与 C 相近时三张照片在同一组。只返回至少两张照片的组，
+// 按组内最小的 ID 排序。两两比较的复杂度为 O(n²)，几万张照片内可以在一秒内完成
+func GroupNearDuplicates(photos []PhotoHash, threshold int) []DuplicateGroup {
+	type entry struct {
+		id   uint
+		hash uint64
+	}
+	entries := make([]entry, 0, len(photos))
+	for _, photo := range photos {
+		hash, err := strconv.ParseUint(photo.PerceptualHash, 16, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{photo.ID, hash})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+
+	// 并查集，根节点总是组内下标最小的照片
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if bits.OnesCount64(entries[i].hash^entries[j].hash) > threshold {
+				continue
+			}
+			if a, b := find(i), find(j); a != b {
+				parent[max(a, b)] = min(a, b)
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	var roots []int
+	for i := range entries {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+
+	var groups []DuplicateGroup
+	for _, root := range roots {
+		indexes := members[root]
+		if len(indexes) < 2 {
+			continue
+		}
+		group := DuplicateGroup{}
+		for n, i := range indexes {
+			group.PhotoIDs = append(group.PhotoIDs, entries[i].id)
+			for _, j := range indexes[n+1:] {
+				group.MaxDistance = max(group.MaxDistance, bits.OnesCount64(entries[i].hash^entries[j].hash))
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// FindNearDuplicates 查找所有已计算感知哈希的照片中的近似重复
+func FindNearDuplicates(threshold int) ([]DuplicateGroup, error) {
+	var photos []PhotoHash
+	if err := DB.Model(&models.Photo{}).
+		Select("id", "perceptual_hash").
+		Where("perceptual_hash <> ''").
+		Find(&photos).Error; err != nil {
+		return nil, err
+	}
+	return GroupNearDuplicates(photos, threshold), nil
+}
+
+// MergePhotos 合并近似重复的照片：保留 keepID，把其他照片所在的相册、作为封面的相册和标签
+// 转移到保留的照片上，然后删除其他照片的记录
+//
+// 返回保留的照片和被删除的照片，被删除照片的文件由调用方清理。
+// 任意一张照片不存在时返回 gorm.ErrRecordNotFound
+func MergePhotos(keepID uint, mergeIDs []uint) (*models.Photo, []models.Photo, error) {
+	var keep models.Photo
+	var merged []models.Photo
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&keep, keepID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", mergeIDs).Find(&merged).Error; err != nil {
+			return err
+		}
+		if len(merged) != len(mergeIDs) {
+			return fmt.Errorf("merge photos: %w", gorm.ErrRecordNotFound)
+		}
+
+		// 转移相册，保留的照片已在相册中时不重复添加
+		var memberships []models.AlbumPhoto
+		if err := tx.Where("photo_id IN ?", mergeIDs).Order("photo_id").Find(&memberships).Error; err != nil {
+			return err
+		}
+		for _, membership := range memberships {
+			albumPhoto := models.AlbumPhoto{AlbumID: membership.AlbumID, PhotoID: keep.ID, SortOrder: membership.SortOrder}
+			var count int64
+			tx.Model(&models.AlbumPhoto{}).Where("album_id = ? AND photo_id = ?", albumPhoto.AlbumID, albumPhoto.PhotoID).Count(&count)
+			if count > 0 {
+				continue
+			}
+			if err := tx.Create(&albumPhoto).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("photo_id IN ?", mergeIDs).Delete(&models.AlbumPhoto{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Album{}).Where("cover_photo_id IN ?", mergeIDs).
+			Update("cover_photo_id", keep.ID).Error; err != nil {
+			return err
+		}
+
+		// 合并标签，保持原有顺序并去重
+		tagLists := []string{keep.Tags}
+		for _, photo := range merged {
+			tagLists = append(tagLists, photo.Tags)
+		}
+		if tags := mergeTags(tagLists...); tags != keep.Tags {
+			if err := tx.Model(&keep).Update("tags", tags).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("id IN ?", mergeIDs).Delete(&models.Photo{}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &keep, merged, nil
+}
+
+// mergeTags 合并多个逗号分隔的标签列表，去除空白和重复的标签
+func mergeTags(lists ...string) string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, list := range lists {
+		for _, tag := range strings.Split(list, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return strings.Join(tags, ",")
+}
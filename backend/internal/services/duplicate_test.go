@@ -0,0 +1,86 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// patternImage 生成带明暗起伏的测试图片，seed 不同时图案不同
+func patternImage(width, height int, seed float64) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			v := 128 + 60*math.Sin(fx*7*seed+fy*3) + 60*math.Cos(fy*5*seed-fx*2*seed)
+			img.Set(x, y, color.NRGBA{uint8(v), uint8(255 - v), uint8(v / 2), 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := patternImage(800, 600, 1)
+	hash := PerceptualHash(original)
+	if len(hash) != 16 {
+		t.Fatalf("Expected 16 hex digits, got %q", hash)
+	}
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		similar bool
+	}{
+		{"resized", imaging.Resize(original, 200, 0, imaging.Lanczos), true},
+		{"slightly cropped", imaging.Crop(original, image.Rect(16, 12, 784, 588)), true},
+		{"brightened", imaging.AdjustBrightness(original, 10), true},
+		{"different picture", patternImage(800, 600, 2.3), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distance := HammingDistance(hash, PerceptualHash(tt.img))
+			if similar := distance <= DefaultDuplicateThreshold; similar != tt.similar {
+				t.Errorf("Expected similar=%v, got distance %d", tt.similar, distance)
+			}
+		})
+	}
+
+	if d := HammingDistance(hash, "not-a-hash"); d != -1 {
+		t.Errorf("Expected -1 for invalid hash, got %d", d)
+	}
+}
+
+func TestGroupNearDuplicates(t *testing.T) {
+	photos := []PhotoHash{
+		{5, "ffffffffffffffff"},
+		{1, "0000000000000000"},
+		{3, "0000000000000003"}, // 与 1 相差 2 位
+		{4, "000000000000000f"}, // 与 3 相差 2 位，与 1 相差 4 位
+		{2, "f0f0f0f0f0f0f0f0"},
+		{6, "fffffffffffffffe"}, // 与 5 相差 1 位
+		{7, ""},
+	}
+
+	groups := GroupNearDuplicates(photos, 2)
+	want := []DuplicateGroup{
+		{PhotoIDs: []uint{1, 3, 4}, MaxDistance: 4},
+		{PhotoIDs: []uint{5, 6}, MaxDistance: 1},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("Expected %+v, got %+v", want, groups)
+	}
+
+	if groups := GroupNearDuplicates(photos, 0); len(groups) != 0 {
+		t.Errorf("Expected no groups at threshold 0, got %+v", groups)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	if got := mergeTags("travel, night", "", "night,city", " travel ,sea"); got != "travel,night,city,sea" {
+		t.Errorf("Unexpected tags %q", got)
+	}
+}
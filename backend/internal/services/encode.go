@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+)
+
+// 支持的输出格式
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+// IsSupportedFormat 判断是否为支持的输出格式
+func IsSupportedFormat(format string) bool {
+	switch format {
+	case FormatJPEG, FormatWebP, FormatAVIF:
+		return true
+	}
+	return false
+}
+
+// FormatExtension 返回输出格式对应的文件扩展名
+func FormatExtension(format string) string {
+	switch format {
+	case FormatWebP:
+		return ".webp"
+	case FormatAVIF:
+		return ".avif"
+	default:
+		return ".jpg"
+	}
+}
+
+// FormatContentType 返回输出格式对应的 MIME 类型
+func FormatContentType(format string) string {
+	switch format {
+	case FormatWebP:
+		return "image/webp"
+	case FormatAVIF:
+		return "image/avif"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// encodeImage 按指定格式编码图片，quality 为 0 时使用 ImageConfig 中该格式的默认质量
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	if quality <= 0 {
+		quality = defaultQuality(format)
+	}
+
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatWebP:
+		return webp.Encode(w, img, webp.Options{Quality: quality, Method: webp.DefaultMethod})
+	case FormatAVIF:
+		return avif.Encode(w, img, avif.Options{
+			Quality:           quality,
+			Speed:             avif.DefaultSpeed,
+			ChromaSubsampling: image.YCbCrSubsampleRatio420,
+		})
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// defaultQuality 返回 ImageConfig 中指定格式的编码质量
+func defaultQuality(format string) int {
+	switch format {
+	case FormatWebP:
+		return ImageConfig.WebPQuality
+	case FormatAVIF:
+		return ImageConfig.AVIFQuality
+	default:
+		return ImageConfig.JPEGQuality
+	}
+}
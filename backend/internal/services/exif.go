@@ -2,8 +2,9 @@ package services
 
 import (
 	"fmt"
-	"log"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,14 +14,62 @@ import (
 
 // EXIFData EXIF 数据结构
 type EXIFData struct {
-	CameraModel  string     `json:"camera_model"`
-	Lens         string     `json:"lens"`
-	Aperture     string     `json:"aperture"`
-	ShutterSpeed string     `json:"shutter_speed"`
-	ISO          int        `json:"iso"`
-	ShotDate     *time.Time `json:"shot_date"`
+	CameraMake      string     `json:"camera_make"`
+	CameraModel     string     `json:"camera_model"`
+	LensMake        string     `json:"lens_make"`
+	Lens            string     `json:"lens"`
+	FocalLength     float64    `json:"focal_length"`      // 毫米
+	FocalLength35mm int        `json:"focal_length_35mm"` // 毫米
+	Aperture        string     `json:"aperture"`
+	ShutterSpeed    string     `json:"shutter_speed"`
+	ISO             int        `json:"iso"`
+	ExposureBias    *float64   `json:"exposure_bias"` // EV
+	ExposureProgram string     `json:"exposure_program"`
+	MeteringMode    string     `json:"metering_mode"`
+	WhiteBalance    string     `json:"white_balance"`
+	Flash           string     `json:"flash"`
+	ShotDate        *time.Time `json:"shot_date"`        // 拍摄时区已知时带有对应的时区偏移，否则按 UTC 保存拍摄地的本地时间
+	ShotDateOffset  string     `json:"shot_date_offset"` // 拍摄时区相对 UTC 的偏移，如 "+08:00"，未知时为空
+	Orientation     int        `json:"orientation"`      // 1-8，0 表示未指定
+	Latitude        *float64   `json:"latitude"`         // 十进制度数，南纬为负
+	Longitude       *float64   `json:"longitude"`        // 十进制度数，西经为负
+	Altitude        *float64   `json:"altitude"`         // 米，海平面以下为负
 }
 
+// 曝光程序、测光模式和白平衡的取值，对应 EXIF 规范中的编号
+var (
+	exposurePrograms = map[int]string{
+		1: "manual",
+		2: "normal",
+		3: "aperture_priority",
+		4: "shutter_priority",
+		5: "creative",
+		6: "action",
+		7: "portrait",
+		8: "landscape",
+	}
+	meteringModes = map[int]string{
+		1:   "average",
+		2:   "center_weighted",
+		3:   "spot",
+		4:   "multi_spot",
+		5:   "pattern",
+		6:   "partial",
+		255: "other",
+	}
+	whiteBalances = map[int]string{
+		0: "auto",
+		1: "manual",
+	}
+)
+
+// 闪光灯状态
+const (
+	FlashFired    = "fired"
+	FlashNotFired = "not_fired"
+	FlashNone     = "no_flash" // 相机没有闪光灯
+)
+
 // ExtractEXIF 从图片文件提取 EXIF 信息
 func ExtractEXIF(filePath string) (*EXIFData, error) {
 	// 读取文件
@@ -29,14 +78,35 @@ func ExtractEXIF(filePath string) (*EXIFData, error) {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	// 检查是否包含 EXIF 数据
-	entries, _, err := exif.GetFlatExifData(data, nil)
+	return ParseEXIF(data), nil
+}
+
+// readEXIFTags 读取图片中的所有 EXIF 标签，没有 EXIF 数据时返回 nil
+func readEXIFTags(data []byte) []exif.ExifTag {
+	// 定位 EXIF 数据块（JPEG 中位于 APP1 段内，HEIF 中为 Exif 项，GetFlatExifData 需要从 TIFF 头开始的数据）
+	rawExif := FindHEIFExif(data)
+	if rawExif == nil {
+		var err error
+		if rawExif, err = exif.SearchAndExtractExif(data); err != nil {
+			return nil
+		}
+	}
+
+	entries, _, err := exif.GetFlatExifData(rawExif, nil)
 	if err != nil {
-		// 没有 EXIF 数据不是错误，返回空结构
-		return &EXIFData{}, nil
+		return nil
 	}
+	return entries
+}
+
+// ParseEXIF 从图片内容解析 EXIF 信息，没有 EXIF 数据时返回空结构
+func ParseEXIF(data []byte) *EXIFData {
+	// 没有 EXIF 数据不是错误，返回空结构
+	entries := readEXIFTags(data)
 
 	exifData := &EXIFData{}
+	var gps gpsTags
+	var shot shotDateTags
 
 	// 解析 EXIF 数据
 	for _, entry := range entries {
@@ -45,16 +115,63 @@ func ExtractEXIF(filePath string) (*EXIFData, error) {
 		}
 
 		switch entry.TagName {
+		case "Make":
+			if cameraMake, ok := entry.Value.(string); ok {
+				exifData.CameraMake = strings.TrimSpace(cameraMake)
+			}
+
 		case "Model":
 			if model, ok := entry.Value.(string); ok {
 				exifData.CameraModel = strings.TrimSpace(model)
 			}
 
+		case "LensMake":
+			if lensMake, ok := entry.Value.(string); ok {
+				exifData.LensMake = strings.TrimSpace(lensMake)
+			}
+
 		case "LensModel":
 			if lens, ok := entry.Value.(string); ok {
 				exifData.Lens = strings.TrimSpace(lens)
 			}
 
+		case "FocalLength":
+			if v, ok := rationalValue(entry.Value); ok && v > 0 {
+				exifData.FocalLength = math.Round(v*10) / 10
+			}
+
+		case "FocalLengthIn35mmFilm":
+			if v, ok := shortValue(entry.Value); ok {
+				exifData.FocalLength35mm = v
+			}
+
+		case "ExposureBiasValue":
+			if v, ok := rationalValue(entry.Value); ok {
+				// 常见取值为 1/3 EV 的倍数，保留两位小数
+				bias := math.Round(v*100) / 100
+				exifData.ExposureBias = &bias
+			}
+
+		case "ExposureProgram":
+			if v, ok := shortValue(entry.Value); ok {
+				exifData.ExposureProgram = exposurePrograms[v]
+			}
+
+		case "MeteringMode":
+			if v, ok := shortValue(entry.Value); ok {
+				exifData.MeteringMode = meteringModes[v]
+			}
+
+		case "WhiteBalance":
+			if v, ok := shortValue(entry.Value); ok {
+				exifData.WhiteBalance = whiteBalances[v]
+			}
+
+		case "Flash":
+			if v, ok := shortValue(entry.Value); ok {
+				exifData.Flash = flashStatus(v)
+			}
+
 		case "FNumber":
 			exifData.Aperture = formatAperture(entry.Value)
 
@@ -62,24 +179,248 @@ func ExtractEXIF(filePath string) (*EXIFData, error) {
 			exifData.ShutterSpeed = formatShutterSpeed(entry.Value)
 
 		case "ISOSpeedRatings":
-			if iso, ok := entry.Value.([]uint16); ok && len(iso) > 0 {
-				exifData.ISO = int(iso[0])
-			} else if iso, ok := entry.Value.(uint16); ok {
-				exifData.ISO = int(iso)
+			if iso, ok := shortValue(entry.Value); ok {
+				exifData.ISO = iso
+			}
+
+		case "Orientation":
+			if orientation, ok := shortValue(entry.Value); ok {
+				exifData.Orientation = orientation
 			}
 
 		case "DateTimeOriginal":
-			if dateStr, ok := entry.Value.(string); ok {
-				// EXIF 日期格式: "2006:01:02 15:04:05"
-				t, err := time.Parse("2006:01:02 15:04:05", dateStr)
-				if err == nil {
-					exifData.ShotDate = &t
-				}
+			shot.original, _ = entry.Value.(string)
+		case "SubSecTimeOriginal":
+			shot.subSec, _ = entry.Value.(string)
+		case "OffsetTimeOriginal":
+			shot.offset, _ = entry.Value.(string)
+		case "OffsetTime":
+			shot.fallbackOffset, _ = entry.Value.(string)
+		case "GPSDateStamp":
+			shot.gpsDate, _ = entry.Value.(string)
+		case "GPSTimeStamp":
+			shot.gpsTime, _ = entry.Value.([]exifcommon.Rational)
+
+		case "GPSLatitude":
+			gps.latitude = rationalDegrees(entry.Value)
+		case "GPSLatitudeRef":
+			gps.latitudeRef, _ = entry.Value.(string)
+		case "GPSLongitude":
+			gps.longitude = rationalDegrees(entry.Value)
+		case "GPSLongitudeRef":
+			gps.longitudeRef, _ = entry.Value.(string)
+		case "GPSAltitude":
+			if v, ok := rationalValue(entry.Value); ok {
+				gps.altitude = &v
+			}
+		case "GPSAltitudeRef":
+			if ref, ok := entry.Value.([]byte); ok && len(ref) > 0 {
+				gps.belowSeaLevel = ref[0] == 1
 			}
 		}
 	}
 
-	return exifData, nil
+	gps.apply(exifData)
+	shot.apply(exifData)
+
+	return exifData
+}
+
+// maxUTCOffset 合法的时区偏移范围（秒），实际使用中的时区在 -12:00 到 +14:00 之间
+const maxUTCOffset = 14 * 3600
+
+// shotDateTags 收集拍摄时间相关标签，所有标签读取完后再确定拍摄时间和时区
+type shotDateTags struct {
+	original, subSec       string
+	offset, fallbackOffset string // OffsetTimeOriginal（EXIF 2.31），以及作为备用的 OffsetTime
+	gpsDate                string // "2006:01:02"，UTC
+	gpsTime                []exifcommon.Rational
+}
+
+// apply 解析 DateTimeOriginal（拍摄地的本地时间）并确定时区：
+// 优先使用 OffsetTimeOriginal，没有时用 GPS 时间（UTC）与本地时间的差值推算，都没有时按 UTC 保存本地时间
+func (s shotDateTags) apply(exifData *EXIFData) {
+	// EXIF 日期格式: "2006:01:02 15:04:05"
+	t, err := time.Parse("2006:01:02 15:04:05", trimEXIFString(s.original))
+	if err != nil {
+		return
+	}
+
+	// SubSecTimeOriginal 为秒的小数部分，如 "12" 表示 0.12 秒
+	if digits := trimEXIFString(s.subSec); digits != "" && len(digits) <= 9 && strings.Trim(digits, "0123456789") == "" {
+		nanos, _ := strconv.Atoi((digits + "000000000")[:9])
+		t = t.Add(time.Duration(nanos))
+	}
+
+	offset, ok := ParseUTCOffset(trimEXIFString(s.offset))
+	if !ok {
+		offset, ok = ParseUTCOffset(trimEXIFString(s.fallbackOffset))
+	}
+	if !ok {
+		offset, ok = s.gpsOffset(t)
+	}
+	if ok {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.FixedZone("", offset))
+		exifData.ShotDateOffset = FormatUTCOffset(offset)
+	}
+	exifData.ShotDate = &t
+}
+
+// gpsOffset 根据 GPS 时间推算时区偏移，按 15 分钟取整以消除相机时钟的误差
+func (s shotDateTags) gpsOffset(local time.Time) (int, bool) {
+	date, err := time.Parse("2006:01:02", trimEXIFString(s.gpsDate))
+	if err != nil || len(s.gpsTime) != 3 {
+		return 0, false
+	}
+
+	var seconds float64
+	for i, unit := range []float64{3600, 60, 1} {
+		if s.gpsTime[i].Denominator == 0 {
+			return 0, false
+		}
+		seconds += float64(s.gpsTime[i].Numerator) / float64(s.gpsTime[i].Denominator) * unit
+	}
+	utc := date.Add(time.Duration(seconds * float64(time.Second)))
+
+	// local 以 UTC 保存本地时间，两者之差即为时区偏移
+	offset := int(math.Round(local.Sub(utc).Minutes()/15)) * 15 * 60
+	if offset < -maxUTCOffset || offset > maxUTCOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+// ParseUTCOffset 解析 "+08:00" 形式的时区偏移，返回相对 UTC 的秒数
+func ParseUTCOffset(value string) (int, bool) {
+	if len(value) != 6 || (value[0] != '+' && value[0] != '-') || value[3] != ':' {
+		return 0, false
+	}
+	hours, err1 := strconv.Atoi(value[1:3])
+	minutes, err2 := strconv.Atoi(value[4:6])
+	if err1 != nil || err2 != nil || minutes >= 60 {
+		return 0, false
+	}
+
+	offset := hours*3600 + minutes*60
+	if offset > maxUTCOffset {
+		return 0, false
+	}
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return offset, true
+}
+
+// FormatUTCOffset 将相对 UTC 的秒数格式化为 "+08:00" 形式
+func FormatUTCOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
+// trimEXIFString 去掉 ASCII 标签值末尾的空字符和空白
+func trimEXIFString(value string) string {
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+// gpsTags 收集 GPS 相关标签，所有标签读取完后再合并坐标和方向
+type gpsTags struct {
+	latitude, longitude       *float64
+	latitudeRef, longitudeRef string
+	altitude                  *float64
+	belowSeaLevel             bool
+}
+
+func (g gpsTags) apply(exifData *EXIFData) {
+	// 经纬度必须成对出现，超出范围的值视为无效
+	if g.latitude != nil && g.longitude != nil && *g.latitude <= 90 && *g.longitude <= 180 {
+		lat, lng := *g.latitude, *g.longitude
+		if strings.EqualFold(strings.TrimSpace(g.latitudeRef), "S") {
+			lat = -lat
+		}
+		if strings.EqualFold(strings.TrimSpace(g.longitudeRef), "W") {
+			lng = -lng
+		}
+		exifData.Latitude = &lat
+		exifData.Longitude = &lng
+	}
+	if g.altitude != nil {
+		alt := *g.altitude
+		if g.belowSeaLevel {
+			alt = -alt
+		}
+		exifData.Altitude = &alt
+	}
+}
+
+// rationalDegrees 将度、分、秒三个有理数转换为十进制度数
+func rationalDegrees(value interface{}) *float64 {
+	v, ok := value.([]exifcommon.Rational)
+	if !ok || len(v) == 0 {
+		return nil
+	}
+
+	var degrees float64
+	for i, divisor := range []float64{1, 60, 3600} {
+		if i >= len(v) {
+			break
+		}
+		if v[i].Denominator == 0 {
+			return nil
+		}
+		degrees += float64(v[i].Numerator) / float64(v[i].Denominator) / divisor
+	}
+	return &degrees
+}
+
+// rationalValue 读取单个有理数（包括有符号有理数）
+func rationalValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case []exifcommon.Rational:
+		if len(v) > 0 && v[0].Denominator != 0 {
+			return float64(v[0].Numerator) / float64(v[0].Denominator), true
+		}
+	case exifcommon.Rational:
+		if v.Denominator != 0 {
+			return float64(v.Numerator) / float64(v.Denominator), true
+		}
+	case []exifcommon.SignedRational:
+		if len(v) > 0 && v[0].Denominator != 0 {
+			return float64(v[0].Numerator) / float64(v[0].Denominator), true
+		}
+	case exifcommon.SignedRational:
+		if v.Denominator != 0 {
+			return float64(v.Numerator) / float64(v.Denominator), true
+		}
+	}
+	return 0, false
+}
+
+// shortValue 读取单个 SHORT 类型的值
+func shortValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case []uint16:
+		if len(v) > 0 {
+			return int(v[0]), true
+		}
+	case uint16:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// flashStatus 将 Flash 标签的位标志转换为闪光灯状态
+func flashStatus(value int) string {
+	switch {
+	case value&0x20 != 0: // 没有闪光灯功能
+		return FlashNone
+	case value&0x01 != 0:
+		return FlashFired
+	default:
+		return FlashNotFired
+	}
 }
 
 // formatAperture 格式化光圈值
@@ -117,13 +458,3 @@ func formatShutterSpeed(value interface{}) string {
 	}
 	return ""
 }
-
-// ExtractEXIFFromUpload 从上传的图片提取 EXIF
-func ExtractEXIFFromUpload(filePath string) *EXIFData {
-	exifData, err := ExtractEXIF(filePath)
-	if err != nil {
-		log.Printf("Failed to extract EXIF: %v", err)
-		return &EXIFData{}
-	}
-	return exifData
-}
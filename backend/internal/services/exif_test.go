@@ -0,0 +1,280 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"picsite/internal/models"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// buildEXIF 使用 go-exif 构造 TIFF 格式的 EXIF 数据
+func buildEXIF(t *testing.T, build func(root *exif.IfdBuilder)) []byte {
+	t.Helper()
+
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		t.Fatalf("Failed to create IFD mapping: %v", err)
+	}
+	ti := exif.NewTagIndex()
+	root := exif.NewIfdBuilder(im, ti, exifcommon.IfdStandardIfdIdentity, exifcommon.EncodeDefaultByteOrder)
+	build(root)
+
+	tiff, err := exif.NewIfdByteEncoder().EncodeToExif(root)
+	if err != nil {
+		t.Fatalf("Failed to encode EXIF: %v", err)
+	}
+	return tiff
+}
+
+// withEXIF 构造 EXIF 段并插入到 JPEG 的 SOI 之后
+func withEXIF(t *testing.T, data []byte, build func(root *exif.IfdBuilder)) []byte {
+	t.Helper()
+
+	payload := append([]byte("Exif\x00\x00"), buildEXIF(t, build)...)
+	length := len(payload) + 2
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// setEXIFTag 在指定 IFD 中设置标准标签，ifdPath 例如 "IFD"、"IFD/Exif"、"IFD/GPSInfo"
+func setEXIFTag(t *testing.T, root *exif.IfdBuilder, ifdPath, name string, value interface{}) {
+	t.Helper()
+
+	ib, err := exif.GetOrCreateIbFromRootIb(root, ifdPath)
+	if err != nil {
+		t.Fatalf("Failed to get IFD %s: %v", ifdPath, err)
+	}
+	if err := ib.SetStandardWithName(name, value); err != nil {
+		t.Fatalf("Failed to set %s: %v", name, err)
+	}
+}
+
+func TestParseEXIFGPS(t *testing.T) {
+	t.Run("southern and western hemispheres are negative", func(t *testing.T) {
+		data := withEXIF(t, testJPEG(t, 10, 10), func(root *exif.IfdBuilder) {
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSLatitudeRef", "S")
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSLatitude", []exifcommon.Rational{{Numerator: 33, Denominator: 1}, {Numerator: 51, Denominator: 1}, {Numerator: 5400, Denominator: 100}})
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSLongitudeRef", "W")
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSLongitude", []exifcommon.Rational{{Numerator: 70, Denominator: 1}, {Numerator: 30, Denominator: 1}, {Numerator: 0, Denominator: 1}})
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSAltitudeRef", []byte{1})
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSAltitude", []exifcommon.Rational{{Numerator: 125, Denominator: 10}})
+		})
+
+		exifData := ParseEXIF(data)
+		if exifData.Latitude == nil || exifData.Longitude == nil || exifData.Altitude == nil {
+			t.Fatalf("Expected GPS coordinates, got %+v", exifData)
+		}
+		if math.Abs(*exifData.Latitude-(-33.865)) > 1e-9 {
+			t.Errorf("Expected latitude -33.865, got %v", *exifData.Latitude)
+		}
+		if *exifData.Longitude != -70.5 {
+			t.Errorf("Expected longitude -70.5, got %v", *exifData.Longitude)
+		}
+		if *exifData.Altitude != -12.5 {
+			t.Errorf("Expected altitude -12.5, got %v", *exifData.Altitude)
+		}
+	})
+
+	t.Run("latitude without longitude is ignored", func(t *testing.T) {
+		data := withEXIF(t, testJPEG(t, 10, 10), func(root *exif.IfdBuilder) {
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSLatitudeRef", "N")
+			setEXIFTag(t, root, "IFD/GPSInfo", "GPSLatitude", []exifcommon.Rational{{Numerator: 10, Denominator: 1}, {Numerator: 0, Denominator: 1}, {Numerator: 0, Denominator: 1}})
+		})
+
+		if exifData := ParseEXIF(data); exifData.Latitude != nil || exifData.Longitude != nil {
+			t.Errorf("Expected no coordinates, got %v, %v", exifData.Latitude, exifData.Longitude)
+		}
+	})
+
+	t.Run("no EXIF", func(t *testing.T) {
+		if exifData := ParseEXIF(testJPEG(t, 10, 10)); exifData.Latitude != nil {
+			t.Error("Expected no coordinates for image without EXIF")
+		}
+	})
+}
+
+func TestParseEXIFShotDate(t *testing.T) {
+	gpsTime := []exifcommon.Rational{{Numerator: 6, Denominator: 1}, {Numerator: 29, Denominator: 1}, {Numerator: 5850, Denominator: 100}}
+
+	tests := []struct {
+		name       string
+		build      func(root *exif.IfdBuilder)
+		expected   string
+		offset     string
+		expectNone bool
+	}{
+		{
+			name: "offset and subseconds",
+			build: func(root *exif.IfdBuilder) {
+				setEXIFTag(t, root, "IFD/Exif", "DateTimeOriginal", "2024:05:01 14:30:00")
+				setEXIFTag(t, root, "IFD/Exif", "SubSecTimeOriginal", "12")
+				setEXIFTag(t, root, "IFD/Exif", "OffsetTimeOriginal", "+08:00")
+			},
+			expected: "2024-05-01T14:30:00.12+08:00",
+			offset:   "+08:00",
+		},
+		{
+			name: "offset from GPS time",
+			build: func(root *exif.IfdBuilder) {
+				setEXIFTag(t, root, "IFD/Exif", "DateTimeOriginal", "2024:05:01 02:30:00")
+				setEXIFTag(t, root, "IFD/GPSInfo", "GPSDateStamp", "2024:05:01")
+				setEXIFTag(t, root, "IFD/GPSInfo", "GPSTimeStamp", gpsTime)
+			},
+			expected: "2024-05-01T02:30:00-04:00",
+			offset:   "-04:00",
+		},
+		{
+			name: "OffsetTimeOriginal takes precedence over GPS",
+			build: func(root *exif.IfdBuilder) {
+				setEXIFTag(t, root, "IFD/Exif", "DateTimeOriginal", "2024:05:01 02:30:00")
+				setEXIFTag(t, root, "IFD/Exif", "OffsetTimeOriginal", "+05:30")
+				setEXIFTag(t, root, "IFD/GPSInfo", "GPSDateStamp", "2024:05:01")
+				setEXIFTag(t, root, "IFD/GPSInfo", "GPSTimeStamp", gpsTime)
+			},
+			expected: "2024-05-01T02:30:00+05:30",
+			offset:   "+05:30",
+		},
+		{
+			name: "unknown offset keeps local time as UTC",
+			build: func(root *exif.IfdBuilder) {
+				setEXIFTag(t, root, "IFD/Exif", "DateTimeOriginal", "2024:05:01 14:30:00")
+				setEXIFTag(t, root, "IFD/Exif", "OffsetTimeOriginal", "   :  ")
+			},
+			expected: "2024-05-01T14:30:00Z",
+		},
+		{
+			name: "blank date",
+			build: func(root *exif.IfdBuilder) {
+				setEXIFTag(t, root, "IFD/Exif", "DateTimeOriginal", "    :  :     :  :  ")
+			},
+			expectNone: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exifData := ParseEXIF(withEXIF(t, testJPEG(t, 10, 10), tt.build))
+			if tt.expectNone {
+				if exifData.ShotDate != nil {
+					t.Errorf("Expected no shot date, got %v", exifData.ShotDate)
+				}
+				return
+			}
+			if exifData.ShotDate == nil {
+				t.Fatal("Expected shot date")
+			}
+			if got := exifData.ShotDate.Format(time.RFC3339Nano); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+			if exifData.ShotDateOffset != tt.offset {
+				t.Errorf("Expected offset %q, got %q", tt.offset, exifData.ShotDateOffset)
+			}
+		})
+	}
+}
+
+func TestParseUTCOffset(t *testing.T) {
+	for value, expected := range map[string]int{"+08:00": 8 * 3600, "-03:30": -(3*3600 + 30*60), "+00:00": 0, "+14:00": 14 * 3600} {
+		offset, ok := ParseUTCOffset(value)
+		if !ok || offset != expected {
+			t.Errorf("ParseUTCOffset(%q) = %d, %v; expected %d", value, offset, ok, expected)
+		}
+		if formatted := FormatUTCOffset(offset); formatted != value {
+			t.Errorf("FormatUTCOffset(%d) = %q; expected %q", offset, formatted, value)
+		}
+	}
+	for _, value := range []string{"", "08:00", "+8:00", "+08:60", "+15:00", "Z", "+0800"} {
+		if _, ok := ParseUTCOffset(value); ok {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestParseEXIFShootingParameters(t *testing.T) {
+	data := withEXIF(t, testJPEG(t, 10, 10), func(root *exif.IfdBuilder) {
+		setEXIFTag(t, root, "IFD", "Make", "Canon")
+		setEXIFTag(t, root, "IFD", "Model", "Canon EOS R5")
+		setEXIFTag(t, root, "IFD/Exif", "LensMake", "Sigma")
+		setEXIFTag(t, root, "IFD/Exif", "FocalLength", []exifcommon.Rational{{Numerator: 350, Denominator: 10}})
+		setEXIFTag(t, root, "IFD/Exif", "FocalLengthIn35mmFilm", []uint16{52})
+		setEXIFTag(t, root, "IFD/Exif", "ExposureBiasValue", []exifcommon.SignedRational{{Numerator: -2, Denominator: 3}})
+		setEXIFTag(t, root, "IFD/Exif", "ExposureProgram", []uint16{3})
+		setEXIFTag(t, root, "IFD/Exif", "MeteringMode", []uint16{5})
+		setEXIFTag(t, root, "IFD/Exif", "WhiteBalance", []uint16{1})
+		setEXIFTag(t, root, "IFD/Exif", "Flash", []uint16{0x19}) // 自动模式，已闪光
+	})
+
+	exifData := ParseEXIF(data)
+	if exifData.CameraMake != "Canon" || exifData.LensMake != "Sigma" {
+		t.Errorf("Unexpected makes: %q, %q", exifData.CameraMake, exifData.LensMake)
+	}
+	if exifData.FocalLength != 35 || exifData.FocalLength35mm != 52 {
+		t.Errorf("Unexpected focal lengths: %v, %v", exifData.FocalLength, exifData.FocalLength35mm)
+	}
+	if exifData.ExposureBias == nil || *exifData.ExposureBias != -0.67 {
+		t.Errorf("Expected exposure bias -0.67, got %v", exifData.ExposureBias)
+	}
+	if exifData.ExposureProgram != "aperture_priority" || exifData.MeteringMode != "pattern" || exifData.WhiteBalance != "manual" {
+		t.Errorf("Unexpected modes: %q, %q, %q", exifData.ExposureProgram, exifData.MeteringMode, exifData.WhiteBalance)
+	}
+	if exifData.Flash != FlashFired {
+		t.Errorf("Expected flash %q, got %q", FlashFired, exifData.Flash)
+	}
+}
+
+func TestFlashStatus(t *testing.T) {
+	tests := map[int]string{
+		0x00: FlashNotFired,
+		0x01: FlashFired,
+		0x10: FlashNotFired, // 强制关闭
+		0x18: FlashNotFired, // 自动模式，未闪光
+		0x20: FlashNone,
+		0x4F: FlashFired, // 强制闪光，防红眼
+	}
+	for value, want := range tests {
+		if got := flashStatus(value); got != want {
+			t.Errorf("flashStatus(%#x) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestFillFromEXIF(t *testing.T) {
+	zero := 0.0
+	bias := -1.0
+	exifData := &EXIFData{CameraMake: "Nikon", FocalLength: 50, ExposureBias: &bias, Flash: FlashNotFired}
+
+	t.Run("fills blank fields", func(t *testing.T) {
+		updates := map[string]interface{}{}
+		fillFromEXIF(updates, &models.Photo{}, exifData)
+		if updates["camera_make"] != "Nikon" || updates["focal_length"] != 50.0 ||
+			updates["exposure_bias"] != -1.0 || updates["flash"] != FlashNotFired {
+			t.Errorf("Unexpected updates: %v", updates)
+		}
+	})
+
+	t.Run("keeps existing values including zero exposure bias", func(t *testing.T) {
+		updates := map[string]interface{}{}
+		fillFromEXIF(updates, &models.Photo{CameraMake: "Manual", ExposureBias: &zero}, exifData)
+		if _, ok := updates["camera_make"]; ok {
+			t.Error("Expected camera make to be kept")
+		}
+		if _, ok := updates["exposure_bias"]; ok {
+			t.Error("Expected exposure bias 0 to be kept")
+		}
+	})
+
+	t.Run("unreadable image fills nothing", func(t *testing.T) {
+		updates := map[string]interface{}{}
+		fillFromEXIF(updates, &models.Photo{}, ParseEXIF([]byte("not an image")))
+		if len(updates) != 0 {
+			t.Errorf("Expected no updates, got %v", updates)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidBBox 边界框参数格式错误
+var ErrInvalidBBox = errors.New("invalid bounding box")
+
+// clusterCellsPerTile 每个 256px 地图瓦片在每个方向上划分的聚合网格数（约 64px 一格）
+const clusterCellsPerTile = 4
+
+// MaxClusterZoom 达到该缩放级别后不再聚合，直接返回所有点
+const MaxClusterZoom = 18
+
+// BBox 经纬度边界框
+//
+// MinLng 大于 MaxLng 时表示跨越 180 度经线的范围
+type BBox struct {
+	MinLng, MinLat, MaxLng, MaxLat float64
+}
+
+// ParseBBox 解析 "minLng,minLat,maxLng,maxLat" 格式（与 GeoJSON 顺序一致）的边界框
+func ParseBBox(value string) (BBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return BBox{}, ErrInvalidBBox
+	}
+
+	var nums [4]float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(n) {
+			return BBox{}, ErrInvalidBBox
+		}
+		nums[i] = n
+	}
+
+	b := BBox{MinLng: nums[0], MinLat: nums[1], MaxLng: nums[2], MaxLat: nums[3]}
+	if b.MinLat > b.MaxLat || b.MinLat < -90 || b.MaxLat > 90 ||
+		b.MinLng < -180 || b.MinLng > 180 || b.MaxLng < -180 || b.MaxLng > 180 {
+		return BBox{}, ErrInvalidBBox
+	}
+	return b, nil
+}
+
+// CrossesAntimeridian 边界框是否跨越 180 度经线
+func (b BBox) CrossesAntimeridian() bool {
+	return b.MinLng > b.MaxLng
+}
+
+// GeoPoint 地图上的一张照片
+type GeoPoint struct {
+	ID            uint    `json:"id"`
+	Title         string  `json:"title"`
+	Latitude      float64 `json:"latitude"`
+	Longitude     float64 `json:"longitude"`
+	ThumbnailPath string  `json:"thumbnail_path"`
+	DominantColor string  `json:"dominant_color,omitempty"`
+}
+
+// GeoCluster 按缩放级别聚合的一组照片
+type GeoCluster struct {
+	Latitude  float64    `json:"latitude"`  // 组内照片的平均位置
+	Longitude float64    `json:"longitude"` // 组内照片的平均位置
+	Count     int        `json:"count"`
+	BBox      [4]float64 `json:"bbox"`  // 组内照片的范围 [minLng, minLat, maxLng, maxLat]，可用于放大地图
+	Cover     GeoPoint   `json:"cover"` // 组内第一张照片，用作预览
+}
+
+// ClusterGeoPoints 按 Web 墨卡托瓦片网格将相邻的照片聚合
+//
+// 只有一张照片的网格不聚合，直接放在返回的 points 中；
+// zoom 大于等于 MaxClusterZoom 时不做聚合
+func ClusterGeoPoints(points []GeoPoint, zoom int) ([]GeoCluster, []GeoPoint) {
+	if zoom >= MaxClusterZoom {
+		return []GeoCluster{}, points
+	}
+	zoom = max(zoom, 0)
+
+	cells := float64(uint(1)<<uint(zoom)) * clusterCellsPerTile
+	type cell struct{ x, y int }
+	groups := make(map[cell][]GeoPoint)
+	var order []cell
+
+	for _, p := range points {
+		x, y := mercatorTile(p.Latitude, p.Longitude)
+		c := cell{int(x * cells), int(y * cells)}
+		if _, ok := groups[c]; !ok {
+			order = append(order, c)
+		}
+		groups[c] = append(groups[c], p)
+	}
+
+	clusters := []GeoCluster{}
+	singles := []GeoPoint{}
+	for _, c := range order {
+		group := groups[c]
+		if len(group) == 1 {
+			singles = append(singles, group[0])
+			continue
+		}
+
+		cluster := GeoCluster{
+			Count: len(group),
+			BBox:  [4]float64{180, 90, -180, -90},
+			Cover: group[0],
+		}
+		for _, p := range group {
+			cluster.Latitude += p.Latitude
+			cluster.Longitude += p.Longitude
+			cluster.BBox[0] = math.Min(cluster.BBox[0], p.Longitude)
+			cluster.BBox[1] = math.Min(cluster.BBox[1], p.Latitude)
+			cluster.BBox[2] = math.Max(cluster.BBox[2], p.Longitude)
+			cluster.BBox[3] = math.Max(cluster.BBox[3], p.Latitude)
+		}
+		cluster.Latitude /= float64(len(group))
+		cluster.Longitude /= float64(len(group))
+		clusters = append(clusters, cluster)
+	}
+
+	// 数量多的聚合排在前面
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Count > clusters[j].Count })
+	return clusters, singles
+}
+
+// mercatorTile 将经纬度转换为 Web 墨卡托投影下 [0, 1) 范围内的坐标
+func mercatorTile(lat, lng float64) (float64, float64) {
+	// 墨卡托投影在两极无定义，限制在常用范围内
+	lat = math.Max(-85.05112878, math.Min(85.05112878, lat))
+	x := (lng + 180) / 360
+	sin := math.Sin(lat * math.Pi / 180)
+	y := 0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)
+	return math.Min(math.Max(x, 0), 0.999999), math.Min(math.Max(y, 0), 0.999999)
+}
@@ -0,0 +1,72 @@
+package services
+
+import "testing"
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"116.0,39.5,117.0,40.5", false},
+		{"170,-20,-170,-10", false}, // 跨越 180 度经线
+		{"116,39.5,117", true},
+		{"a,b,c,d", true},
+		{"0,50,10,40", true}, // 最小纬度大于最大纬度
+		{"0,-91,10,0", true},
+		{"-181,0,10,10", true},
+	}
+
+	for _, tt := range tests {
+		b, err := ParseBBox(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBBox(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if err == nil && tt.value == "170,-20,-170,-10" && !b.CrossesAntimeridian() {
+			t.Errorf("Expected %q to cross the antimeridian", tt.value)
+		}
+	}
+}
+
+func TestClusterGeoPoints(t *testing.T) {
+	points := []GeoPoint{
+		{ID: 1, Latitude: 39.9042, Longitude: 116.4074}, // 北京
+		{ID: 2, Latitude: 39.9142, Longitude: 116.4174},
+		{ID: 3, Latitude: 39.8942, Longitude: 116.3974},
+		{ID: 4, Latitude: 31.2304, Longitude: 121.4737}, // 上海
+	}
+
+	t.Run("nearby photos are clustered at low zoom", func(t *testing.T) {
+		clusters, singles := ClusterGeoPoints(points, 5)
+		if len(clusters) != 1 || clusters[0].Count != 3 {
+			t.Fatalf("Expected one cluster of 3, got %+v", clusters)
+		}
+		if len(singles) != 1 || singles[0].ID != 4 {
+			t.Errorf("Expected Shanghai as a single point, got %+v", singles)
+		}
+
+		c := clusters[0]
+		if c.Cover.ID != 1 {
+			t.Errorf("Expected first photo as cover, got %d", c.Cover.ID)
+		}
+		if c.BBox != [4]float64{116.3974, 39.8942, 116.4174, 39.9142} {
+			t.Errorf("Unexpected cluster bbox: %v", c.BBox)
+		}
+		if c.Latitude < 39.9041 || c.Latitude > 39.9043 {
+			t.Errorf("Expected cluster centered on Beijing, got %v", c.Latitude)
+		}
+	})
+
+	t.Run("everything clusters at zoom 0", func(t *testing.T) {
+		clusters, singles := ClusterGeoPoints(points, 0)
+		if len(clusters) != 1 || clusters[0].Count != 4 || len(singles) != 0 {
+			t.Errorf("Expected all photos in one cluster, got %+v, %+v", clusters, singles)
+		}
+	})
+
+	t.Run("no clustering at max zoom", func(t *testing.T) {
+		clusters, singles := ClusterGeoPoints(points, MaxClusterZoom)
+		if len(clusters) != 0 || len(singles) != 4 {
+			t.Errorf("Expected 4 single points, got %d clusters and %d points", len(clusters), len(singles))
+		}
+	})
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"mime"
+	"strings"
+
+	"github.com/gen2brain/heic"
+)
+
+// heifBrands ftyp 中表示 HEIC/HEIF 静态图片的品牌
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true, "msf1": true,
+}
+
+var errMalformedHEIF = errors.New("malformed heif")
+
+func init() {
+	// 部分系统的 MIME 表中没有 HEIF，存储和下载时需要正确的 Content-Type
+	mime.AddExtensionType(".heic", "image/heic")
+	mime.AddExtensionType(".heif", "image/heif")
+}
+
+// IsHEIF 按扩展名判断是否为 HEIC/HEIF 图片
+//
+// HEIF 解码时已经按 irot/imir 属性旋转，EXIF 中的方向只是记录，不能再次应用；
+// 大多数浏览器无法显示 HEIF，公开的原图和各尺寸版本都会转换为 JPEG/WebP
+func IsHEIF(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".heic", ".heif":
+		return true
+	}
+	return false
+}
+
+// isHEIFData 通过 ftyp 盒判断内容是否为 HEIC/HEIF
+func isHEIFData(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	end := min(int(binary.BigEndian.Uint32(data)), len(data))
+	// 主品牌和兼容品牌
+	for pos := 8; pos+4 <= end; pos += 4 {
+		if pos == 12 {
+			continue // minor_version
+		}
+		if heifBrands[string(data[pos:pos+4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeHEIFConfig 读取 HEIF 图片的尺寸（已按旋转属性校正）
+func decodeHEIFConfig(data []byte) (image.Config, error) {
+	return heic.DecodeConfig(bytes.NewReader(data))
+}
+
+// isoBox ISOBMFF 盒
+type isoBox struct {
+	boxType string
+	body    []byte
+}
+
+// readBoxes 读取 data 中依次排列的盒
+func readBoxes(data []byte) ([]isoBox, error) {
+	var boxes []isoBox
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return boxes, errMalformedHEIF
+		}
+		size := uint64(binary.BigEndian.Uint32(data[pos:]))
+		boxType := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0: // 一直到文件末尾
+			size = uint64(len(data) - pos)
+		case 1: // 64 位长度
+			if pos+16 > len(data) {
+				return boxes, errMalformedHEIF
+			}
+			size = binary.BigEndian.Uint64(data[pos+8:])
+			header = 16
+		}
+		if size < uint64(header) || size > uint64(len(data)-pos) {
+			return boxes, errMalformedHEIF
+		}
+
+		end := pos + int(size)
+		boxes = append(boxes, isoBox{boxType: boxType, body: data[pos+header : end]})
+		pos = end
+	}
+	return boxes, nil
+}
+
+// findBox 返回第一个指定类型的盒
+func findBox(boxes []isoBox, boxType string) (isoBox, bool) {
+	for _, box := range boxes {
+		if box.boxType == boxType {
+			return box, true
+		}
+	}
+	return isoBox{}, false
+}
+
+// FindHEIFExif 返回 HEIF 中 Exif 项从 TIFF 头开始的数据，没有时返回 nil
+func FindHEIFExif(data []byte) []byte {
+	if !isHEIFData(data) {
+		return nil
+	}
+	item, err := readHEIFItem(data, "Exif")
+	if err != nil || len(item) < 4 {
+		return nil
+	}
+
+	// Exif 项以 4 字节的 TIFF 头偏移开始，之后通常是 "Exif\0\0"
+	offset := uint64(binary.BigEndian.Uint32(item))
+	if offset > uint64(len(item)-4) {
+		return nil
+	}
+	tiff := item[4+offset:]
+	if bytes.HasPrefix(tiff, exifHeader) {
+		tiff = tiff[len(exifHeader):]
+	}
+	if len(tiff) < 8 || (string(tiff[:2]) != "II" && string(tiff[:2]) != "MM") {
+		return nil
+	}
+	return tiff
+}
+
+// readHEIFItem 读取 meta 盒中第一个指定类型的项的内容
+func readHEIFItem(data []byte, itemType string) ([]byte, error) {
+	boxes, err := readBoxes(data)
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+	meta, ok := findBox(boxes, "meta")
+	if !ok || len(meta.body) < 4 {
+		return nil, errMalformedHEIF
+	}
+	// meta 为 FullBox，跳过版本和标志
+	children, err := readBoxes(meta.body[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	iinf, ok := findBox(children, "iinf")
+	if !ok {
+		return nil, errMalformedHEIF
+	}
+	itemID, err := findHEIFItemID(iinf.body, itemType)
+	if err != nil {
+		return nil, err
+	}
+
+	iloc, ok := findBox(children, "iloc")
+	if !ok {
+		return nil, errMalformedHEIF
+	}
+	return readHEIFItemData(data, iloc.body, itemID)
+}
+
+// findHEIFItemID 在 iinf 盒中查找指定类型的项
+func findHEIFItemID(iinf []byte, itemType string) (uint32, error) {
+	if len(iinf) < 6 {
+		return 0, errMalformedHEIF
+	}
+	pos := 6 // 版本、标志和 entry_count
+	if iinf[0] != 0 {
+		pos = 8
+	}
+	entries, err := readBoxes(iinf[min(pos, len(iinf)):])
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		body := entry.body
+		// 只有版本 2 及以上的 infe 带有项类型
+		if entry.boxType != "infe" || len(body) < 4 || body[0] < 2 {
+			continue
+		}
+		var id uint32
+		pos := 4
+		if body[0] == 2 {
+			if len(body) < pos+2 {
+				continue
+			}
+			id = uint32(binary.BigEndian.Uint16(body[pos:]))
+			pos += 2
+		} else {
+			if len(body) < pos+4 {
+				continue
+			}
+			id = binary.BigEndian.Uint32(body[pos:])
+			pos += 4
+		}
+		pos += 2 // item_protection_index
+		if len(body) >= pos+4 && string(body[pos:pos+4]) == itemType {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("heif item %q not found", itemType)
+}
+
+// readHEIFItemData 按 iloc 盒中的位置读取项的内容，只支持以文件偏移定位的项
+func readHEIFItemData(data, iloc []byte, itemID uint32) ([]byte, error) {
+	r := &boxReader{buf: iloc}
+	version := r.uint(1)
+	r.skip(3) // 标志
+	sizes := r.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+
+	itemCount := r.uint(2)
+	if version == 2 {
+		itemCount = r.uint(4)
+	}
+
+	for i := uint64(0); i < itemCount && r.err == nil; i++ {
+		id := r.uint(2)
+		if version == 2 {
+			id = r.uint(4)
+		}
+		constructionMethod := uint64(0)
+		if version == 1 || version == 2 {
+			constructionMethod = r.uint(2) & 0x0F
+		}
+		r.skip(2) // data_reference_index
+		baseOffset := r.uint(baseOffsetSize)
+
+		var item []byte
+		extentCount := r.uint(2)
+		for j := uint64(0); j < extentCount && r.err == nil; j++ {
+			r.skip(indexSize)
+			offset := baseOffset + r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			if uint32(id) != itemID {
+				continue
+			}
+			if constructionMethod != 0 {
+				return nil, fmt.Errorf("unsupported heif construction method %d", constructionMethod)
+			}
+			if offset > uint64(len(data)) || length > uint64(len(data))-offset {
+				return nil, errMalformedHEIF
+			}
+			item = append(item, data[offset:offset+length]...)
+		}
+		if uint32(id) == itemID && r.err == nil {
+			return item, nil
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return nil, fmt.Errorf("heif item %d has no location", itemID)
+}
+
+// boxReader 按大端序读取盒中的字段，越界时记录错误并返回 0
+type boxReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *boxReader) uint(size int) uint64 {
+	if r.err != nil || size == 0 {
+		return 0
+	}
+	if size > 8 || r.pos+size > len(r.buf) {
+		r.err = errMalformedHEIF
+		return 0
+	}
+	var v uint64
+	for _, b := range r.buf[r.pos : r.pos+size] {
+		v = v<<8 | uint64(b)
+	}
+	r.pos += size
+	return v
+}
+
+func (r *boxReader) skip(size int) {
+	if r.err == nil && r.pos+size > len(r.buf) {
+		r.err = errMalformedHEIF
+	}
+	r.pos += size
+}
+
+// setTIFFOrientation 修改 IFD0 中的方向标签，没有该标签时不做修改
+func setTIFFOrientation(tiff []byte, orientation uint16) {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		// Orientation 为单个 SHORT，值直接保存在条目中
+		if order.Uint16(tiff[entry:]) == tagOrientation && order.Uint16(tiff[entry+2:]) == 3 {
+			order.PutUint16(tiff[entry+8:], orientation)
+			return
+		}
+	}
+}
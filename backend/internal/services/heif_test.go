@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"picsite/internal/models"
+)
+
+// readSampleHEIF 读取 512x512 的 HEIC 测试图片
+func readSampleHEIF(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/sample.heic")
+	if err != nil {
+		t.Fatalf("Failed to read sample HEIC: %v", err)
+	}
+	return data
+}
+
+// isoBoxBytes 构造一个 ISOBMFF 盒
+func isoBoxBytes(boxType string, body ...[]byte) []byte {
+	content := bytes.Join(body, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(content)+8))
+	return append(append(out, boxType...), content...)
+}
+
+// buildHEIF 构造只包含一个 Exif 项（没有图像数据）的 HEIF 容器
+func buildHEIF(exifItem []byte) []byte {
+	ftyp := isoBoxBytes("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	infe := isoBoxBytes("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif\x00"))
+	iinf := isoBoxBytes("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	iloc := func(offset int) []byte {
+		body := []byte{1, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 0, 0, 1}
+		body = binary.BigEndian.AppendUint32(body, uint32(offset))
+		body = binary.BigEndian.AppendUint32(body, uint32(len(exifItem)))
+		return isoBoxBytes("iloc", body)
+	}
+
+	// 先按占位偏移计算 meta 的长度，再填入 mdat 中的实际偏移
+	meta := isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, iloc(0))
+	offset := len(ftyp) + len(meta) + 8
+	meta = isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, iloc(offset))
+	return bytes.Join([][]byte{ftyp, meta, isoBoxBytes("mdat", exifItem)}, nil)
+}
+
+// heifExifItem 构造 HEIF Exif 项的内容：4 字节偏移、"Exif\0\0" 和 TIFF 数据
+func heifExifItem(tiff []byte) []byte {
+	item := binary.BigEndian.AppendUint32(nil, uint32(len(exifHeader)))
+	return append(append(item, exifHeader...), tiff...)
+}
+
+// withHEIFExif 替换 HEIF 中 Exif 项的内容：新内容放在文件末尾的 mdat 中，并修改 iloc 中的位置
+//
+// 只支持 iloc 版本 0、偏移和长度均为 4 字节、只有一个区段的文件
+func withHEIFExif(t *testing.T, data, tiff []byte) []byte {
+	t.Helper()
+	out := append([]byte{}, data...)
+
+	boxes, _ := readBoxes(out)
+	meta, _ := findBox(boxes, "meta")
+	children, err := readBoxes(meta.body[4:])
+	if err != nil {
+		t.Fatalf("Failed to read meta: %v", err)
+	}
+	iinf, _ := findBox(children, "iinf")
+	id, err := findHEIFItemID(iinf.body, "Exif")
+	if err != nil {
+		t.Fatalf("Sample has no Exif item: %v", err)
+	}
+
+	iloc, _ := findBox(children, "iloc")
+	r := &boxReader{buf: iloc.body}
+	if r.uint(1) != 0 || r.uint(3) != 0 || r.uint(1) != 0x44 {
+		t.Fatal("Unsupported iloc layout")
+	}
+	baseOffsetSize := int(r.uint(1) >> 4)
+	count := r.uint(2)
+
+	item := heifExifItem(tiff)
+	offset := uint64(len(out) + 8)
+	out = append(out, isoBoxBytes("mdat", item)...)
+
+	for i := uint64(0); i < count; i++ {
+		itemID := r.uint(2)
+		r.skip(2)
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+		if itemID == uint64(id) {
+			if extents != 1 {
+				t.Fatal("Unsupported extent count")
+			}
+			binary.BigEndian.PutUint32(iloc.body[r.pos:], uint32(offset-base))
+			binary.BigEndian.PutUint32(iloc.body[r.pos+4:], uint32(len(item)))
+			return out
+		}
+		r.skip(int(extents) * 8)
+	}
+	t.Fatal("Exif item location not found")
+	return nil
+}
+
+func TestFindHEIFExif(t *testing.T) {
+	tiff := buildEXIF(t, privateEXIF(t))
+	data := buildHEIF(heifExifItem(tiff))
+
+	if found := FindHEIFExif(data); !bytes.Equal(found, tiff) {
+		t.Fatalf("Expected TIFF data, got %d bytes", len(found))
+	}
+	if exifData := ParseEXIF(data); exifData.Latitude == nil {
+		t.Errorf("Expected GPS from HEIF EXIF, got %+v", exifData)
+	}
+
+	t.Run("not HEIF", func(t *testing.T) {
+		if found := FindHEIFExif(testJPEG(t, 10, 10)); found != nil {
+			t.Errorf("Expected nil for JPEG, got %d bytes", len(found))
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		if found := FindHEIFExif(data[:len(data)-20]); found != nil {
+			t.Errorf("Expected nil for truncated file, got %d bytes", len(found))
+		}
+	})
+}
+
+func TestSetTIFFOrientation(t *testing.T) {
+	tiff := buildEXIF(t, privateEXIF(t))
+	setTIFFOrientation(tiff, 1)
+
+	data := testJPEG(t, 10, 10)
+	data = append(append(append([]byte{}, data[:2]...), jpegAPP1(tiff)...), data[2:]...)
+	if orientation := ParseEXIF(data).Orientation; orientation != 1 {
+		t.Errorf("Expected orientation 1, got %d", orientation)
+	}
+}
+
+// jpegAPP1 构造包含 EXIF 的 APP1 段
+func jpegAPP1(tiff []byte) []byte {
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	segment := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestInspectHEIF(t *testing.T) {
+	// 方向标签不能再次应用：解码结果已经按 irot 旋转
+	data := withHEIFExif(t, readSampleHEIF(t), buildEXIF(t, privateEXIF(t)))
+
+	info, err := InspectImage(data, ".heic")
+	if err != nil {
+		t.Fatalf("InspectImage failed: %v", err)
+	}
+	if info.Width != 512 || info.Height != 512 || info.MimeType != "image/heic" {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+	if orientation := ParseEXIF(data).Orientation; orientation != 6 {
+		t.Errorf("Expected EXIF orientation 6, got %d", orientation)
+	}
+}
+
+func TestProcessPhotoHEIF(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.MetadataPrivacy = MetadataStripGPS
+
+	DB = setupJobsTestDB(t)
+	Store = NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+
+	data := withHEIFExif(t, readSampleHEIF(t), buildEXIF(t, privateEXIF(t)))
+	Store.Put("iphone.heic", bytes.NewReader(data), int64(len(data)), "image/heic")
+
+	for _, mode := range []string{MetadataKeep, MetadataStripGPS, MetadataStripAll} {
+		t.Run(mode, func(t *testing.T) {
+			photo := models.Photo{FilePath: "/uploads/iphone.heic", FileKey: "iphone.heic", MetadataPrivacy: mode}
+			DB.Create(&photo)
+			if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+				t.Fatalf("ProcessPhoto failed: %v", err)
+			}
+
+			DB.First(&photo, photo.ID)
+			if photo.Width != 512 || photo.MimeType != "image/heic" || photo.ThumbnailKey == "" {
+				t.Errorf("Unexpected photo: %+v", photo)
+			}
+			if photo.FileKey != "iphone.heic" {
+				t.Errorf("Expected HEIC to stay the master, got %s", photo.FileKey)
+			}
+			if photo.Latitude == nil || photo.CameraModel == "" {
+				t.Errorf("Expected EXIF from HEIC, got latitude=%v model=%q", photo.Latitude, photo.CameraModel)
+			}
+
+			// 公开的是 JPEG 副本
+			if photo.PublicKey != "iphone_public.jpg" || photo.FilePath != "/uploads/iphone_public.jpg" {
+				t.Fatalf("Expected JPEG public copy, got %s (%s)", photo.FilePath, photo.PublicKey)
+			}
+			public, err := ReadObject(Store, photo.PublicKey)
+			if err != nil {
+				t.Fatalf("Expected public copy: %v", err)
+			}
+			if mimeType := detectMimeType(public, ".jpg"); mimeType != "image/jpeg" {
+				t.Errorf("Expected JPEG public copy, got %s", mimeType)
+			}
+
+			exifData := ParseEXIF(public)
+			switch mode {
+			case MetadataKeep:
+				if exifData.Latitude == nil || exifData.Orientation != 1 {
+					t.Errorf("Expected EXIF with GPS and orientation reset, got %+v", exifData)
+				}
+			case MetadataStripGPS:
+				if exifData.Latitude != nil || exifData.CameraModel == "" || exifData.Orientation != 1 {
+					t.Errorf("Expected EXIF without GPS, got %+v", exifData)
+				}
+			case MetadataStripAll:
+				if tags := exifTagNames(t, public); len(tags) != 0 {
+					t.Errorf("Expected no EXIF, got %v", tags)
+				}
+			}
+		})
+	}
+}
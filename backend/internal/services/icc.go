@@ -0,0 +1,497 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxICCProfileSize ICC 配置文件的大小上限，防止损坏的文件占用过多内存
+const maxICCProfileSize = 4 << 20
+
+// iccJPEGHeader JPEG APP2 段中 ICC 配置文件的标识，之后是 1 字节序号和 1 字节总数
+var iccJPEGHeader = []byte("ICC_PROFILE\x00")
+
+var errMalformedICC = errors.New("malformed icc profile")
+
+// srgbColorants sRGB 三原色在 PCS（D50）中的 XYZ 值，按列依次为红、绿、蓝
+var srgbColorants = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// xyzToLinearSRGB PCS（D50）XYZ 到线性 sRGB 的转换矩阵（Bradford 色适应）
+var xyzToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// ICCProfile 嵌入图片中的 ICC 配置文件
+//
+// 只有 RGB 矩阵/TRC 型配置文件（Display P3、Adobe RGB 等相机和手机常用的配置文件）可以转换为 sRGB，
+// 基于查找表的配置文件（如 CMYK）只记录名称
+type ICCProfile struct {
+	Description string // desc 标签中的名称，例如 "Display P3"
+	ColorSpace  string // 数据颜色空间：RGB、GRAY、CMYK 等
+
+	colorants [3][3]float64 // rXYZ、gXYZ、bXYZ，按列排列
+	curves    [3]iccCurve   // rTRC、gTRC、bTRC
+	matrix    bool          // 是否为完整的矩阵/TRC 型 RGB 配置文件
+}
+
+// Name 返回用于展示的颜色空间名称，没有描述时使用数据颜色空间
+func (p *ICCProfile) Name() string {
+	if p.Description != "" {
+		return p.Description
+	}
+	return p.ColorSpace
+}
+
+// IsSRGB 判断配置文件是否与 sRGB 等效（三原色和色调曲线一致）
+func (p *ICCProfile) IsSRGB() bool {
+	if !p.matrix {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(p.colorants[i][j]-srgbColorants[i][j]) > 0.003 {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		for v := 0; v <= 255; v += 15 {
+			if math.Abs(curve.eval(float64(v)/255)-srgbToLinear(uint8(v))) > 0.005 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CanConvert 判断能否将使用该配置文件的像素转换为 sRGB
+func (p *ICCProfile) CanConvert() bool {
+	return p.matrix
+}
+
+// ParseICCProfile 解析 ICC 配置文件的头部和矩阵/TRC 相关标签
+func ParseICCProfile(data []byte) (*ICCProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errMalformedICC
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 132 || size > len(data) {
+		return nil, errMalformedICC
+	}
+	data = data[:size]
+
+	profile := &ICCProfile{ColorSpace: strings.TrimSpace(string(data[16:20]))}
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	if count > (len(data)-132)/12 {
+		return nil, errMalformedICC
+	}
+	for i := 0; i < count; i++ {
+		entry := data[132+i*12:]
+		offset := uint64(binary.BigEndian.Uint32(entry[4:]))
+		size := uint64(binary.BigEndian.Uint32(entry[8:]))
+		if offset+size > uint64(len(data)) || size < 8 {
+			continue
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	profile.Description = iccDescription(tags["desc"])
+
+	// 矩阵/TRC 型配置文件需要全部六个标签，PCS 必须为 XYZ
+	if profile.ColorSpace != "RGB" || string(data[20:24]) != "XYZ " {
+		return profile, nil
+	}
+	for i, name := range []string{"r", "g", "b"} {
+		xyz, ok := iccXYZ(tags[name+"XYZ"])
+		if !ok {
+			return profile, nil
+		}
+		curve, ok := parseICCCurve(tags[name+"TRC"])
+		if !ok {
+			return profile, nil
+		}
+		for j := 0; j < 3; j++ {
+			profile.colorants[j][i] = xyz[j]
+		}
+		profile.curves[i] = curve
+	}
+	profile.matrix = true
+	return profile, nil
+}
+
+// iccDescription 读取 desc（v2 textDescriptionType）或 mluc（v4）标签中的名称
+func iccDescription(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+	var desc string
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n > 0 && 12+n <= len(tag) {
+			desc = string(bytes.TrimRight(tag[12:12+n], "\x00"))
+		}
+	case "mluc":
+		// 使用第一条记录
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		n := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if offset < 0 || n < 0 || offset+n > len(tag) {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		desc = strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return strings.TrimSpace(desc)
+}
+
+// iccXYZ 读取 XYZType 标签中的第一个 XYZ 值
+func iccXYZ(tag []byte) ([3]float64, bool) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, false
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+i*4:])
+	}
+	return xyz, true
+}
+
+// s15Fixed16 读取 ICC 的有符号 16.16 定点数
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccCurve 色调曲线：将编码值（0-1）转换为线性值
+type iccCurve struct {
+	table  []float64  // curv 类型的采样表，为空时使用参数
+	kind   int        // parametricCurveType 的函数类型，curv 的单个 gamma 按类型 0 处理
+	params [7]float64 // g、a、b、c、d、e、f
+}
+
+// parseICCCurve 解析 curv 或 para 类型的色调曲线
+func parseICCCurve(tag []byte) (iccCurve, bool) {
+	if len(tag) < 12 {
+		return iccCurve{}, false
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return iccCurve{params: [7]float64{1}}, true
+		case n == 1 && len(tag) >= 14:
+			// u8Fixed8Number 表示的 gamma
+			return iccCurve{params: [7]float64{float64(binary.BigEndian.Uint16(tag[12:])) / 256}}, true
+		case n > 1 && 12+n*2 <= len(tag):
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+			}
+			return iccCurve{table: table}, true
+		}
+	case "para":
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		counts := []int{1, 3, 4, 5, 7}
+		if kind >= len(counts) || len(tag) < 12+counts[kind]*4 {
+			return iccCurve{}, false
+		}
+		curve := iccCurve{kind: kind}
+		for i := 0; i < counts[kind]; i++ {
+			curve.params[i] = s15Fixed16(tag[12+i*4:])
+		}
+		return curve, true
+	}
+	return iccCurve{}, false
+}
+
+// eval 计算编码值 x 对应的线性值
+func (c iccCurve) eval(x float64) float64 {
+	if c.table != nil {
+		pos := x * float64(len(c.table)-1)
+		i := int(pos)
+		if i >= len(c.table)-1 {
+			return c.table[len(c.table)-1]
+		}
+		frac := pos - float64(i)
+		return c.table[i]*(1-frac) + c.table[i+1]*frac
+	}
+
+	g, a, b, cc, d, e, f := c.params[0], c.params[1], c.params[2], c.params[3], c.params[4], c.params[5], c.params[6]
+	pow := func(v float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, g)
+	}
+	switch c.kind {
+	case 1:
+		if x >= -b/a {
+			return pow(a*x + b)
+		}
+		return 0
+	case 2:
+		if x >= -b/a {
+			return pow(a*x+b) + cc
+		}
+		return cc
+	case 3:
+		if x >= d {
+			return pow(a*x + b)
+		}
+		return cc * x
+	case 4:
+		if x >= d {
+			return pow(a*x+b) + e
+		}
+		return cc*x + f
+	default:
+		return pow(x)
+	}
+}
+
+// ConvertToSRGB 将使用矩阵/TRC 型 RGB 配置文件的图片转换为 sRGB，超出 sRGB 色域的颜色被截断
+func ConvertToSRGB(img image.Image, profile *ICCProfile) image.Image {
+	if !profile.CanConvert() {
+		return img
+	}
+
+	// 输入：8 位编码值到线性值；输出：线性值（4096 级）到 8 位 sRGB
+	var toLinear [3][256]float64
+	for c := range toLinear {
+		for i := range toLinear[c] {
+			toLinear[c][i] = profile.curves[c].eval(float64(i) / 255)
+		}
+	}
+	const outSteps = 4095
+	var toSRGB [outSteps + 1]uint8
+	for i := range toSRGB {
+		toSRGB[i] = uint8(linearToSRGB(float64(i) / outSteps))
+	}
+
+	// 线性 RGB -> XYZ -> 线性 sRGB
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += xyzToLinearSRGB[i][k] * profile.colorants[k][j]
+			}
+		}
+	}
+	encode := func(v float64) uint8 {
+		return toSRGB[int(math.Round(math.Max(0, math.Min(1, v))*outSteps))]
+	}
+
+	out := imaging.Clone(img)
+	for i := 0; i+3 < len(out.Pix); i += 4 {
+		r, g, b := toLinear[0][out.Pix[i]], toLinear[1][out.Pix[i+1]], toLinear[2][out.Pix[i+2]]
+		out.Pix[i] = encode(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+		out.Pix[i+1] = encode(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+		out.Pix[i+2] = encode(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+	}
+	return out
+}
+
+// FindICCProfile 返回图片中嵌入的 ICC 配置文件，没有时返回 nil
+//
+// 支持 JPEG（APP2）、PNG（iCCP）、WebP（ICCP）以及 HEIF/AVIF（colr 属性），
+// RAW 文件读取其内嵌的 JPEG 预览图中的配置文件
+func FindICCProfile(data []byte, ext string) []byte {
+	if IsRAW(ext) {
+		preview, err := ExtractRAWPreview(data)
+		if err != nil {
+			return nil
+		}
+		data = preview
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return findJPEGICC(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return findPNGICC(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return findWebPICC(data)
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return findISOBMFFICC(data)
+	}
+	return nil
+}
+
+// findJPEGICC 按序号拼接 APP2 段中的 ICC 配置文件
+func findJPEGICC(data []byte) []byte {
+	chunks := make(map[int][]byte)
+	total := 0
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		payload := data[pos+4 : pos+2+length]
+		if marker == 0xE2 && bytes.HasPrefix(payload, iccJPEGHeader) && len(payload) > len(iccJPEGHeader)+2 {
+			seq := int(payload[len(iccJPEGHeader)])
+			total = int(payload[len(iccJPEGHeader)+1])
+			chunks[seq] = payload[len(iccJPEGHeader)+2:]
+		}
+		pos += 2 + length
+	}
+	if len(chunks) == 0 || len(chunks) != total {
+		return nil
+	}
+
+	seqs := make([]int, 0, len(chunks))
+	for seq := range chunks {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	var profile []byte
+	for i, seq := range seqs {
+		if seq != i+1 {
+			return nil // 缺少部分数据
+		}
+		profile = append(profile, chunks[seq]...)
+	}
+	return profile
+}
+
+// findPNGICC 解压 iCCP 块中的配置文件
+func findPNGICC(data []byte) []byte {
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) || chunkType == "IDAT" {
+			break
+		}
+		if chunkType == "iCCP" {
+			body := data[pos+8 : pos+8+length]
+			// 配置文件名称、NUL、压缩方法（0 = zlib）
+			name := bytes.IndexByte(body, 0)
+			if name < 0 || name+2 > len(body) || body[name+1] != 0 {
+				return nil
+			}
+			r, err := zlib.NewReader(bytes.NewReader(body[name+2:]))
+			if err != nil {
+				return nil
+			}
+			defer r.Close()
+			profile, err := io.ReadAll(io.LimitReader(r, maxICCProfileSize))
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		pos += 12 + length
+	}
+	return nil
+}
+
+// findWebPICC 读取扩展格式 WebP 中的 ICCP 块
+func findWebPICC(data []byte) []byte {
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if size < 0 || pos+8+size > len(data) {
+			break
+		}
+		if string(data[pos:pos+4]) == "ICCP" {
+			return data[pos+8 : pos+8+size]
+		}
+		pos += 8 + size + size%2
+	}
+	return nil
+}
+
+// findISOBMFFICC 读取 HEIF/AVIF 中 meta/iprp/ipco 下第一个带 ICC 配置文件的 colr 属性
+func findISOBMFFICC(data []byte) []byte {
+	boxes, _ := readBoxes(data)
+	meta, ok := findBox(boxes, "meta")
+	if !ok || len(meta.body) < 4 {
+		return nil
+	}
+	children, _ := readBoxes(meta.body[4:])
+	iprp, ok := findBox(children, "iprp")
+	if !ok {
+		return nil
+	}
+	properties, _ := readBoxes(iprp.body)
+	ipco, ok := findBox(properties, "ipco")
+	if !ok {
+		return nil
+	}
+	items, _ := readBoxes(ipco.body)
+	for _, box := range items {
+		if box.boxType != "colr" || len(box.body) < 4 {
+			continue
+		}
+		// nclx 只有编码参数，prof/rICC 后面是完整的配置文件
+		if colourType := string(box.body[:4]); colourType == "prof" || colourType == "rICC" {
+			return box.body[4:]
+		}
+	}
+	return nil
+}
+
+// imageProfile 返回图片中嵌入的配置文件，没有或无法解析时返回 nil
+func imageProfile(data []byte, ext string) *ICCProfile {
+	raw := FindICCProfile(data, ext)
+	if raw == nil {
+		return nil
+	}
+	profile, err := ParseICCProfile(raw)
+	if err != nil {
+		return nil
+	}
+	return profile
+}
+
+// ColorInfo 图片的源颜色空间
+type ColorInfo struct {
+	// ColorSpace 嵌入的 ICC 配置文件名称，没有配置文件时为空（按 sRGB 处理）
+	ColorSpace string
+	// Converted 生成的缩略图和各尺寸版本是否从该颜色空间转换为 sRGB
+	Converted bool
+}
+
+// InspectColor 读取图片嵌入的颜色配置文件，判断生成的图片是否需要转换为 sRGB
+func InspectColor(data []byte, ext string) ColorInfo {
+	profile := imageProfile(data, ext)
+	if profile == nil {
+		return ColorInfo{}
+	}
+	return ColorInfo{
+		ColorSpace: profile.Name(),
+		Converted:  profile.CanConvert() && !profile.IsSRGB(),
+	}
+}
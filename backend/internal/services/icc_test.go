@@ -0,0 +1,287 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+
+	"picsite/internal/models"
+)
+
+// displayP3Colorants Display P3 三原色在 PCS（D50）中的 XYZ 值，按列排列
+var displayP3Colorants = [3][3]float64{
+	{0.5151, 0.2920, 0.1571},
+	{0.2412, 0.6922, 0.0666},
+	{-0.0011, 0.0419, 0.7841},
+}
+
+// buildICCProfile 构造 v2 矩阵/TRC 型 RGB 配置文件，curve 为 curv 或 para 标签的内容
+func buildICCProfile(desc string, colorants [3][3]float64, curve []byte) []byte {
+	fixed := func(out []byte, v float64) []byte {
+		return binary.BigEndian.AppendUint32(out, uint32(int32(math.Round(v*65536))))
+	}
+	descTag := binary.BigEndian.AppendUint32([]byte("desc\x00\x00\x00\x00"), uint32(len(desc)+1))
+	descTag = append(append(descTag, desc...), 0)
+
+	type tag struct {
+		sig  string
+		body []byte
+	}
+	tags := []tag{{"desc", descTag}}
+	for i, name := range []string{"r", "g", "b"} {
+		xyz := []byte("XYZ \x00\x00\x00\x00")
+		for j := 0; j < 3; j++ {
+			xyz = fixed(xyz, colorants[j][i])
+		}
+		tags = append(tags, tag{name + "XYZ", xyz})
+	}
+	for _, name := range []string{"r", "g", "b"} {
+		tags = append(tags, tag{name + "TRC", curve})
+	}
+
+	header := make([]byte, 128)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB XYZ ")
+	copy(header[36:], "acsp")
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := 128 + 4 + len(tags)*12
+	for _, t := range tags {
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.body)))
+		data = append(data, t.body...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+
+	profile := append(append(header, table...), data...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+// gammaCurve 单个 gamma 值的 curv 标签
+func gammaCurve(gamma float64) []byte {
+	return binary.BigEndian.AppendUint16([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01"), uint16(gamma*256))
+}
+
+// srgbCurve sRGB 色调曲线的 para 标签（函数类型 3）
+func srgbCurve() []byte {
+	curve := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		curve = binary.BigEndian.AppendUint32(curve, uint32(int32(math.Round(v*65536))))
+	}
+	return curve
+}
+
+// displayP3Profile Display P3 配置文件（sRGB 色调曲线）
+func displayP3Profile() []byte {
+	return buildICCProfile("Display P3", displayP3Colorants, srgbCurve())
+}
+
+// withJPEGICC 将配置文件拆分为 chunks 个 APP2 段插入 JPEG
+func withJPEGICC(data, profile []byte, chunks int) []byte {
+	out := append([]byte{}, data[:2]...)
+	size := (len(profile) + chunks - 1) / chunks
+	for i := 0; i < chunks; i++ {
+		part := profile[i*size : min((i+1)*size, len(profile))]
+		payload := append(append(append([]byte{}, iccJPEGHeader...), byte(i+1), byte(chunks)), part...)
+		out = binary.BigEndian.AppendUint16(append(out, 0xFF, 0xE2), uint16(len(payload)+2))
+		out = append(out, payload...)
+	}
+	return append(out, data[2:]...)
+}
+
+// solidJPEG 生成纯色 JPEG
+func solidJPEG(t *testing.T, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseICCProfile(t *testing.T) {
+	profile, err := ParseICCProfile(displayP3Profile())
+	if err != nil {
+		t.Fatalf("ParseICCProfile failed: %v", err)
+	}
+	if profile.Name() != "Display P3" || profile.ColorSpace != "RGB" {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+	if !profile.CanConvert() || profile.IsSRGB() {
+		t.Errorf("Expected convertible non-sRGB profile")
+	}
+
+	t.Run("srgb", func(t *testing.T) {
+		profile, err := ParseICCProfile(buildICCProfile("sRGB IEC61966-2.1", srgbColorants, srgbCurve()))
+		if err != nil {
+			t.Fatalf("ParseICCProfile failed: %v", err)
+		}
+		if !profile.IsSRGB() {
+			t.Error("Expected sRGB profile to be recognised")
+		}
+	})
+
+	t.Run("gamma 2.2 with srgb primaries", func(t *testing.T) {
+		profile, _ := ParseICCProfile(buildICCProfile("Custom", srgbColorants, gammaCurve(2.2)))
+		if profile.IsSRGB() {
+			t.Error("Expected gamma 2.2 curve to differ from sRGB")
+		}
+	})
+
+	t.Run("lookup table profile", func(t *testing.T) {
+		data := displayP3Profile()
+		copy(data[16:], "CMYK")
+		profile, err := ParseICCProfile(data)
+		if err != nil {
+			t.Fatalf("ParseICCProfile failed: %v", err)
+		}
+		if profile.ColorSpace != "CMYK" || profile.CanConvert() {
+			t.Errorf("Expected unconvertible CMYK profile, got %+v", profile)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		data := displayP3Profile()
+		for _, bad := range [][]byte{data[:100], append([]byte("xxxx"), data[4:]...), data[:len(data)-1]} {
+			if _, err := ParseICCProfile(bad); err == nil {
+				t.Error("Expected error for malformed profile")
+			}
+		}
+	})
+}
+
+func TestFindICCProfile(t *testing.T) {
+	profile := displayP3Profile()
+
+	t.Run("jpeg", func(t *testing.T) {
+		data := withJPEGICC(testJPEG(t, 10, 10), profile, 3)
+		if found := FindICCProfile(data, ".jpg"); !bytes.Equal(found, profile) {
+			t.Errorf("Expected profile from APP2 segments, got %d bytes", len(found))
+		}
+		// 序号不连续（缺少部分数据）时忽略
+		partial := withJPEGICC(testJPEG(t, 10, 10), profile, 1)
+		partial[6+len(iccJPEGHeader)] = 2
+		if found := FindICCProfile(partial, ".jpg"); found != nil {
+			t.Errorf("Expected nil for incomplete profile, got %d bytes", len(found))
+		}
+	})
+
+	t.Run("png", func(t *testing.T) {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(profile)
+		zw.Close()
+		chunk := pngChunk("iCCP", append([]byte("Display P3\x00\x00"), compressed.Bytes()...))
+		data := append(append(append([]byte{}, buf.Bytes()[:33]...), chunk...), buf.Bytes()[33:]...)
+		if found := FindICCProfile(data, ".png"); !bytes.Equal(found, profile) {
+			t.Errorf("Expected profile from iCCP, got %d bytes", len(found))
+		}
+	})
+
+	t.Run("heif", func(t *testing.T) {
+		colr := isoBoxBytes("colr", []byte("prof"), profile)
+		ipco := isoBoxBytes("ipco", isoBoxBytes("colr", []byte("nclx\x00\x01\x00\x0d\x00\x01\x80")), colr)
+		meta := isoBoxBytes("meta", []byte{0, 0, 0, 0}, isoBoxBytes("iprp", ipco))
+		data := append(isoBoxBytes("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")), meta...)
+		if found := FindICCProfile(data, ".heic"); !bytes.Equal(found, profile) {
+			t.Errorf("Expected profile from colr, got %d bytes", len(found))
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		if found := FindICCProfile(testJPEG(t, 10, 10), ".jpg"); found != nil {
+			t.Errorf("Expected nil, got %d bytes", len(found))
+		}
+	})
+}
+
+func TestConvertToSRGB(t *testing.T) {
+	profile, _ := ParseICCProfile(displayP3Profile())
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{128, 128, 128, 255})
+	img.Set(1, 0, color.NRGBA{200, 100, 50, 128})
+
+	out := ConvertToSRGB(img, profile).(*image.NRGBA)
+
+	// 白点和色调曲线相同，灰色不变
+	if gray := out.NRGBAAt(0, 0); absDiff(gray.R, 128) > 1 || absDiff(gray.G, 128) > 1 || absDiff(gray.B, 128) > 1 {
+		t.Errorf("Expected neutral gray to be preserved, got %v", gray)
+	}
+	// P3 色域更大，同样的数值在 sRGB 中饱和度更高
+	if c := out.NRGBAAt(1, 0); c.R <= 200 || c.B >= 50 || c.A != 128 {
+		t.Errorf("Expected more saturated color with alpha preserved, got %v", c)
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestProcessPhotoColorSpace(t *testing.T) {
+	DB = setupJobsTestDB(t)
+	Store = NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+
+	tests := []struct {
+		name      string
+		profile   []byte
+		space     string
+		converted bool
+	}{
+		{"display p3", displayP3Profile(), "Display P3", true},
+		{"srgb", buildICCProfile("sRGB IEC61966-2.1", srgbColorants, srgbCurve()), "sRGB IEC61966-2.1", false},
+		{"untagged", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := solidJPEG(t, color.RGBA{200, 100, 50, 255})
+			if tt.profile != nil {
+				data = withJPEGICC(data, tt.profile, 1)
+			}
+			key := tt.name + ".jpg"
+			Store.Put(key, bytes.NewReader(data), int64(len(data)), "image/jpeg")
+
+			photo := models.Photo{FilePath: "/uploads/" + key, FileKey: key, MetadataPrivacy: MetadataKeep}
+			DB.Create(&photo)
+			if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+				t.Fatalf("ProcessPhoto failed: %v", err)
+			}
+
+			DB.First(&photo, photo.ID)
+			if photo.ColorSpace != tt.space || photo.ColorConverted != tt.converted {
+				t.Errorf("Expected %q (converted=%v), got %q (converted=%v)", tt.space, tt.converted, photo.ColorSpace, photo.ColorConverted)
+			}
+
+			thumbnail, _ := ReadObject(Store, photo.ThumbnailKey)
+			img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+			if err != nil {
+				t.Fatalf("Failed to decode thumbnail: %v", err)
+			}
+			r, _, _, _ := img.At(0, 0).RGBA()
+			if converted := r>>8 > 205; converted != tt.converted {
+				t.Errorf("Expected thumbnail conversion %v, got red %d", tt.converted, r>>8)
+			}
+		})
+	}
+}
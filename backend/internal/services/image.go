@@ -1,15 +1,22 @@
 package services
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
-	"os"
+	"io"
+	"math"
+	"mime"
+	"net/http"
 	"path/filepath"
 	"strings"
 
 	"github.com/disintegration/imaging"
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/heic"
+	"github.com/gen2brain/webp"
 )
 
 const (
@@ -18,68 +25,193 @@ const (
 	ThumbnailQuality = 85
 )
 
-// GenerateThumbnail generates a thumbnail for the given image file
-func GenerateThumbnail(srcPath, destPath string) error {
-	// Open source image
-	srcFile, err := os.Open(srcPath)
+// saveThumbnail resizes an already decoded original and stores the JPEG
+// thumbnail next to it, watermarked when watermark is true regardless of
+// WatermarkOptions.MinSize (see drawWatermark).
+// It returns the thumbnail key and the thumbnail image without the watermark
+func saveThumbnail(store Storage, key string, img image.Image, watermark bool) (string, image.Image, error) {
+	// Generate thumbnail key
+	thumbnailKey := derivedKey(key, "_thumb.jpg")
+	thumbnail := imaging.Resize(img, ThumbnailWidth, ThumbnailHeight, imaging.Lanczos)
+
+	published := image.Image(thumbnail)
+	if watermark {
+		published = drawWatermark(thumbnail)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeThumbnail(&buf, published); err != nil {
+		return "", nil, err
+	}
+
+	if err := store.Put(thumbnailKey, &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+		return "", nil, fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+
+	return thumbnailKey, thumbnail, nil
+}
+
+// encodeThumbnail writes a resized thumbnail as JPEG
+func encodeThumbnail(dst io.Writer, thumbnail image.Image) error {
+	// Always save as JPEG for consistency
+	if err := jpeg.Encode(dst, thumbnail, &jpeg.Options{Quality: ThumbnailQuality}); err != nil {
+		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+
+	return nil
+}
+
+// decodeImage decodes an image, picking the decoder by file extension,
+// converts it to sRGB when it has an embedded ICC profile in another color
+// space, and rotates/flips it according to its EXIF orientation
+func decodeImage(r io.Reader, ext string) (image.Image, error) {
+	data, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("failed to open source image: %w", err)
+		return nil, err
+	}
+
+	img, err := decodeRaw(bytes.NewReader(data), ext)
+	if err != nil {
+		return nil, err
+	}
+	// Encoded outputs carry no profile, so they must already be sRGB
+	if profile := imageProfile(data, ext); profile != nil && !profile.IsSRGB() {
+		img = ConvertToSRGB(img, profile)
+	}
+	if IsHEIF(ext) {
+		return img, nil // HEIF decoding already applies the rotation
 	}
-	defer srcFile.Close()
 
-	// Decode image
-	var img image.Image
-	ext := strings.ToLower(filepath.Ext(srcPath))
+	return applyOrientation(img, ParseEXIF(data).Orientation), nil
+}
 
-	switch ext {
+// decodeRaw decodes the pixel data without applying EXIF orientation
+func decodeRaw(r io.Reader, ext string) (image.Image, error) {
+	switch strings.ToLower(ext) {
 	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(srcFile)
+		return jpeg.Decode(r)
 	case ".png":
-		img, err = png.Decode(srcFile)
+		return png.Decode(r)
+	case ".webp":
+		return webp.Decode(r)
+	case ".avif":
+		return avif.Decode(r)
+	case ".heic", ".heif":
+		return heic.Decode(r)
+	case ".dng", ".cr2", ".nef", ".arw":
+		return decodeRAWPreview(r)
 	default:
 		// Try to decode as generic image
-		img, err = imaging.Decode(srcFile)
+		return imaging.Decode(r)
 	}
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+// applyOrientation transforms the image so it is displayed upright for the
+// given EXIF orientation (1-8); unknown values leave the image unchanged
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
 	}
+}
 
-	// Generate thumbnail
-	thumbnail := imaging.Resize(img, ThumbnailWidth, ThumbnailHeight, imaging.Lanczos)
+// swapsDimensions reports whether the EXIF orientation rotates the image by 90 degrees
+func swapsDimensions(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
 
-	// Ensure thumbnail directory exists
-	thumbnailDir := filepath.Dir(destPath)
-	if err := os.MkdirAll(thumbnailDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create thumbnail directory: %w", err)
+// ImageDimensions returns the displayed width and height of an image,
+// taking EXIF orientation into account, without decoding the pixel data
+func ImageDimensions(data []byte, ext string) (int, int, error) {
+	if IsHEIF(ext) {
+		// HEIF dimensions already account for rotation
+		cfg, err := decodeHEIFConfig(data)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to decode image: %w", err)
+		}
+		return cfg.Width, cfg.Height, nil
 	}
 
-	// Save thumbnail
-	dstFile, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("failed to create thumbnail file: %w", err)
+	var cfg image.Config
+	var err error
+	if IsRAW(ext) {
+		// RAW files are displayed through their embedded preview
+		if cfg, err = rawPreviewConfig(data); err != nil {
+			return 0, 0, err
+		}
+	} else if cfg, _, err = image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		// Fall back to a full decode for formats without a registered config decoder
+		img, decodeErr := decodeRaw(bytes.NewReader(data), ext)
+		if decodeErr != nil {
+			return 0, 0, fmt.Errorf("failed to decode image: %w", err)
+		}
+		cfg.Width, cfg.Height = img.Bounds().Dx(), img.Bounds().Dy()
 	}
-	defer dstFile.Close()
 
-	// Always save as JPEG for consistency
-	err = jpeg.Encode(dstFile, thumbnail, &jpeg.Options{Quality: ThumbnailQuality})
-	if err != nil {
-		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	if swapsDimensions(ParseEXIF(data).Orientation) {
+		return cfg.Height, cfg.Width, nil
 	}
+	return cfg.Width, cfg.Height, nil
+}
 
-	return nil
+// ImageInfo describes the basic properties of an image file
+type ImageInfo struct {
+	Width       int
+	Height      int
+	AspectRatio float64
+	Size        int64
+	MimeType    string
 }
 
-// GenerateThumbnailFromUpload generates a thumbnail for uploaded file
-func GenerateThumbnailFromUpload(uploadPath string) (string, error) {
-	// Generate thumbnail path
-	ext := filepath.Ext(uploadPath)
-	thumbnailPath := strings.TrimSuffix(uploadPath, ext) + "_thumb.jpg"
+// InspectImage reads the displayed dimensions, byte size and content type of an image
+func InspectImage(data []byte, ext string) (*ImageInfo, error) {
+	width, height, err := ImageDimensions(data, ext)
+	if err != nil {
+		return nil, err
+	}
 
-	err := GenerateThumbnail(uploadPath, thumbnailPath)
+	info := &ImageInfo{
+		Width:    width,
+		Height:   height,
+		Size:     int64(len(data)),
+		MimeType: detectMimeType(data, ext),
+	}
+	if height > 0 {
+		info.AspectRatio = math.Round(float64(width)/float64(height)*10000) / 10000
+	}
+	return info, nil
+}
+
+// InspectUpload inspects an uploaded object, see InspectImage
+func InspectUpload(store Storage, key string) (*ImageInfo, error) {
+	data, err := ReadObject(store, key)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to read image: %w", err)
 	}
+	return InspectImage(data, filepath.Ext(key))
+}
 
-	return thumbnailPath, nil
+// detectMimeType sniffs the content type, falling back to the file extension
+func detectMimeType(data []byte, ext string) string {
+	if mimeType := http.DetectContentType(data); strings.HasPrefix(mimeType, "image/") {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(strings.ToLower(ext)); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
 }
@@ -1,35 +1,137 @@
 package services
 
 import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"testing"
 )
 
-func TestExtractEXIFFromUpload(t *testing.T) {
-	t.Run("extract EXIF from non-existent file", func(t *testing.T) {
-		exifData := ExtractEXIFFromUpload("non_existent_file.jpg")
+// testJPEG 生成指定尺寸的测试 JPEG 图片
+func testJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
 
-		// 应该返回空结构,不应该 panic
-		if exifData == nil {
-			t.Error("Expected non-nil EXIF data")
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
 		}
+	}
 
-		if exifData.CameraModel != "" {
-			t.Error("Expected empty camera model for non-existent file")
-		}
-	})
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
 }
 
-func TestGenerateThumbnailFromUpload(t *testing.T) {
-	t.Run("generate thumbnail from non-existent file", func(t *testing.T) {
-		thumbnailPath, err := GenerateThumbnailFromUpload("non_existent_file.jpg")
+func TestDecodeImageAppliesOrientation(t *testing.T) {
+	// 64x32 的图片，左上角 16x16 为红色标记块
+	src := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{R: 0, G: 0, B: 255, A: 255}
+			if x < 16 && y < 16 {
+				c = color.RGBA{R: 255, G: 0, B: 0, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	tests := []struct {
+		orientation   int
+		width, height int
+		markerX       int
+		markerY       int
+	}{
+		{1, 64, 32, 8, 8},
+		{2, 64, 32, 56, 8},
+		{3, 64, 32, 56, 24},
+		{4, 64, 32, 8, 24},
+		{5, 32, 64, 8, 8},
+		{6, 32, 64, 24, 8},
+		{7, 32, 64, 24, 56},
+		{8, 32, 64, 8, 56},
+	}
+
+	for _, tt := range tests {
+		data := withEXIFOrientation(t, buf.Bytes(), tt.orientation)
 
-		// 应该返回错误
-		if err == nil {
-			t.Error("Expected error for non-existent file")
+		img, err := decodeImage(bytes.NewReader(data), ".jpg")
+		if err != nil {
+			t.Fatalf("Orientation %d: failed to decode: %v", tt.orientation, err)
+		}
+		if img.Bounds().Dx() != tt.width || img.Bounds().Dy() != tt.height {
+			t.Errorf("Orientation %d: expected %dx%d, got %v", tt.orientation, tt.width, tt.height, img.Bounds().Size())
+			continue
+		}
+		r, _, b, _ := img.At(tt.markerX, tt.markerY).RGBA()
+		if r < b {
+			t.Errorf("Orientation %d: expected marker at (%d,%d)", tt.orientation, tt.markerX, tt.markerY)
 		}
 
-		if thumbnailPath != "" {
-			t.Error("Expected empty thumbnail path for non-existent file")
+		w, h, err := ImageDimensions(data, ".jpg")
+		if err != nil || w != tt.width || h != tt.height {
+			t.Errorf("Orientation %d: ImageDimensions = %dx%d, %v; want %dx%d", tt.orientation, w, h, err, tt.width, tt.height)
 		}
-	})
+	}
+}
+
+// withEXIFOrientation 在 JPEG 的 SOI 之后插入只包含 Orientation 标签的 EXIF 段
+func withEXIFOrientation(t *testing.T, data []byte, orientation int) []byte {
+	t.Helper()
+
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // 大端 TIFF 头，IFD0 偏移 8
+		0x00, 0x01, // 1 个条目
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // Orientation, SHORT, count 1
+		0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // 没有下一个 IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(payload) + 2
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestInspectImage(t *testing.T) {
+	data := testJPEG(t, 300, 200)
+
+	info, err := InspectImage(data, ".jpg")
+	if err != nil {
+		t.Fatalf("Failed to inspect image: %v", err)
+	}
+	if info.Width != 300 || info.Height != 200 {
+		t.Errorf("Expected 300x200, got %dx%d", info.Width, info.Height)
+	}
+	if info.AspectRatio != 1.5 {
+		t.Errorf("Expected aspect ratio 1.5, got %v", info.AspectRatio)
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("Expected size %d, got %d", len(data), info.Size)
+	}
+	if info.MimeType != "image/jpeg" {
+		t.Errorf("Expected image/jpeg, got %s", info.MimeType)
+	}
+
+	// 旋转 90 度的照片按显示方向计算宽高比
+	rotated, err := InspectImage(withEXIFOrientation(t, data, 6), ".jpg")
+	if err != nil {
+		t.Fatalf("Failed to inspect rotated image: %v", err)
+	}
+	if rotated.Width != 200 || rotated.Height != 300 || rotated.AspectRatio != 0.6667 {
+		t.Errorf("Expected 200x300 (0.6667), got %dx%d (%v)", rotated.Width, rotated.Height, rotated.AspectRatio)
+	}
+
+	if _, err := InspectImage([]byte("not an image"), ".jpg"); err == nil {
+		t.Error("Expected error for invalid image data")
+	}
 }
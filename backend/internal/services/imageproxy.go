@@ -0,0 +1,270 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strconv"
+
+	"picsite/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// 尺寸版本的生成方式
+const (
+	// RenditionModeOnDemand 上传时不生成，访问时通过 /img 接口按需生成并缓存
+	RenditionModeOnDemand = "on_demand"
+	// RenditionModeEager 上传时预先生成所有尺寸
+	RenditionModeEager = "eager"
+)
+
+// 缩放方式
+const (
+	FitInside = "inside" // 等比缩放到指定宽高范围内
+	FitCover  = "cover"  // 等比缩放后居中裁剪到指定宽高
+	FitFill   = "fill"   // 拉伸到指定宽高
+)
+
+// ImageCachePrefix 按需生成的图片在存储中的前缀
+const ImageCachePrefix = "cache/"
+
+var ErrInvalidImageParams = errors.New("invalid image parameters")
+
+// ImageParams 按需生成图片的参数
+type ImageParams struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int // 0 表示使用该格式的默认质量
+	// Version 照片公开图片的版本（见 Photo.RenditionVersion），重新生成后地址随之改变，客户端缓存不会返回旧图片
+	Version int
+}
+
+// ParseImageParams 从查询参数 w, h, fit, fmt, q, v 解析并校验图片参数
+func ParseImageParams(values url.Values) (ImageParams, error) {
+	var p ImageParams
+	var err error
+
+	if p.Width, err = parseIntParam(values.Get("w")); err != nil {
+		return p, err
+	}
+	if p.Height, err = parseIntParam(values.Get("h")); err != nil {
+		return p, err
+	}
+	if p.Quality, err = parseIntParam(values.Get("q")); err != nil {
+		return p, err
+	}
+	if p.Version, err = parseIntParam(values.Get("v")); err != nil {
+		return p, err
+	}
+	p.Fit = values.Get("fit")
+	p.Format = values.Get("fmt")
+
+	p = p.normalized()
+	if err := p.validate(); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func parseIntParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, ErrInvalidImageParams
+	}
+	return n, nil
+}
+
+// normalized 填充默认值，保证相同含义的参数得到相同的签名和缓存 key
+func (p ImageParams) normalized() ImageParams {
+	if p.Fit == "" {
+		p.Fit = FitInside
+	}
+	if p.Format == "" {
+		p.Format = FormatJPEG
+	}
+	return p
+}
+
+func (p ImageParams) validate() error {
+	max := ImageConfig.MaxDimension
+	if p.Width < 0 || p.Height < 0 || p.Width > max || p.Height > max {
+		return ErrInvalidImageParams
+	}
+	if p.Quality < 0 || p.Quality > 100 || p.Version < 0 {
+		return ErrInvalidImageParams
+	}
+	if p.Fit != FitInside && p.Fit != FitCover && p.Fit != FitFill {
+		return ErrInvalidImageParams
+	}
+	if !IsSupportedFormat(p.Format) {
+		return ErrInvalidImageParams
+	}
+	return nil
+}
+
+// canonical 返回用于签名的规范化字符串
+func (p ImageParams) canonical(photoID uint) string {
+	return fmt.Sprintf("%d:w=%d:h=%d:fit=%s:fmt=%s:q=%d:v=%d", photoID, p.Width, p.Height, p.Fit, p.Format, p.Quality, p.Version)
+}
+
+// CacheKey 返回生成结果在存储中的缓存 key
+func (p ImageParams) CacheKey(photoID uint) string {
+	return fmt.Sprintf("%s%d/w%d_h%d_%s_q%d_v%d%s", ImageCachePrefix, photoID, p.Width, p.Height, p.Fit, p.Quality, p.Version, FormatExtension(p.Format))
+}
+
+// ClearImageCache 删除照片所有按需生成的缓存图片
+func ClearImageCache(store Storage, photoID uint) error {
+	cached, err := store.List(fmt.Sprintf("%s%d/", ImageCachePrefix, photoID))
+	if err != nil {
+		return fmt.Errorf("failed to list cached images: %w", err)
+	}
+	for _, obj := range cached {
+		if err := store.Delete(obj.Key); err != nil {
+			return fmt.Errorf("failed to delete cached image: %w", err)
+		}
+	}
+	return nil
+}
+
+// SignImageParams 使用 HMAC-SHA256 对图片参数签名
+func SignImageParams(photoID uint, p ImageParams) string {
+	mac := hmac.New(sha256.New, []byte(ImageConfig.SigningKey))
+	mac.Write([]byte(p.normalized().canonical(photoID)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyImageSignature 校验图片参数签名
+func VerifyImageSignature(photoID uint, p ImageParams, signature string) bool {
+	expected := SignImageParams(photoID, p)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// ImageURL 返回带签名的按需生成图片地址
+func ImageURL(photoID uint, p ImageParams) string {
+	p = p.normalized()
+
+	values := url.Values{}
+	if p.Width > 0 {
+		values.Set("w", strconv.Itoa(p.Width))
+	}
+	if p.Height > 0 {
+		values.Set("h", strconv.Itoa(p.Height))
+	}
+	if p.Fit != FitInside {
+		values.Set("fit", p.Fit)
+	}
+	if p.Format != FormatJPEG {
+		values.Set("fmt", p.Format)
+	}
+	if p.Quality > 0 {
+		values.Set("q", strconv.Itoa(p.Quality))
+	}
+	if p.Version > 0 {
+		values.Set("v", strconv.Itoa(p.Version))
+	}
+	values.Set("s", SignImageParams(photoID, p))
+
+	return fmt.Sprintf("/img/%d?%s", photoID, values.Encode())
+}
+
+// RenderImage 从原图生成指定参数的图片，不会放大原图，watermark 为 true 时按配置加水印
+func RenderImage(store Storage, srcKey string, p ImageParams, watermark bool) ([]byte, error) {
+	src, err := store.Get(srcKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source image: %w", err)
+	}
+	defer src.Close()
+
+	img, err := decodeImage(src, filepath.Ext(srcKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	img = transformImage(img, p)
+	if watermark {
+		img = ApplyWatermark(img)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, p.Format, p.Quality); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// transformImage 按缩放方式调整图片尺寸
+func transformImage(img image.Image, p ImageParams) image.Image {
+	srcW, srcH := img.Bounds().Dx(), img.Bounds().Dy()
+	w, h := min(p.Width, srcW), min(p.Height, srcH)
+
+	switch {
+	case w == 0 && h == 0:
+		return img
+	case p.Fit == FitCover && w > 0 && h > 0:
+		return imaging.Fill(img, w, h, imaging.Center, imaging.Lanczos)
+	case p.Fit == FitFill && w > 0 && h > 0:
+		return imaging.Resize(img, w, h, imaging.Lanczos)
+	case w == 0:
+		return imaging.Resize(img, 0, h, imaging.Lanczos)
+	case h == 0:
+		return imaging.Resize(img, w, 0, imaging.Lanczos)
+	default:
+		return imaging.Fit(img, w, h, imaging.Lanczos)
+	}
+}
+
+// OnDemandRenditions 按配置的宽度和格式列出照片的按需生成地址
+func OnDemandRenditions(photo models.Photo) []models.PhotoRendition {
+	var renditions []models.PhotoRendition
+
+	// 已知原图尺寸时不列出大于原图的宽度
+	srcWidth := math.MaxInt
+	if photo.Width > 0 {
+		srcWidth = photo.Width
+	}
+
+	for _, width := range renditionWidths(srcWidth) {
+		height := 0
+		if photo.Width > 0 {
+			height = int(math.Round(float64(photo.Height) * float64(width) / float64(photo.Width)))
+		}
+		for _, format := range renditionFormats() {
+			renditions = append(renditions, models.PhotoRendition{
+				Width:  width,
+				Height: height,
+				Format: format,
+				URL:    ImageURL(photo.ID, ImageParams{Width: width, Format: format, Version: photo.RenditionVersion}),
+			})
+		}
+	}
+
+	return renditions
+}
+
+// AttachRenditions 为没有预生成尺寸版本的照片填充按需生成地址
+func AttachRenditions(photos []models.Photo) {
+	for i := range photos {
+		AttachPhotoRenditions(&photos[i])
+	}
+}
+
+// AttachPhotoRenditions 为单张照片填充按需生成地址，未处理完成的照片不提供
+func AttachPhotoRenditions(photo *models.Photo) {
+	if ImageConfig.RenditionMode == RenditionModeOnDemand && len(photo.Renditions) == 0 &&
+		photo.ProcessingStatus == models.PhotoStatusReady {
+		photo.Renditions = OnDemandRenditions(*photo)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestImageSignature(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.SigningKey = "test-signing-key"
+
+	p := ImageParams{Width: 800, Format: FormatWebP}
+	sig := SignImageParams(1, p)
+
+	t.Run("valid signature", func(t *testing.T) {
+		if !VerifyImageSignature(1, p, sig) {
+			t.Error("Expected signature to be valid")
+		}
+	})
+
+	t.Run("defaults do not change signature", func(t *testing.T) {
+		if !VerifyImageSignature(1, ImageParams{Width: 800, Fit: FitInside, Format: FormatWebP}, sig) {
+			t.Error("Expected explicit default fit to produce the same signature")
+		}
+	})
+
+	t.Run("tampered parameters", func(t *testing.T) {
+		if VerifyImageSignature(1, ImageParams{Width: 1600, Format: FormatWebP}, sig) {
+			t.Error("Expected signature to be invalid for different width")
+		}
+		if VerifyImageSignature(2, p, sig) {
+			t.Error("Expected signature to be invalid for different photo")
+		}
+	})
+
+	t.Run("version is signed", func(t *testing.T) {
+		versioned := ImageParams{Width: 800, Format: FormatWebP, Version: 2}
+		if VerifyImageSignature(1, versioned, sig) {
+			t.Error("Expected signature to be invalid for different version")
+		}
+		if versioned.CacheKey(1) == p.CacheKey(1) {
+			t.Error("Expected different versions to use different cache keys")
+		}
+	})
+
+	t.Run("different key", func(t *testing.T) {
+		ImageConfig.SigningKey = "other-key"
+		defer func() { ImageConfig.SigningKey = "test-signing-key" }()
+		if VerifyImageSignature(1, p, sig) {
+			t.Error("Expected signature to be invalid with a different key")
+		}
+	})
+
+	t.Run("image url round trip", func(t *testing.T) {
+		imageURL := ImageURL(1, p)
+		if !strings.HasPrefix(imageURL, "/img/1?") {
+			t.Fatalf("Unexpected image url: %s", imageURL)
+		}
+
+		u, _ := url.Parse(imageURL)
+		parsed, err := ParseImageParams(u.Query())
+		if err != nil {
+			t.Fatalf("Failed to parse image url: %v", err)
+		}
+		if !VerifyImageSignature(1, parsed, u.Query().Get("s")) {
+			t.Error("Expected generated url to carry a valid signature")
+		}
+
+		u, _ = url.Parse(ImageURL(1, ImageParams{Width: 800, Version: 3}))
+		if parsed, err := ParseImageParams(u.Query()); err != nil || parsed.Version != 3 {
+			t.Errorf("Expected version 3 in image url, got %+v (%v)", parsed, err)
+		}
+	})
+}
+
+func TestParseImageParams(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"w=800", false},
+		{"w=800&h=600&fit=cover&fmt=avif&q=50", false},
+		{"w=abc", true},
+		{"w=-1", true},
+		{"w=100000", true},
+		{"fit=stretch", true},
+		{"fmt=gif", true},
+		{"q=101", true},
+		{"v=2", false},
+		{"v=-1", true},
+	}
+
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		_, err := ParseImageParams(values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseImageParams(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTransformImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	tests := []struct {
+		name          string
+		params        ImageParams
+		width, height int
+	}{
+		{"width only", ImageParams{Width: 200, Fit: FitInside}, 200, 100},
+		{"height only", ImageParams{Height: 50, Fit: FitInside}, 100, 50},
+		{"inside box", ImageParams{Width: 100, Height: 100, Fit: FitInside}, 100, 50},
+		{"cover crop", ImageParams{Width: 100, Height: 100, Fit: FitCover}, 100, 100},
+		{"fill stretch", ImageParams{Width: 100, Height: 100, Fit: FitFill}, 100, 100},
+		{"no upscaling", ImageParams{Width: 1600, Fit: FitInside}, 400, 200},
+		{"original size", ImageParams{Fit: FitInside}, 400, 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transformImage(src, tt.params).Bounds()
+			if got.Dx() != tt.width || got.Dy() != tt.height {
+				t.Errorf("Expected %dx%d, got %dx%d", tt.width, tt.height, got.Dx(), got.Dy())
+			}
+		})
+	}
+}
+
+func TestRenderImage(t *testing.T) {
+	store := NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+	data := testJPEG(t, 640, 480)
+	if err := store.Put("photo.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("Failed to put test image: %v", err)
+	}
+
+	out, err := RenderImage(store, "photo.jpg", ImageParams{Width: 320, Fit: FitInside, Format: FormatWebP}, false)
+	if err != nil {
+		t.Fatalf("Failed to render image: %v", err)
+	}
+
+	img, err := decodeImage(bytes.NewReader(out), ".webp")
+	if err != nil {
+		t.Fatalf("Failed to decode rendered image: %v", err)
+	}
+	if img.Bounds().Dx() != 320 || img.Bounds().Dy() != 240 {
+		t.Errorf("Expected 320x240, got %v", img.Bounds().Size())
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+	// iptcUTF8 CodedCharacterSet (1:90) 中表示 UTF-8 的转义序列
+	iptcUTF8 = []byte("\x1b%G")
+)
+
+// irbIPTC Photoshop 图像资源块中存放 IPTC-IIM 数据的资源 ID
+const irbIPTC = 0x0404
+
+// iptcDatasets IPTC-IIM 应用记录（record 2）中的数据集名称，repeatable 为 true 的数据集可以出现多次
+var iptcDatasets = map[uint8]struct {
+	name       string
+	repeatable bool
+}{
+	5:   {"ObjectName", false},
+	7:   {"EditStatus", false},
+	10:  {"Urgency", false},
+	15:  {"Category", false},
+	20:  {"SupplementalCategories", true},
+	25:  {"Keywords", true},
+	40:  {"SpecialInstructions", false},
+	55:  {"DateCreated", false},
+	60:  {"TimeCreated", false},
+	80:  {"By-line", true},
+	85:  {"By-lineTitle", true},
+	90:  {"City", false},
+	92:  {"Sub-location", false},
+	95:  {"Province-State", false},
+	100: {"Country-PrimaryLocationCode", false},
+	101: {"Country-PrimaryLocationName", false},
+	103: {"OriginalTransmissionReference", false},
+	105: {"Headline", false},
+	110: {"Credit", false},
+	115: {"Source", false},
+	116: {"CopyrightNotice", false},
+	118: {"Contact", true},
+	120: {"Caption-Abstract", false},
+	122: {"Writer-Editor", true},
+}
+
+// IPTCData 从 IPTC-IIM 中读取的照片信息（通讯社、图片库常用）
+type IPTCData struct {
+	Caption   string   `json:"caption"`
+	Byline    []string `json:"byline"`
+	Copyright string   `json:"copyright"`
+	City      string   `json:"city"`
+	State     string   `json:"state"`
+	Country   string   `json:"country"`
+	Keywords  []string `json:"keywords"`
+}
+
+// Location 将城市、省/州和国家以逗号连接
+func (d *IPTCData) Location() string {
+	var parts []string
+	for _, part := range []string{d.City, d.State, d.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FindIPTC 从 JPEG 的 APP13 段中取出 IPTC-IIM 数据，没有时返回 nil
+func FindIPTC(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	// 较大的图像资源块会被拆分到多个 APP13 段中
+	var irb []byte
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		if payload := data[pos+4 : end]; marker == 0xED && bytes.HasPrefix(payload, photoshopHeader) {
+			irb = append(irb, payload[len(photoshopHeader):]...)
+		}
+		pos = end
+	}
+
+	return findIRBResource(irb, irbIPTC)
+}
+
+// findIRBResource 在 Photoshop 图像资源块中查找指定 ID 的资源
+func findIRBResource(irb []byte, id uint16) []byte {
+	for pos := 0; pos+12 <= len(irb) && string(irb[pos:pos+4]) == "8BIM"; {
+		resourceID := binary.BigEndian.Uint16(irb[pos+4:])
+
+		// 资源名称为 Pascal 字符串，包括长度字节在内按偶数字节对齐
+		nameLen := int(irb[pos+6])
+		pos += 6 + nameLen + 1 + (nameLen+1)%2
+		if pos+4 > len(irb) {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint32(irb[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(irb) {
+			return nil
+		}
+		if resourceID == id {
+			return irb[pos : pos+size]
+		}
+		pos += size + size%2
+	}
+	return nil
+}
+
+// ParseIPTCProperties 解析 IPTC-IIM 应用记录中的数据集，以数据集名称为键
+//
+// 可重复的数据集（如 Keywords）为 []string，其他为 string；未知数据集以 "2:200" 形式的编号为键
+func ParseIPTCProperties(iim []byte) (map[string]interface{}, error) {
+	props := make(map[string]interface{})
+	isUTF8 := false
+
+	for pos := 0; pos < len(iim); {
+		if iim[pos] != 0x1C {
+			// 部分软件会在数据后补零
+			if bytes.Count(iim[pos:], []byte{0}) == len(iim)-pos {
+				break
+			}
+			return props, fmt.Errorf("invalid iptc tag marker at offset %d", pos)
+		}
+		if pos+5 > len(iim) {
+			return props, fmt.Errorf("truncated iptc dataset at offset %d", pos)
+		}
+
+		record, dataset := iim[pos+1], iim[pos+2]
+		size := int(binary.BigEndian.Uint16(iim[pos+3:]))
+		pos += 5
+
+		// 扩展长度：低 15 位为实际长度所占的字节数
+		if size&0x8000 != 0 {
+			n := size & 0x7FFF
+			if n > 4 || pos+n > len(iim) {
+				return props, fmt.Errorf("invalid iptc extended size at offset %d", pos)
+			}
+			size = 0
+			for _, b := range iim[pos : pos+n] {
+				size = size<<8 | int(b)
+			}
+			pos += n
+		}
+		if pos+size > len(iim) {
+			return props, fmt.Errorf("truncated iptc dataset at offset %d", pos)
+		}
+		value := iim[pos : pos+size]
+		pos += size
+
+		switch record {
+		case 1:
+			if dataset == 90 {
+				isUTF8 = bytes.Equal(value, iptcUTF8)
+			}
+		case 2:
+			if dataset == 0 {
+				continue // RecordVersion，二进制数据
+			}
+			text := decodeIPTCString(value, isUTF8)
+			info, known := iptcDatasets[dataset]
+			name := info.name
+			if !known {
+				name = fmt.Sprintf("2:%d", dataset)
+			}
+
+			if info.repeatable {
+				values, _ := props[name].([]string)
+				props[name] = append(values, text)
+			} else {
+				props[name] = text
+			}
+		}
+	}
+
+	return props, nil
+}
+
+// decodeIPTCString 解码数据集文本，未声明 UTF-8 且不是合法 UTF-8 时按 Latin-1 处理
+func decodeIPTCString(value []byte, isUTF8 bool) string {
+	value = bytes.TrimRight(value, "\x00")
+	if isUTF8 || utf8.Valid(value) {
+		return strings.TrimSpace(string(value))
+	}
+
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+// IPTCFromProperties 从 ParseIPTCProperties 的结果中读取照片信息
+func IPTCFromProperties(props map[string]interface{}) *IPTCData {
+	text := func(name string) string {
+		value, _ := props[name].(string)
+		return value
+	}
+	list := func(name string) []string {
+		var values []string
+		items, _ := props[name].([]string)
+		for _, item := range items {
+			if item != "" {
+				values = append(values, item)
+			}
+		}
+		return values
+	}
+
+	return &IPTCData{
+		Caption:   text("Caption-Abstract"),
+		Byline:    list("By-line"),
+		Copyright: text("CopyrightNotice"),
+		City:      text("City"),
+		State:     text("Province-State"),
+		Country:   text("Country-PrimaryLocationName"),
+		Keywords:  list("Keywords"),
+	}
+}
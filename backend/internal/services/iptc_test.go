@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"picsite/internal/models"
+)
+
+// iptcDataset 构造一个 IPTC-IIM 数据集
+func iptcDataset(record, dataset uint8, value string) []byte {
+	out := []byte{0x1C, record, dataset}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(value)))
+	return append(out, value...)
+}
+
+// withIPTC 在 JPEG 的 SOI 之后插入包含 IPTC-IIM 数据的 APP13 段
+func withIPTC(data []byte, iim []byte) []byte {
+	// 先放一个无关的资源，确认能跳过其他资源和名称填充
+	irb := append([]byte("8BIM\x04\x0C\x03abc"), binary.BigEndian.AppendUint32(nil, 3)...)
+	irb = append(irb, "xyz\x00"...)
+	irb = append(irb, "8BIM\x04\x04\x00\x00"...)
+	irb = binary.BigEndian.AppendUint32(irb, uint32(len(iim)))
+	irb = append(irb, iim...)
+	if len(iim)%2 == 1 {
+		irb = append(irb, 0)
+	}
+
+	payload := append(append([]byte{}, photoshopHeader...), irb...)
+	length := len(payload) + 2
+	segment := append([]byte{0xFF, 0xED, byte(length >> 8), byte(length)}, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// agencyIPTC 通讯社图片常见的 IPTC 数据
+func agencyIPTC() []byte {
+	var iim []byte
+	for _, ds := range []struct {
+		record, dataset uint8
+		value           string
+	}{
+		{1, 90, "\x1b%G"},
+		{2, 0, "\x00\x04"},
+		{2, 120, "Fishing boats at dawn"},
+		{2, 80, "Jane Doe"},
+		{2, 116, "© 2024 Example Agency"},
+		{2, 90, "Hội An"},
+		{2, 95, "Quảng Nam"},
+		{2, 101, "Vietnam"},
+		{2, 25, "boats"},
+		{2, 25, "harbour"},
+		{2, 200, "custom"},
+	} {
+		iim = append(iim, iptcDataset(ds.record, ds.dataset, ds.value)...)
+	}
+	return iim
+}
+
+func TestParseIPTCProperties(t *testing.T) {
+	props, err := ParseIPTCProperties(agencyIPTC())
+	if err != nil {
+		t.Fatalf("ParseIPTCProperties failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"Caption-Abstract":            "Fishing boats at dawn",
+		"By-line":                     []string{"Jane Doe"},
+		"CopyrightNotice":             "© 2024 Example Agency",
+		"City":                        "Hội An",
+		"Province-State":              "Quảng Nam",
+		"Country-PrimaryLocationName": "Vietnam",
+		"Keywords":                    []string{"boats", "harbour"},
+		"2:200":                       "custom",
+	}
+	if !reflect.DeepEqual(props, expected) {
+		t.Errorf("Expected %v, got %v", expected, props)
+	}
+
+	t.Run("latin-1 without coded character set", func(t *testing.T) {
+		props, err := ParseIPTCProperties(iptcDataset(2, 90, "Z\xfcrich"))
+		if err != nil {
+			t.Fatalf("ParseIPTCProperties failed: %v", err)
+		}
+		if props["City"] != "Zürich" {
+			t.Errorf("Expected Zürich, got %q", props["City"])
+		}
+	})
+
+	t.Run("extended size", func(t *testing.T) {
+		iim := append([]byte{0x1C, 2, 120, 0x80, 0x02, 0x00, 0x05}, "hello"...)
+		props, err := ParseIPTCProperties(iim)
+		if err != nil {
+			t.Fatalf("ParseIPTCProperties failed: %v", err)
+		}
+		if props["Caption-Abstract"] != "hello" {
+			t.Errorf("Expected hello, got %v", props)
+		}
+	})
+
+	t.Run("trailing padding", func(t *testing.T) {
+		iim := append(iptcDataset(2, 90, "Paris"), 0, 0)
+		if _, err := ParseIPTCProperties(iim); err != nil {
+			t.Errorf("Expected padding to be ignored, got %v", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		iim := iptcDataset(2, 120, "caption")
+		props, err := ParseIPTCProperties(append(iptcDataset(2, 90, "Paris"), iim[:len(iim)-2]...))
+		if err == nil {
+			t.Error("Expected error for truncated dataset")
+		}
+		if props["City"] != "Paris" {
+			t.Errorf("Expected datasets before the error to be kept, got %v", props)
+		}
+	})
+}
+
+func TestIPTCFromProperties(t *testing.T) {
+	props, _ := ParseIPTCProperties(agencyIPTC())
+	iptcData := IPTCFromProperties(props)
+
+	if iptcData.Caption != "Fishing boats at dawn" || iptcData.Copyright != "© 2024 Example Agency" {
+		t.Errorf("Unexpected caption/copyright: %+v", iptcData)
+	}
+	if !reflect.DeepEqual(iptcData.Byline, []string{"Jane Doe"}) || !reflect.DeepEqual(iptcData.Keywords, []string{"boats", "harbour"}) {
+		t.Errorf("Unexpected byline/keywords: %+v", iptcData)
+	}
+	if location := iptcData.Location(); location != "Hội An, Quảng Nam, Vietnam" {
+		t.Errorf("Expected joined location, got %q", location)
+	}
+	if location := (&IPTCData{Country: "Vietnam"}).Location(); location != "Vietnam" {
+		t.Errorf("Expected country only, got %q", location)
+	}
+}
+
+func TestFindIPTC(t *testing.T) {
+	data := withIPTC(testJPEG(t, 10, 10), agencyIPTC())
+	if iim := FindIPTC(data); !bytes.Equal(iim, agencyIPTC()) {
+		t.Errorf("Expected embedded IPTC, got %q", iim)
+	}
+	if iim := FindIPTC(testJPEG(t, 10, 10)); iim != nil {
+		t.Errorf("Expected no IPTC, got %q", iim)
+	}
+
+	meta := ExtractRawMetadata(data)
+	if meta.IPTC["City"] != "Hội An" {
+		t.Errorf("Expected IPTC in raw metadata, got %v", meta.IPTC)
+	}
+}
+
+func TestProcessPhotoIPTC(t *testing.T) {
+	DB = setupJobsTestDB(t)
+	Store = NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+
+	data := withIPTC(testJPEG(t, 64, 48), agencyIPTC())
+	Store.Put("agency.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg")
+
+	t.Run("IPTC fills blank fields", func(t *testing.T) {
+		photo := models.Photo{FilePath: "/uploads/agency.jpg", FileKey: "agency.jpg", Location: "From form"}
+		DB.Create(&photo)
+		if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+			t.Fatalf("ProcessPhoto failed: %v", err)
+		}
+
+		DB.First(&photo, photo.ID)
+		if photo.Description != "Fishing boats at dawn" || photo.Tags != "boats,harbour" {
+			t.Errorf("Expected IPTC values, got description=%q tags=%q", photo.Description, photo.Tags)
+		}
+		if photo.Author != "Jane Doe" || photo.Copyright != "© 2024 Example Agency" {
+			t.Errorf("Expected author and copyright, got %q %q", photo.Author, photo.Copyright)
+		}
+		if photo.Location != "From form" {
+			t.Errorf("Expected form location to be kept, got %q", photo.Location)
+		}
+	})
+
+	t.Run("XMP takes precedence over IPTC", func(t *testing.T) {
+		both := withXMP(data, lightroomXMP)
+		Store.Put("both.jpg", bytes.NewReader(both), int64(len(both)), "image/jpeg")
+
+		photo := models.Photo{FilePath: "/uploads/both.jpg", FileKey: "both.jpg"}
+		DB.Create(&photo)
+		if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+			t.Fatalf("ProcessPhoto failed: %v", err)
+		}
+
+		DB.First(&photo, photo.ID)
+		if photo.Tags != "beach,sunset" {
+			t.Errorf("Expected XMP keywords, got %q", photo.Tags)
+		}
+		if photo.Location != "Hội An, Quảng Nam, Vietnam" || photo.Author != "Jane Doe" {
+			t.Errorf("Expected IPTC-only fields to be filled, got location=%q author=%q", photo.Location, photo.Author)
+		}
+	})
+}
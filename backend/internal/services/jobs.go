@@ -0,0 +1,330 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"picsite/internal/config"
+	"picsite/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// 任务类型
+const (
+	// JobTypeProcessPhoto 上传后的照片处理：读取图片信息和 EXIF、生成缩略图、占位预览和尺寸版本
+	JobTypeProcessPhoto = "process_photo"
+	// JobTypeWatermarkPhoto 照片或相册的水印开关变更后重新生成公开的图片
+	JobTypeWatermarkPhoto = "watermark_photo"
+)
+
+// ErrJobNotRetryable 任务不存在或不是失败状态
+var ErrJobNotRetryable = errors.New("job is not failed")
+
+const (
+	defaultJobPollInterval = time.Second
+	maxJobRetryBackoff     = time.Hour
+)
+
+// JobHandler 某一类任务的处理方式
+type JobHandler struct {
+	// Run 执行任务，返回错误时按退避时间重试
+	Run func(job *models.Job) error
+	// Failed 在重试次数用尽后调用，可为空
+	Failed func(job *models.Job, err error)
+}
+
+// QueueOptions 任务队列配置，零值使用默认值
+type QueueOptions struct {
+	Workers      int           // 并发执行的 worker 数量，默认 2
+	MaxAttempts  int           // 每个任务最多执行次数，默认 3
+	RetryBackoff time.Duration // 首次重试等待时间，之后每次翻倍，默认 30 秒
+	PollInterval time.Duration // 没有新任务通知时轮询数据库的间隔，默认 1 秒
+}
+
+// JobQueue 基于数据库 jobs 表的持久化任务队列
+type JobQueue struct {
+	db       *gorm.DB
+	opts     QueueOptions
+	handlers map[string]JobHandler
+
+	claimMu sync.Mutex // 同一进程内串行领取任务
+	wake    chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Queue 当前使用的任务队列
+var Queue *JobQueue
+
+// InitJobQueue 根据配置创建任务队列并注册内置任务，需要先初始化数据库
+func InitJobQueue(cfg *config.Config) {
+	Queue = NewJobQueue(DB, QueueOptions{
+		Workers:      cfg.JobWorkers,
+		MaxAttempts:  cfg.JobMaxAttempts,
+		RetryBackoff: time.Duration(cfg.JobRetryBackoff) * time.Second,
+	})
+	Queue.Register(JobTypeProcessPhoto, JobHandler{
+		Run:    runProcessPhotoJob,
+		Failed: failProcessPhotoJob,
+	})
+	Queue.Register(JobTypeWatermarkPhoto, JobHandler{
+		Run: runWatermarkPhotoJob,
+	})
+}
+
+func GetJobQueue() *JobQueue {
+	return Queue
+}
+
+// NewJobQueue 创建任务队列，需调用 Start 启动 worker
+func NewJobQueue(db *gorm.DB, opts QueueOptions) *JobQueue {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 30 * time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultJobPollInterval
+	}
+
+	return &JobQueue{
+		db:       db,
+		opts:     opts,
+		handlers: make(map[string]JobHandler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Register 注册任务类型的处理方式
+func (q *JobQueue) Register(jobType string, handler JobHandler) {
+	q.handlers[jobType] = handler
+}
+
+// Enqueue 添加一个立即可执行的任务
+func (q *JobQueue) Enqueue(jobType string, photoID uint) (*models.Job, error) {
+	job, err := q.EnqueueTx(q.db, jobType, photoID)
+	if err != nil {
+		return nil, err
+	}
+	q.Notify()
+	return job, nil
+}
+
+// EnqueueTx 在指定事务中添加任务，事务提交后应调用 Notify 唤醒 worker
+func (q *JobQueue) EnqueueTx(tx *gorm.DB, jobType string, photoID uint) (*models.Job, error) {
+	job := &models.Job{
+		Type:        jobType,
+		PhotoID:     photoID,
+		Status:      models.JobStatusPending,
+		MaxAttempts: q.opts.MaxAttempts,
+		RunAt:       time.Now(),
+	}
+	if err := tx.Create(job).Error; err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return job, nil
+}
+
+// Notify 唤醒一个空闲的 worker
+func (q *JobQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Retry 将失败的任务重新放回队列
+func (q *JobQueue) Retry(id uint) (*models.Job, error) {
+	result := q.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", id, models.JobStatusFailed).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusPending,
+			"attempts":    0,
+			"run_at":      time.Now(),
+			"finished_at": nil,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrJobNotRetryable
+	}
+
+	var job models.Job
+	if err := q.db.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	q.Notify()
+	return &job, nil
+}
+
+// Start 启动 worker，并将上次退出时中断的任务重新放回队列
+func (q *JobQueue) Start() {
+	if err := q.db.Model(&models.Job{}).
+		Where("status = ?", models.JobStatusRunning).
+		Update("status", models.JobStatusPending).Error; err != nil {
+		fmt.Printf("Failed to requeue interrupted jobs: %v\n", err)
+	}
+
+	q.stop = make(chan struct{})
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Stop 停止 worker 并等待正在执行的任务完成
+func (q *JobQueue) Stop() {
+	if q.stop == nil {
+		return
+	}
+	close(q.stop)
+	q.wg.Wait()
+	q.stop = nil
+}
+
+// RunPending 在当前 goroutine 中依次执行所有已到期的任务，返回执行的任务数
+func (q *JobQueue) RunPending() int {
+	count := 0
+	for q.runNext() {
+		count++
+	}
+	return count
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for q.runNext() {
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNext 领取并执行一个任务，没有可执行的任务时返回 false
+func (q *JobQueue) runNext() bool {
+	job := q.claim()
+	if job == nil {
+		return false
+	}
+	q.execute(job)
+	return true
+}
+
+// claim 领取最早到期的待执行任务并标记为执行中
+func (q *JobQueue) claim() *models.Job {
+	q.claimMu.Lock()
+	defer q.claimMu.Unlock()
+
+	now := time.Now()
+	// 使用 Find 而不是 First，队列为空时不会在每次轮询时记录 record not found
+	var jobs []models.Job
+	if err := q.db.Where("status = ? AND run_at <= ?", models.JobStatusPending, now).
+		Order("run_at ASC, id ASC").
+		Limit(1).
+		Find(&jobs).Error; err != nil {
+		fmt.Printf("Failed to fetch pending job: %v\n", err)
+		return nil
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	job := jobs[0]
+
+	// 带状态条件更新，避免多个进程同时领取同一个任务
+	result := q.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", job.ID, models.JobStatusPending).
+		Updates(map[string]interface{}{
+			"status":     models.JobStatusRunning,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"started_at": now,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil
+	}
+
+	job.Status = models.JobStatusRunning
+	job.Attempts++
+	job.StartedAt = &now
+	return &job
+}
+
+// execute 执行任务并根据结果更新状态，失败时按指数退避重新排队
+func (q *JobQueue) execute(job *models.Job) {
+	handler, ok := q.handlers[job.Type]
+	var err error
+	if ok {
+		err = runJob(handler, job)
+	} else {
+		err = fmt.Errorf("unknown job type: %s", job.Type)
+		job.Attempts = job.MaxAttempts // 未知类型重试也没有意义
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{}
+	switch {
+	case err == nil:
+		updates["status"] = models.JobStatusDone
+		updates["last_error"] = ""
+		updates["finished_at"] = now
+	case job.Attempts < job.MaxAttempts:
+		updates["status"] = models.JobStatusPending
+		updates["last_error"] = err.Error()
+		updates["run_at"] = now.Add(q.backoff(job.Attempts))
+		fmt.Printf("Job %d (%s) failed, will retry: %v\n", job.ID, job.Type, err)
+	default:
+		updates["status"] = models.JobStatusFailed
+		updates["last_error"] = err.Error()
+		updates["finished_at"] = now
+		fmt.Printf("Job %d (%s) failed after %d attempts: %v\n", job.ID, job.Type, job.Attempts, err)
+	}
+
+	if dbErr := q.db.Model(job).Updates(updates).Error; dbErr != nil {
+		fmt.Printf("Failed to update job %d: %v\n", job.ID, dbErr)
+	}
+
+	if updates["status"] == models.JobStatusFailed && handler.Failed != nil {
+		handler.Failed(job, err)
+	}
+}
+
+// runJob 执行任务处理函数，将 panic 转换为错误
+func runJob(handler JobHandler, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler.Run(job)
+}
+
+// backoff 返回第 attempts 次失败后的等待时间
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	d := q.opts.RetryBackoff
+	for i := 1; i < attempts && d < maxJobRetryBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxJobRetryBackoff)
+}
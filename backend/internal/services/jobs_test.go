@@ -0,0 +1,280 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"picsite/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupJobsTestDB 创建基于临时文件的测试数据库，worker 会在多个连接上并发访问
+func setupJobsTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(sqliteDSN(filepath.Join(t.TempDir(), "test.db"))), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := AutoMigrate(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	return db
+}
+
+func TestJobQueueRunPending(t *testing.T) {
+	db := setupJobsTestDB(t)
+	queue := NewJobQueue(db, QueueOptions{MaxAttempts: 2, RetryBackoff: time.Hour})
+
+	var ran []uint
+	var failed []uint
+	queue.Register("ok", JobHandler{Run: func(job *models.Job) error {
+		ran = append(ran, job.PhotoID)
+		return nil
+	}})
+	queue.Register("broken", JobHandler{
+		Run: func(job *models.Job) error { return errors.New("boom") },
+		Failed: func(job *models.Job, err error) {
+			failed = append(failed, job.ID)
+		},
+	})
+
+	t.Run("successful job is marked done", func(t *testing.T) {
+		job, err := queue.Enqueue("ok", 7)
+		if err != nil {
+			t.Fatalf("Failed to enqueue job: %v", err)
+		}
+
+		if n := queue.RunPending(); n != 1 {
+			t.Errorf("Expected 1 job to run, got %d", n)
+		}
+		if len(ran) != 1 || ran[0] != 7 {
+			t.Errorf("Expected handler to run for photo 7, got %v", ran)
+		}
+
+		db.First(job, job.ID)
+		if job.Status != models.JobStatusDone || job.Attempts != 1 || job.FinishedAt == nil {
+			t.Errorf("Unexpected job state: %+v", job)
+		}
+	})
+
+	t.Run("failed job is retried with backoff", func(t *testing.T) {
+		job, _ := queue.Enqueue("broken", 1)
+
+		queue.RunPending()
+		db.First(job, job.ID)
+		if job.Status != models.JobStatusPending || job.Attempts != 1 || job.LastError != "boom" {
+			t.Fatalf("Expected job to be rescheduled, got %+v", job)
+		}
+		if time.Until(job.RunAt) < 30*time.Minute {
+			t.Errorf("Expected retry to be delayed by backoff, run_at %v", job.RunAt)
+		}
+
+		// 退避时间未到时不会执行
+		if n := queue.RunPending(); n != 0 {
+			t.Errorf("Expected no due jobs, got %d", n)
+		}
+
+		// 最后一次失败后标记为 failed 并调用 Failed
+		db.Model(job).Update("run_at", time.Now())
+		queue.RunPending()
+		db.First(job, job.ID)
+		if job.Status != models.JobStatusFailed || job.Attempts != 2 {
+			t.Errorf("Expected job to fail after 2 attempts, got %+v", job)
+		}
+		if len(failed) != 1 || failed[0] != job.ID {
+			t.Errorf("Expected Failed hook for job %d, got %v", job.ID, failed)
+		}
+
+		t.Run("retry requeues failed job", func(t *testing.T) {
+			retried, err := queue.Retry(job.ID)
+			if err != nil {
+				t.Fatalf("Failed to retry job: %v", err)
+			}
+			if retried.Status != models.JobStatusPending || retried.Attempts != 0 {
+				t.Errorf("Expected pending job with no attempts, got %+v", retried)
+			}
+
+			if _, err := queue.Retry(job.ID); !errors.Is(err, ErrJobNotRetryable) {
+				t.Errorf("Expected ErrJobNotRetryable for pending job, got %v", err)
+			}
+		})
+	})
+
+	t.Run("unknown job type fails immediately", func(t *testing.T) {
+		job, _ := queue.Enqueue("missing", 0)
+		queue.RunPending()
+
+		db.First(job, job.ID)
+		if job.Status != models.JobStatusFailed {
+			t.Errorf("Expected unknown job type to fail, got %+v", job)
+		}
+	})
+}
+
+func TestJobQueueBackoff(t *testing.T) {
+	queue := NewJobQueue(nil, QueueOptions{RetryBackoff: 10 * time.Second})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := queue.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestJobQueueWorkers(t *testing.T) {
+	db := setupJobsTestDB(t)
+	queue := NewJobQueue(db, QueueOptions{Workers: 3, PollInterval: 10 * time.Millisecond})
+
+	var count atomic.Int32
+	queue.Register("count", JobHandler{Run: func(job *models.Job) error {
+		count.Add(1)
+		return nil
+	}})
+
+	// 模拟上次退出时中断的任务
+	db.Create(&models.Job{Type: "count", Status: models.JobStatusRunning, MaxAttempts: 1, RunAt: time.Now()})
+
+	queue.Start()
+	defer queue.Stop()
+
+	for i := 0; i < 10; i++ {
+		if _, err := queue.Enqueue("count", uint(i)); err != nil {
+			t.Fatalf("Failed to enqueue job: %v", err)
+		}
+	}
+
+	// 等待所有任务的状态写回数据库
+	var done int64
+	deadline := time.Now().Add(5 * time.Second)
+	for done < 11 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		db.Model(&models.Job{}).Where("status = ?", models.JobStatusDone).Count(&done)
+	}
+	if done != 11 {
+		t.Fatalf("Expected 11 done jobs, got %d", done)
+	}
+	if got := count.Load(); got != 11 {
+		t.Errorf("Expected each job to run exactly once, got %d runs", got)
+	}
+}
+
+func TestProcessPhoto(t *testing.T) {
+	DB = setupJobsTestDB(t)
+	Store = NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+
+	data := testJPEG(t, 640, 480)
+	if err := Store.Put("photo.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	photo := models.Photo{
+		Title:            "Pending",
+		FilePath:         "/uploads/photo.jpg",
+		FileKey:          "photo.jpg",
+		CameraModel:      "From Form",
+		ProcessingStatus: models.PhotoStatusPending,
+	}
+	DB.Create(&photo)
+
+	if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+		t.Fatalf("Failed to process photo: %v", err)
+	}
+
+	DB.First(&photo, photo.ID)
+	if photo.ProcessingStatus != models.PhotoStatusReady {
+		t.Errorf("Expected status ready, got %s", photo.ProcessingStatus)
+	}
+	if photo.Width != 640 || photo.Height != 480 || photo.MimeType != "image/jpeg" {
+		t.Errorf("Unexpected image info: %dx%d %s", photo.Width, photo.Height, photo.MimeType)
+	}
+	if photo.ThumbnailKey != "photo_thumb.jpg" || photo.ThumbnailPath != "/uploads/photo_thumb.jpg" {
+		t.Errorf("Unexpected thumbnail: %s, %s", photo.ThumbnailKey, photo.ThumbnailPath)
+	}
+	thumbnail, err := ReadObject(Store, photo.ThumbnailKey)
+	if err != nil {
+		t.Fatalf("Failed to read thumbnail: %v", err)
+	}
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumbnail)); err != nil || cfg.Width != ThumbnailWidth || cfg.Height != 300 {
+		t.Errorf("Expected %dx300 JPEG thumbnail, got %+v (%v)", ThumbnailWidth, cfg, err)
+	}
+	if photo.BlurHash == "" {
+		t.Error("Expected blurhash to be set")
+	}
+	if len(photo.PerceptualHash) != 16 || len(photo.ColorHistogram) != 2*colorHistogramBins {
+		t.Errorf("Expected visual features to be set, got %q, %q", photo.PerceptualHash, photo.ColorHistogram)
+	}
+	if photo.CameraModel != "From Form" {
+		t.Errorf("Expected form value to be kept, got %s", photo.CameraModel)
+	}
+	// 早期照片没有内容哈希，处理时补上
+	if photo.ContentHash != ContentHash(data) {
+		t.Errorf("Expected content hash to be backfilled, got %q", photo.ContentHash)
+	}
+
+	var palette []models.PhotoColor
+	DB.Where("photo_id = ?", photo.ID).Find(&palette)
+	if len(palette) == 0 || palette[0].Color == "" {
+		t.Errorf("Expected palette to be saved, got %+v", palette)
+	}
+
+	var metadata models.PhotoMetadata
+	if err := DB.First(&metadata, "photo_id = ?", photo.ID).Error; err != nil {
+		t.Errorf("Expected metadata record: %v", err)
+	}
+
+	t.Run("eager renditions are saved", func(t *testing.T) {
+		original := ImageConfig
+		defer func() { ImageConfig = original }()
+		ImageConfig.RenditionMode = RenditionModeEager
+		ImageConfig.RenditionWidths = []int{200, 800}
+		ImageConfig.RenditionFormats = []string{FormatJPEG}
+
+		if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+			t.Fatalf("Failed to process photo: %v", err)
+		}
+
+		var renditions []models.PhotoRendition
+		DB.Where("photo_id = ?", photo.ID).Order("width ASC").Find(&renditions)
+		if len(renditions) != 2 || renditions[0].Width != 200 || renditions[1].Width != 640 {
+			t.Fatalf("Expected 200 and 640 wide renditions, got %+v", renditions)
+		}
+		for _, r := range renditions {
+			if _, err := Store.Stat(r.Key); err != nil {
+				t.Errorf("Expected rendition %s to be stored: %v", r.Key, err)
+			}
+		}
+	})
+
+	t.Run("missing photo is ignored", func(t *testing.T) {
+		if err := ProcessPhoto(9999, ProcessOptions{}); err != nil {
+			t.Errorf("Expected no error for deleted photo, got %v", err)
+		}
+	})
+
+	t.Run("missing file fails", func(t *testing.T) {
+		broken := models.Photo{Title: "Broken", FilePath: "/uploads/gone.jpg", FileKey: "gone.jpg"}
+		DB.Create(&broken)
+		if err := ProcessPhoto(broken.ID, ProcessOptions{}); err == nil {
+			t.Error("Expected error for missing file")
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"picsite/internal/models"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxRawBytesValue 超过该长度的二进制标签值（如 MakerNote）只记录长度
+const maxRawBytesValue = 64
+
+// RawMetadata 图片中的完整元数据，按来源分组保存为 JSON
+type RawMetadata struct {
+	// EXIF 按 IFD 路径（如 "IFD"、"IFD/Exif"、"IFD/GPSInfo"）分组的标签，未知标签以 "0xA500" 形式的编号为键
+	EXIF map[string]map[string]interface{} `json:"exif,omitempty"`
+	// XMP 嵌入的或 .xmp 附属文件中的属性，以 "dc:title" 形式的名称为键，见 ParseXMPProperties
+	XMP map[string]interface{} `json:"xmp,omitempty"`
+	// IPTC JPEG APP13 中的 IPTC-IIM 数据集，以 "Caption-Abstract" 形式的名称为键，见 ParseIPTCProperties
+	IPTC map[string]interface{} `json:"iptc,omitempty"`
+}
+
+// IsEmpty 是否没有读取到任何元数据
+func (m *RawMetadata) IsEmpty() bool {
+	return len(m.EXIF) == 0 && len(m.XMP) == 0 && len(m.IPTC) == 0
+}
+
+// ExtractRawMetadata 读取图片中的全部元数据，数值类标签转换为数字，便于查询
+func ExtractRawMetadata(data []byte) *RawMetadata {
+	meta := &RawMetadata{}
+
+	for _, tag := range readEXIFTags(data) {
+		// 子 IFD 指针只是偏移量，内容已经按各自的 IFD 路径展开
+		if tag.ChildIfdPath != "" {
+			continue
+		}
+
+		name := tag.TagName
+		if name == "" {
+			name = fmt.Sprintf("0x%04X", tag.TagId)
+		}
+
+		if meta.EXIF == nil {
+			meta.EXIF = make(map[string]map[string]interface{})
+		}
+		ifd := meta.EXIF[tag.IfdPath]
+		if ifd == nil {
+			ifd = make(map[string]interface{})
+			meta.EXIF[tag.IfdPath] = ifd
+		}
+		ifd[name] = rawTagValue(tag)
+	}
+
+	if packet := FindXMPPacket(data); packet != nil {
+		meta.SetXMP(packet)
+	}
+
+	if iim := FindIPTC(data); iim != nil {
+		props, err := ParseIPTCProperties(iim)
+		if err != nil {
+			fmt.Printf("Failed to parse IPTC: %v\n", err)
+		}
+		if len(props) > 0 {
+			meta.IPTC = props
+		}
+	}
+
+	return meta
+}
+
+// SetXMP 解析 XMP 数据包并替换已有的 XMP 属性，格式错误时保留已经读取到的部分
+func (m *RawMetadata) SetXMP(packet []byte) {
+	props, err := ParseXMPProperties(packet)
+	if err != nil {
+		fmt.Printf("Failed to parse XMP: %v\n", err)
+	}
+	if len(props) > 0 {
+		m.XMP = props
+	}
+}
+
+// rawTagValue 将标签值转换为可以编码为 JSON 的形式，只有一个元素的数组展开为单个值
+func rawTagValue(tag exif.ExifTag) interface{} {
+	switch v := tag.Value.(type) {
+	case string:
+		return strings.TrimRight(v, "\x00 ")
+	case []uint16:
+		return singleOrSlice(v)
+	case []uint32:
+		return singleOrSlice(v)
+	case []int32:
+		return singleOrSlice(v)
+	case []exifcommon.Rational:
+		values := make([]interface{}, len(v))
+		for i, r := range v {
+			values[i] = rationalNumber(float64(r.Numerator), float64(r.Denominator))
+		}
+		return singleOrSlice(values)
+	case []exifcommon.SignedRational:
+		values := make([]interface{}, len(v))
+		for i, r := range v {
+			values[i] = rationalNumber(float64(r.Numerator), float64(r.Denominator))
+		}
+		return singleOrSlice(values)
+	case []byte:
+		if len(v) > maxRawBytesValue {
+			return fmt.Sprintf("(%d bytes)", len(v))
+		}
+		values := make([]int, len(v))
+		for i, b := range v {
+			values[i] = int(b)
+		}
+		return singleOrSlice(values)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	if len(tag.ValueBytes) > maxRawBytesValue {
+		return fmt.Sprintf("(%d bytes)", len(tag.ValueBytes))
+	}
+	return tag.Formatted
+}
+
+// rationalNumber 计算有理数的值，分母为 0 时返回 nil
+func rationalNumber(numerator, denominator float64) interface{} {
+	if denominator == 0 {
+		return nil
+	}
+	return math.Round(numerator/denominator*1e6) / 1e6
+}
+
+func singleOrSlice[T any](values []T) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// SavePhotoMetadata 保存照片的完整元数据，已有记录时覆盖
+func SavePhotoMetadata(tx *gorm.DB, photoID uint, meta *RawMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	record := models.PhotoMetadata{PhotoID: photoID, Data: string(data)}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "photo_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
+	}).Create(&record).Error
+}
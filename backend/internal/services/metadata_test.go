@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"picsite/internal/models"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+func TestExtractRawMetadata(t *testing.T) {
+	data := withEXIF(t, testJPEG(t, 10, 10), func(root *exif.IfdBuilder) {
+		setEXIFTag(t, root, "IFD", "Model", "Test Camera")
+		setEXIFTag(t, root, "IFD/Exif", "FNumber", []exifcommon.Rational{{Numerator: 28, Denominator: 10}})
+		setEXIFTag(t, root, "IFD/Exif", "ISOSpeedRatings", []uint16{400})
+		setEXIFTag(t, root, "IFD/Exif", "ExposureBiasValue", []exifcommon.SignedRational{{Numerator: -1, Denominator: 3}})
+		setEXIFTag(t, root, "IFD/GPSInfo", "GPSVersionID", []byte{2, 3, 0, 0})
+	})
+
+	meta := ExtractRawMetadata(data)
+	if meta.IsEmpty() {
+		t.Fatal("Expected metadata to be extracted")
+	}
+
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("Failed to encode metadata: %v", err)
+	}
+	var decoded map[string]map[string]map[string]interface{}
+	json.Unmarshal(encoded, &decoded)
+
+	tests := []struct {
+		ifd, tag string
+		want     interface{}
+	}{
+		{"IFD", "Model", "Test Camera"},
+		{"IFD/Exif", "FNumber", 2.8},
+		{"IFD/Exif", "ISOSpeedRatings", 400.0},
+		{"IFD/Exif", "ExposureBiasValue", -0.333333},
+	}
+	for _, tt := range tests {
+		if got := decoded["exif"][tt.ifd][tt.tag]; got != tt.want {
+			t.Errorf("Expected %s/%s = %v, got %v", tt.ifd, tt.tag, tt.want, got)
+		}
+	}
+	if version, ok := decoded["exif"]["IFD/GPSInfo"]["GPSVersionID"].([]interface{}); !ok || len(version) != 4 {
+		t.Errorf("Expected GPS version bytes as numbers, got %v", decoded["exif"]["IFD/GPSInfo"]["GPSVersionID"])
+	}
+	if _, ok := decoded["exif"]["IFD"]["ExifTag"]; ok {
+		t.Error("Expected sub-IFD pointers to be skipped")
+	}
+
+	if meta := ExtractRawMetadata(testJPEG(t, 10, 10)); !meta.IsEmpty() {
+		t.Errorf("Expected empty metadata for image without EXIF, got %+v", meta)
+	}
+}
+
+func TestSavePhotoMetadata(t *testing.T) {
+	DB = setupJobsTestDB(t)
+
+	meta := &RawMetadata{EXIF: map[string]map[string]interface{}{"IFD": {"Model": "First"}}}
+	if err := SavePhotoMetadata(DB, 1, meta); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	meta.EXIF["IFD"]["Model"] = "Second"
+	if err := SavePhotoMetadata(DB, 1, meta); err != nil {
+		t.Fatalf("Failed to overwrite metadata: %v", err)
+	}
+
+	var count int64
+	DB.Model(&models.PhotoMetadata{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 metadata record, got %d", count)
+	}
+
+	// 可以直接用 json_extract 查询
+	var model string
+	DB.Model(&models.PhotoMetadata{}).Where("photo_id = ?", 1).
+		Select("json_extract(data, '$.exif.IFD.Model')").Scan(&model)
+	if model != "Second" {
+		t.Errorf("Expected model 'Second', got %q", model)
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"picsite/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// 调色板参数
+const (
+	PaletteSize           = 5    // 每张照片提取的颜色数
+	DefaultColorTolerance = 20.0 // 按颜色筛选时默认允许的色差（CIE76 ΔE）
+	MaxColorTolerance     = 100.0
+	minPaletteWeight      = 0.01 // 占比低于 1% 的颜色不计入调色板
+	paletteMaxSampleSize  = 64
+	xyzWhiteX, xyzWhiteZ  = 0.95047, 1.08883 // D65 白点（Y = 1）
+	labEpsilon, labKappa  = 216.0 / 24389, 24389.0 / 27
+)
+
+// ErrInvalidColor 颜色格式错误
+var ErrInvalidColor = errors.New("invalid color, expected #rrggbb")
+
+// ExtractPalette 用中位切分法提取图片的主要颜色，按占比从高到低返回，最多 PaletteSize 个
+//
+// 所有不透明像素放在一个 RGB 盒子中，反复把“像素数 × 最长边”最大的盒子沿最长的通道从中位数处一分为二，
+// 直到得到 PaletteSize 个盒子，每个盒子的平均色即为调色板中的一种颜色。结果是确定的，相同图片总是得到相同的调色板
+func ExtractPalette(img image.Image) []models.PhotoColor {
+	small := imaging.Fit(img, paletteMaxSampleSize, paletteMaxSampleSize, imaging.Box)
+	nrgba := imaging.Clone(small)
+
+	var pixels [][3]uint8
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i+3] < 128 {
+			continue
+		}
+		pixels = append(pixels, [3]uint8{nrgba.Pix[i], nrgba.Pix[i+1], nrgba.Pix[i+2]})
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := []colorBox{{pixels: pixels}}
+	for len(boxes) < PaletteSize {
+		// 选出最值得切分的盒子，只有一种颜色的盒子无法再分
+		best, bestScore := -1, 0
+		for i, box := range boxes {
+			if _, spread := box.widestChannel(); spread > 0 && len(box.pixels) > 1 {
+				if score := len(box.pixels) * spread; score > bestScore {
+					best, bestScore = i, score
+				}
+			}
+		}
+		if best < 0 {
+			break
+		}
+		a, b := boxes[best].split()
+		boxes = append(append(boxes[:best:best], a, b), boxes[best+1:]...)
+	}
+
+	palette := make([]models.PhotoColor, 0, len(boxes))
+	for _, box := range boxes {
+		weight := float64(len(box.pixels)) / float64(len(pixels))
+		if weight < minPaletteWeight {
+			continue
+		}
+		r, g, b := box.average()
+		palette = append(palette, NewPhotoColor(r, g, b, weight))
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Weight > palette[j].Weight })
+	for i := range palette {
+		palette[i].Position = i
+	}
+	return palette
+}
+
+// colorBox 中位切分中的一组像素
+type colorBox struct {
+	pixels [][3]uint8
+}
+
+// widestChannel 返回取值范围最大的通道及其范围
+func (b colorBox) widestChannel() (int, int) {
+	channel, spread := 0, -1
+	for c := 0; c < 3; c++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, p := range b.pixels {
+			lo, hi = min(lo, p[c]), max(hi, p[c])
+		}
+		if s := int(hi) - int(lo); s > spread {
+			channel, spread = c, s
+		}
+	}
+	return channel, spread
+}
+
+// split 沿最长的通道在中位数附近把盒子分为两半，该通道取值相同的像素总是分在同一边
+func (b colorBox) split() (colorBox, colorBox) {
+	channel, _ := b.widestChannel()
+	pixels := append([][3]uint8(nil), b.pixels...)
+	sort.SliceStable(pixels, func(i, j int) bool { return pixels[i][channel] < pixels[j][channel] })
+
+	median := pixels[len(pixels)/2][channel]
+	mid := sort.Search(len(pixels), func(i int) bool { return pixels[i][channel] >= median })
+	if mid == 0 {
+		mid = sort.Search(len(pixels), func(i int) bool { return pixels[i][channel] > median })
+	}
+	return colorBox{pixels[:mid]}, colorBox{pixels[mid:]}
+}
+
+// average 返回盒子中像素的平均色
+func (b colorBox) average() (uint8, uint8, uint8) {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+	n := len(b.pixels)
+	return uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n)
+}
+
+// NewPhotoColor 创建调色板中的一种颜色，同时计算其 CIELAB 坐标用于按色差筛选
+func NewPhotoColor(r, g, b uint8, weight float64) models.PhotoColor {
+	l, a, bb := RGBToLab(r, g, b)
+	return models.PhotoColor{
+		Color:  fmt.Sprintf("#%02x%02x%02x", r, g, b),
+		Weight: math.Round(weight*1000) / 1000,
+		LabL:   l,
+		LabA:   a,
+		LabB:   bb,
+	}
+}
+
+// RGBToLab 把 sRGB 颜色转换为 CIELAB（D65 白点）
+func RGBToLab(r, g, b uint8) (float64, float64, float64) {
+	lr, lg, lb := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / xyzWhiteX
+	y := 0.2126729*lr + 0.7151522*lg + 0.0721750*lb
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / xyzWhiteZ
+
+	f := func(t float64) float64 {
+		if t > labEpsilon {
+			return math.Cbrt(t)
+		}
+		return (labKappa*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// ParseHexColor 解析 "#rrggbb" 或 "rrggbb" 格式的颜色
+func ParseHexColor(value string) (uint8, uint8, uint8, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(value) != 6 {
+		return 0, 0, 0, ErrInvalidColor
+	}
+	n, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return 0, 0, 0, ErrInvalidColor
+	}
+	return uint8(n >> 16), uint8(n >> 8), uint8(n), nil
+}
+
+// PaletteColorCondition 返回筛选调色板中有与指定颜色色差不超过 tolerance（CIE76 ΔE）的照片的 SQL 条件和参数
+func PaletteColorCondition(r, g, b uint8, tolerance float64) (string, []interface{}) {
+	l, a, bb := RGBToLab(r, g, b)
+	return "id IN (SELECT photo_id FROM photo_colors WHERE " +
+			"(lab_l - ?) * (lab_l - ?) + (lab_a - ?) * (lab_a - ?) + (lab_b - ?) * (lab_b - ?) <= ?)",
+		[]interface{}{l, l, a, a, bb, bb, tolerance * tolerance}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestExtractPalette(t *testing.T) {
+	// 60% 橙色、30% 深蓝、10% 白色
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			c := color.NRGBA{0xe8, 0x6a, 0x2c, 255}
+			switch {
+			case x >= 9:
+				c = color.NRGBA{0xff, 0xff, 0xff, 255}
+			case x >= 6:
+				c = color.NRGBA{0x20, 0x20, 0x40, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	palette := ExtractPalette(img)
+	if len(palette) != 3 {
+		t.Fatalf("Expected 3 colors, got %+v", palette)
+	}
+	want := []struct {
+		color  string
+		weight float64
+	}{{"#e86a2c", 0.6}, {"#202040", 0.3}, {"#ffffff", 0.1}}
+	for i, w := range want {
+		if palette[i].Color != w.color || palette[i].Weight != w.weight || palette[i].Position != i {
+			t.Errorf("Expected %s (%.1f) at %d, got %+v", w.color, w.weight, i, palette[i])
+		}
+	}
+
+	t.Run("solid color", func(t *testing.T) {
+		solid := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		for i := range solid.Pix {
+			solid.Pix[i] = 0xff
+		}
+		if palette := ExtractPalette(solid); len(palette) != 1 || palette[0].Color != "#ffffff" || palette[0].Weight != 1 {
+			t.Errorf("Expected a single white color, got %+v", palette)
+		}
+	})
+
+	t.Run("transparent", func(t *testing.T) {
+		if palette := ExtractPalette(image.NewNRGBA(image.Rect(0, 0, 8, 8))); palette != nil {
+			t.Errorf("Expected nil for transparent image, got %+v", palette)
+		}
+	})
+}
+
+func TestRGBToLab(t *testing.T) {
+	tests := []struct {
+		r, g, b  uint8
+		l, a, bb float64
+	}{
+		{255, 255, 255, 100, 0, 0},
+		{0, 0, 0, 0, 0, 0},
+		{255, 0, 0, 53.24, 80.09, 67.20},
+		{0, 0, 255, 32.30, 79.19, -107.86},
+	}
+	for _, tt := range tests {
+		l, a, b := RGBToLab(tt.r, tt.g, tt.b)
+		if math.Abs(l-tt.l) > 0.05 || math.Abs(a-tt.a) > 0.05 || math.Abs(b-tt.bb) > 0.05 {
+			t.Errorf("RGBToLab(%d, %d, %d) = %.2f, %.2f, %.2f, want %.2f, %.2f, %.2f", tt.r, tt.g, tt.b, l, a, b, tt.l, tt.a, tt.bb)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	for _, value := range []string{"#1e5a9c", "1E5A9C", " #1e5a9c "} {
+		r, g, b, err := ParseHexColor(value)
+		if err != nil || r != 0x1e || g != 0x5a || b != 0x9c {
+			t.Errorf("ParseHexColor(%q) = %d, %d, %d, %v", value, r, g, b, err)
+		}
+	}
+	for _, value := range []string{"", "#fff", "red", "#12345g", "+12345"} {
+		if _, _, _, err := ParseHexColor(value); err != ErrInvalidColor {
+			t.Errorf("Expected error for %q, got %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// 占位图参数
+const (
+	LQIPWidth      = 16 // 低质量预览图宽度
+	LQIPQuality    = 50
+	BlurHashX      = 4 // BlurHash 水平分量数
+	BlurHashY      = 3 // BlurHash 垂直分量数
+	placeholderMax = 64
+)
+
+// Placeholder 图片加载前用于占位的预览信息
+type Placeholder struct {
+	BlurHash      string // BlurHash 字符串
+	LQIP          string // data URI 形式的低质量 JPEG 预览图
+	DominantColor string // 主色调，格式为 #rrggbb
+}
+
+// GeneratePlaceholderFromUpload 为已上传的图片计算占位预览信息
+func GeneratePlaceholderFromUpload(store Storage, key string) (*Placeholder, error) {
+	src, err := store.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source image: %w", err)
+	}
+	defer src.Close()
+
+	img, err := decodeImage(src, filepath.Ext(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return GeneratePlaceholder(img)
+}
+
+// GeneratePlaceholder 计算图片的 BlurHash、LQIP 和主色调
+func GeneratePlaceholder(img image.Image) (*Placeholder, error) {
+	// 先缩小再计算，结果几乎没有差别但速度快得多
+	small := imaging.Fit(img, placeholderMax, placeholderMax, imaging.Box)
+
+	lqip, err := encodeLQIP(small)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Placeholder{
+		BlurHash:      EncodeBlurHash(small, BlurHashX, BlurHashY),
+		LQIP:          lqip,
+		DominantColor: DominantColor(small),
+	}, nil
+}
+
+// encodeLQIP 生成 LQIPWidth 宽的 JPEG 并编码为 data URI
+func encodeLQIP(img image.Image) (string, error) {
+	tiny := imaging.Resize(img, LQIPWidth, 0, imaging.Lanczos)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, tiny, &jpeg.Options{Quality: LQIPQuality}); err != nil {
+		return "", fmt.Errorf("failed to encode lqip: %w", err)
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DominantColor 返回图片中出现最多的颜色
+//
+// 每个通道量化为 16 级后统计出现次数，取次数最多的一组像素的平均色
+func DominantColor(img image.Image) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+
+	nrgba := imaging.Clone(img)
+	best := &bucket{}
+	for i := 0; i+3 < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i+3] < 128 {
+			continue // 忽略透明像素
+		}
+		r, g, b := int(nrgba.Pix[i]), int(nrgba.Pix[i+1]), int(nrgba.Pix[i+2])
+		k := (r>>4)<<8 | (g>>4)<<4 | b>>4
+		bk := buckets[k]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[k] = bk
+		}
+		bk.count++
+		bk.r += r
+		bk.g += g
+		bk.b += b
+		if bk.count > best.count {
+			best = bk
+		}
+	}
+
+	if best.count == 0 {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+// EncodeBlurHash 按 https://blurha.sh 的算法计算 BlurHash，分量数取值 1~9
+func EncodeBlurHash(img image.Image, xComponents, yComponents int) string {
+	nrgba := imaging.Clone(img)
+	width, height := nrgba.Bounds().Dx(), nrgba.Bounds().Dy()
+	if width == 0 || height == 0 {
+		return ""
+	}
+
+	// 预先转换到线性色彩空间
+	linear := make([][3]float64, width*height)
+	for i := range linear {
+		p := nrgba.Pix[i*4 : i*4+3]
+		linear[i] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := basisY * math.Cos(math.Pi*float64(i)*float64(x)/float64(width))
+					c := linear[y*width+x]
+					f[0] += basis * c[0]
+					f[1] += basis * c[1]
+					f[2] += basis * c[2]
+				}
+			}
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maximumValue := 1.0
+	if ac := factors[1:]; len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maximumValue = float64(quantisedMax+1) / 166
+		sb.WriteString(encodeBase83(quantisedMax, 1))
+	} else {
+		sb.WriteString(encodeBase83(0, 1))
+	}
+
+	dc := factors[0]
+	sb.WriteString(encodeBase83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+
+	for _, f := range factors[1:] {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encodeBase83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+
+	return sb.String()
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encodeBase83(value, length int) string {
+	buf := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buf[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(buf)
+}
+
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	c := math.Max(0, math.Min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
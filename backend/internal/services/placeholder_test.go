@@ -0,0 +1,62 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestEncodeBlurHash(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 8), uint8(y * 10), uint8((x + y) * 4), 255})
+		}
+	}
+
+	// 与参考实现 github.com/buckket/go-blurhash 的结果一致
+	if got, want := EncodeBlurHash(img, 4, 3), "LxH27b2kwzX5mAWYjuf7gKfkfQfj"; got != want {
+		t.Errorf("Expected blurhash %s, got %s", want, got)
+	}
+}
+
+func TestDominantColor(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			c := color.NRGBA{200, 30, 30, 255}
+			if x < 3 {
+				c = color.NRGBA{10, 10, 240, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	if got := DominantColor(img); got != "#c81e1e" {
+		t.Errorf("Expected #c81e1e, got %s", got)
+	}
+}
+
+func TestGeneratePlaceholderFromUpload(t *testing.T) {
+	store := NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+	data := testJPEG(t, 640, 480)
+	if err := store.Put("photo.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	placeholder, err := GeneratePlaceholderFromUpload(store, "photo.jpg")
+	if err != nil {
+		t.Fatalf("Failed to generate placeholder: %v", err)
+	}
+	if len(placeholder.BlurHash) != 28 {
+		t.Errorf("Expected 28 character blurhash for 4x3 components, got %q", placeholder.BlurHash)
+	}
+	if !strings.HasPrefix(placeholder.LQIP, "data:image/jpeg;base64,") {
+		t.Errorf("Expected jpeg data URI, got %q", placeholder.LQIP)
+	}
+	if len(placeholder.DominantColor) != 7 || placeholder.DominantColor[0] != '#' {
+		t.Errorf("Expected #rrggbb dominant color, got %q", placeholder.DominantColor)
+	}
+}
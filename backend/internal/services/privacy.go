@@ -0,0 +1,597 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strings"
+
+	"picsite/internal/models"
+)
+
+// 公开原图的元数据处理方式
+const (
+	// MetadataKeep 原样公开原图
+	MetadataKeep = "keep"
+	// MetadataStripGPS 抹去 GPS、相机和镜头序列号、MakerNote 以及 XMP
+	MetadataStripGPS = "strip_gps"
+	// MetadataStripAll 抹去除方向以外的全部 EXIF，以及 XMP、IPTC 和注释
+	MetadataStripAll = "strip_all"
+)
+
+// publicCopySuffix 公开副本 key 的后缀，例如 "1700000000_a_public.jpg"
+const publicCopySuffix = "_public"
+
+// ErrMetadataUnsupported 无法在不重新编码的情况下处理该格式的元数据
+var ErrMetadataUnsupported = errors.New("metadata scrubbing not supported for this format")
+
+// IsValidMetadataPrivacy 判断是否为支持的元数据处理方式
+func IsValidMetadataPrivacy(mode string) bool {
+	switch mode {
+	case MetadataKeep, MetadataStripGPS, MetadataStripAll:
+		return true
+	}
+	return false
+}
+
+// EffectiveMetadataPrivacy 返回照片实际使用的元数据处理方式，未单独设置时使用全局配置
+func EffectiveMetadataPrivacy(photo models.Photo) string {
+	if IsValidMetadataPrivacy(photo.MetadataPrivacy) {
+		return photo.MetadataPrivacy
+	}
+	return ImageConfig.MetadataPrivacy
+}
+
+// HidesLocation 照片的拍摄位置是否对未登录用户隐藏
+func HidesLocation(photo models.Photo) bool {
+	return EffectiveMetadataPrivacy(photo) != MetadataKeep
+}
+
+// RedactPhotoLocation 照片不公开拍摄位置时清除坐标，用于返回给未登录用户的数据
+func RedactPhotoLocation(photo *models.Photo) {
+	if HidesLocation(*photo) {
+		photo.Latitude = nil
+		photo.Longitude = nil
+		photo.Altitude = nil
+	}
+}
+
+// RedactLocation 对每张照片调用 RedactPhotoLocation
+func RedactLocation(photos []models.Photo) {
+	for i := range photos {
+		RedactPhotoLocation(&photos[i])
+	}
+}
+
+// PublicLocationCondition 返回筛选出公开拍摄位置的照片的 SQL 条件和参数
+func PublicLocationCondition() (string, []interface{}) {
+	if ImageConfig.MetadataPrivacy == MetadataKeep {
+		return "(metadata_privacy = ? OR metadata_privacy = '' OR metadata_privacy IS NULL)", []interface{}{MetadataKeep}
+	}
+	return "metadata_privacy = ?", []interface{}{MetadataKeep}
+}
+
+// PublicPhotoCondition 返回排除密码保护相册中照片的 SQL 条件和参数
+func PublicPhotoCondition() (string, []interface{}) {
+	return "id NOT IN (SELECT album_photos.photo_id FROM album_photos JOIN albums ON albums.id = album_photos.album_id " +
+		"WHERE albums.is_protected = ? AND albums.deleted_at IS NULL)", []interface{}{true}
+}
+
+// PublishOriginal 按元数据设置生成原图的公开版本，返回需要更新到照片记录的字段
+//
+// 需要处理元数据时，原图保持不变，另存一份处理后的副本作为 file_path；
+// 原样公开时 file_path 指向原图并删除之前的副本，PrivatePrefix 下的原图则原样复制一份副本；
+// HEIF 和 RAW 原图始终另存一份 JPEG 副本。
+// 需要加水印时副本重新编码，除 HEIF 转换的 JPEG 外不包含元数据
+func PublishOriginal(store Storage, photo models.Photo) (map[string]interface{}, error) {
+	return publishOriginal(store, photo, nil, nil)
+}
+
+// publishOriginal 见 PublishOriginal，data 和 img 为已读取、已解码的原图，
+// 为 nil 时在需要时才读取和解码
+func publishOriginal(store Storage, photo models.Photo, data []byte, img image.Image) (map[string]interface{}, error) {
+	key := ResolveStorageKey(photo.FileKey, photo.FilePath)
+	if key == "" {
+		return nil, fmt.Errorf("photo %d has no storage key", photo.ID)
+	}
+
+	updates := map[string]interface{}{"file_key": key}
+	mode := EffectiveMetadataPrivacy(photo)
+	watermark := WatermarkEnabled(photo)
+
+	// 私有目录下的原图不能直接公开，keep 时原样复制一份
+	if mode == MetadataKeep && !watermark && !needsDisplayCopy(filepath.Ext(key)) && !IsPrivateKey(key) {
+		if photo.PublicKey != "" {
+			if err := store.Delete(photo.PublicKey); err != nil {
+				return nil, fmt.Errorf("failed to delete public copy: %w", err)
+			}
+		}
+		updates["file_path"] = store.URL(key)
+		updates["public_key"] = ""
+		return updates, nil
+	}
+
+	var err error
+	if data == nil {
+		if data, err = ReadObject(store, key); err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+	}
+	ext := filepath.Ext(key)
+	// 只在需要重新编码时解码
+	decode := func() (err error) {
+		if img == nil {
+			if img, err = decodeImage(bytes.NewReader(data), ext); err != nil {
+				return fmt.Errorf("failed to decode image: %w", err)
+			}
+		}
+		return nil
+	}
+
+	var scrubbed []byte
+	if needsDisplayCopy(ext) {
+		if err = decode(); err != nil {
+			return nil, err
+		}
+		if scrubbed, err = displayCopy(data, img, ext, mode, watermark); err != nil {
+			return nil, err
+		}
+		ext = ".jpg"
+	} else if watermark {
+		if err = decode(); err != nil {
+			return nil, err
+		}
+		if scrubbed, ext, err = reencodeWithoutMetadata(img, ext, true); err != nil {
+			return nil, err
+		}
+	} else if scrubbed, err = ScrubMetadata(data, ext, mode); err != nil {
+		// 无法无损处理时重新编码，编码结果不包含任何元数据
+		if err = decode(); err != nil {
+			return nil, err
+		}
+		if scrubbed, ext, err = reencodeWithoutMetadata(img, ext, false); err != nil {
+			return nil, err
+		}
+	}
+
+	publicKey := derivedKey(key, publicCopySuffix+ext)
+	if err := store.Put(publicKey, bytes.NewReader(scrubbed), int64(len(scrubbed)), detectMimeType(scrubbed, ext)); err != nil {
+		return nil, fmt.Errorf("failed to save public copy: %w", err)
+	}
+	if photo.PublicKey != "" && photo.PublicKey != publicKey {
+		store.Delete(photo.PublicKey)
+	}
+
+	updates["file_path"] = store.URL(publicKey)
+	updates["public_key"] = publicKey
+	return updates, nil
+}
+
+// needsDisplayCopy 浏览器无法直接显示的原图格式（HEIF、RAW），公开时始终转换为 JPEG
+func needsDisplayCopy(ext string) bool {
+	return IsHEIF(ext) || IsRAW(ext)
+}
+
+// displayCopy 将已解码的原图 img 转换为可在浏览器中显示的 JPEG，data 为原图文件内容
+//
+// HEIF 在 keep 和 strip_gps 时带上（经过处理的）EXIF，方向重置为 1，因为像素已经按方向校正；
+// RAW 的 EXIF 与传感器数据保存在同一个 TIFF 结构中，副本不带元数据。watermark 为 true 时按配置加水印
+func displayCopy(data []byte, img image.Image, ext, mode string, watermark bool) ([]byte, error) {
+	if watermark {
+		img = ApplyWatermark(img)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, FormatJPEG, ImageConfig.JPEGQuality); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	out := buf.Bytes()
+
+	var tiff []byte
+	if IsHEIF(ext) {
+		tiff = FindHEIFExif(data)
+	}
+	if mode == MetadataStripAll || tiff == nil {
+		return out, nil
+	}
+
+	tiff = append([]byte{}, tiff...)
+	if mode == MetadataStripGPS {
+		if err := redactTIFF(tiff, mode); err != nil {
+			return out, nil // EXIF 损坏时不带元数据
+		}
+	}
+	setTIFFOrientation(tiff, 1)
+
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	if len(payload)+2 > 0xFFFF {
+		return out, nil // 超出单个 APP1 段的长度限制
+	}
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	result := make([]byte, 0, len(out)+len(segment))
+	result = append(result, out[:2]...)
+	result = append(result, segment...)
+	return append(result, out[2:]...), nil
+}
+
+// reencodeWithoutMetadata 将已解码（已按 EXIF 方向旋转）的原图重新编码为 JPEG（PNG 保持 PNG），watermark 为 true 时按配置加水印
+func reencodeWithoutMetadata(img image.Image, ext string, watermark bool) ([]byte, string, error) {
+	if watermark {
+		img = ApplyWatermark(img)
+	}
+
+	var buf bytes.Buffer
+	if strings.EqualFold(ext, ".png") {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", fmt.Errorf("failed to encode image: %w", err)
+		}
+		return buf.Bytes(), ".png", nil
+	}
+	if err := encodeImage(&buf, img, FormatJPEG, ImageConfig.JPEGQuality); err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), ".jpg", nil
+}
+
+// ScrubUpload 按全局的元数据处理方式处理直接公开的上传文件，返回处理后的内容和扩展名
+//
+// 无法直接改写元数据的格式重新编码，HEIF 和 RAW 始终转换为 JPEG
+func ScrubUpload(data []byte, ext string) ([]byte, string, error) {
+	mode := ImageConfig.MetadataPrivacy
+	if !needsDisplayCopy(ext) {
+		if scrubbed, err := ScrubMetadata(data, ext, mode); err == nil {
+			return scrubbed, ext, nil
+		}
+	}
+
+	img, err := decodeImage(bytes.NewReader(data), ext)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if needsDisplayCopy(ext) {
+		scrubbed, err := displayCopy(data, img, ext, mode, false)
+		return scrubbed, ".jpg", err
+	}
+	return reencodeWithoutMetadata(img, ext, false)
+}
+
+// ScrubMetadata按指定方式抹去图片中的元数据，不重新编码像素数据
+//
+// EXIF 在原位置改写（删除的条目及其数据全部清零），XMP 等元数据块直接删除。
+// 支持 JPEG、PNG 和 WebP，其他格式返回 ErrMetadataUnsupported
+func ScrubMetadata(data []byte, ext, mode string) ([]byte, error) {
+	if mode == MetadataKeep {
+		return data, nil
+	}
+	if !IsValidMetadataPrivacy(mode) {
+		return nil, fmt.Errorf("unknown metadata mode: %s", mode)
+	}
+
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return scrubJPEG(data, mode)
+	case ".png":
+		return scrubPNG(data, mode)
+	case ".webp":
+		return scrubWebP(data, mode)
+	default:
+		return nil, ErrMetadataUnsupported
+	}
+}
+
+var (
+	pngSignature     = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader       = []byte("Exif\x00\x00")
+	xmpNamespace     = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtNamespace  = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	errMalformedJPEG = errors.New("malformed jpeg")
+	errMalformedPNG  = errors.New("malformed png")
+	errMalformedWebP = errors.New("malformed webp")
+)
+
+// scrubJPEG 改写 APP1 中的 EXIF，删除 XMP；strip_all 时还会删除 APP13（IPTC）和注释
+func scrubJPEG(data []byte, mode string) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformedJPEG
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	pos := 2
+
+	for pos+2 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errMalformedJPEG
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF: // 填充字节
+			pos++
+			continue
+		case marker == 0xDA || marker == 0xD9: // 图像数据开始，之后不再有元数据段
+			return append(out, data[pos:]...), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // 没有长度的标记
+			out = append(out, data[pos:pos+2]...)
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return nil, errMalformedJPEG
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errMalformedJPEG
+		}
+		segment := data[pos:end]
+		payload := segment[4:]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+			segment = append([]byte{}, segment...)
+			if err := redactTIFF(segment[4+len(exifHeader):], mode); err != nil {
+				return nil, err
+			}
+		case marker == 0xE1 && (bytes.HasPrefix(payload, xmpNamespace) || bytes.HasPrefix(payload, xmpExtNamespace)):
+			segment = nil
+		case (marker == 0xED || marker == 0xFE) && mode == MetadataStripAll:
+			segment = nil
+		}
+
+		out = append(out, segment...)
+		pos = end
+	}
+
+	return nil, errMalformedJPEG
+}
+
+// scrubPNG 改写 eXIf 块并重新计算 CRC，删除 XMP；strip_all 时删除所有文本块和时间块
+func scrubPNG(data []byte, mode string) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformedPNG
+	}
+
+	out := append(make([]byte, 0, len(data)), pngSignature...)
+	pos := len(pngSignature)
+
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformedPNG
+		}
+		chunk := data[pos:end]
+		chunkType := string(chunk[4:8])
+		body := chunk[8 : 8+length]
+
+		switch {
+		case chunkType == "eXIf":
+			chunk = append([]byte{}, chunk...)
+			tiff := chunk[8 : 8+length]
+			tiff = bytes.TrimPrefix(tiff, exifHeader)
+			if err := redactTIFF(tiff, mode); err != nil {
+				return nil, err
+			}
+			binary.BigEndian.PutUint32(chunk[8+length:], crc32.ChecksumIEEE(chunk[4:8+length]))
+		case chunkType == "iTXt" && bytes.HasPrefix(body, []byte("XML:com.adobe.xmp\x00")):
+			chunk = nil
+		case mode == MetadataStripAll && (chunkType == "tEXt" || chunkType == "zTXt" || chunkType == "iTXt" || chunkType == "tIME"):
+			chunk = nil
+		}
+
+		out = append(out, chunk...)
+		pos = end
+		if chunkType == "IEND" {
+			return out, nil
+		}
+	}
+
+	return nil, errMalformedPNG
+}
+
+// scrubWebP 改写 EXIF 块，删除 XMP 块并更新 RIFF 长度和 VP8X 标志位
+func scrubWebP(data []byte, mode string) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformedWebP
+	}
+
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	vp8x := -1
+	pos := 12
+
+	for pos+8 <= len(data) {
+		fourcc := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2 // 块按偶数字节对齐
+		if size < 0 || end > len(data) {
+			return nil, errMalformedWebP
+		}
+		chunk := data[pos:end]
+
+		switch fourcc {
+		case "VP8X":
+			vp8x = len(out)
+		case "EXIF":
+			chunk = append([]byte{}, chunk...)
+			tiff := bytes.TrimPrefix(chunk[8:8+size], exifHeader)
+			if err := redactTIFF(tiff, mode); err != nil {
+				return nil, err
+			}
+		case "XMP ":
+			chunk = nil
+		}
+
+		out = append(out, chunk...)
+		pos = end
+	}
+	if pos != len(data) {
+		return nil, errMalformedWebP
+	}
+
+	// 清除 VP8X 中的 XMP 标志位
+	if vp8x >= 0 && vp8x+9 <= len(out) {
+		out[vp8x+8] &^= 0x04
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// TIFF 标签
+const (
+	tagCompression        = 0x0103
+	tagStripOffsets       = 0x0111
+	tagOrientation        = 0x0112
+	tagStripByteCounts    = 0x0117
+	tagSubIFDs            = 0x014A
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagInteropIFD         = 0xA005
+	tagJPEGInterchange    = 0x0201
+	tagJPEGInterchangeLen = 0x0202
+)
+
+// sensitiveTags strip_gps 时删除的可识别设备或个人的标签
+var sensitiveTags = map[uint16]bool{
+	0x927C: true, // MakerNote，常包含机身序列号
+	0xA420: true, // ImageUniqueID
+	0xA430: true, // CameraOwnerName
+	0xA431: true, // BodySerialNumber
+	0xA435: true, // LensSerialNumber
+	0xC62F: true, // CameraSerialNumber (DNG)
+}
+
+// tiffTypeSizes TIFF 数据类型对应的字节数
+var tiffTypeSizes = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+var errMalformedTIFF = errors.New("malformed exif")
+
+// tiffRedactor 在原位置改写 TIFF 结构的 EXIF 数据，长度保持不变
+type tiffRedactor struct {
+	buf   []byte
+	order binary.ByteOrder
+	mode  string
+	seen  map[uint32]bool
+}
+
+// redactTIFF 按 mode 删除 EXIF 条目：删除的条目从 IFD 中移除，对应数据清零
+func redactTIFF(buf []byte, mode string) error {
+	if len(buf) < 8 {
+		return errMalformedTIFF
+	}
+
+	r := &tiffRedactor{buf: buf, mode: mode, seen: make(map[uint32]bool)}
+	switch string(buf[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return errMalformedTIFF
+	}
+
+	offset := r.order.Uint32(buf[4:])
+	for ifd := 0; offset != 0; ifd++ {
+		next, err := r.redactIFD(offset, ifd == 0, false)
+		if err != nil {
+			return err
+		}
+		offset = next
+	}
+	return nil
+}
+
+// keep 判断 IFD0/IFD1 或子 IFD 中的条目是否保留
+func (r *tiffRedactor) keep(tag uint16, ifd0 bool) bool {
+	if r.mode == MetadataStripAll {
+		return ifd0 && tag == tagOrientation
+	}
+	return tag != tagGPSIFD && !sensitiveTags[tag]
+}
+
+// redactIFD 处理一个 IFD，dropAll 为 true 时删除其中所有条目，返回下一个 IFD 的偏移
+func (r *tiffRedactor) redactIFD(offset uint32, ifd0, dropAll bool) (uint32, error) {
+	if r.seen[offset] {
+		return 0, errMalformedTIFF
+	}
+	r.seen[offset] = true
+
+	start := uint64(offset)
+	if start+2 > uint64(len(r.buf)) {
+		return 0, errMalformedTIFF
+	}
+	count := uint64(r.order.Uint16(r.buf[start:]))
+	end := start + 2 + count*12
+	if end+4 > uint64(len(r.buf)) {
+		return 0, errMalformedTIFF
+	}
+	next := r.order.Uint32(r.buf[end:])
+
+	// 缩略图的偏移和长度分别在两个条目中，先找出长度
+	var thumbLen uint32
+	for i := uint64(0); i < count; i++ {
+		entry := r.buf[start+2+i*12:]
+		if r.order.Uint16(entry) == tagJPEGInterchangeLen {
+			thumbLen = r.order.Uint32(entry[8:])
+		}
+	}
+
+	var kept [][]byte
+	for i := uint64(0); i < count; i++ {
+		entry := append([]byte{}, r.buf[start+2+i*12:start+2+i*12+12]...)
+		tag := r.order.Uint16(entry)
+		keep := !dropAll && r.keep(tag, ifd0)
+
+		switch tag {
+		case tagExifIFD, tagGPSIFD, tagInteropIFD:
+			// 子 IFD：保留时递归处理其中的条目，删除时清空
+			if _, err := r.redactIFD(r.order.Uint32(entry[8:]), false, !keep); err != nil {
+				return 0, err
+			}
+		case tagJPEGInterchange:
+			if !keep {
+				r.zero(uint64(r.order.Uint32(entry[8:])), uint64(thumbLen))
+			}
+		}
+
+		if keep {
+			kept = append(kept, entry)
+			continue
+		}
+
+		// 清零存放在 IFD 之外的数据
+		typeSize, ok := tiffTypeSizes[r.order.Uint16(entry[2:])]
+		if size := typeSize * uint64(r.order.Uint32(entry[4:])); ok && size > 4 {
+			r.zero(uint64(r.order.Uint32(entry[8:])), size)
+		}
+	}
+
+	// 重写 IFD：条目数、保留的条目、下一个 IFD 偏移，其余位置清零
+	r.zero(start, end+4-start)
+	r.order.PutUint16(r.buf[start:], uint16(len(kept)))
+	for i, entry := range kept {
+		copy(r.buf[start+2+uint64(i)*12:], entry)
+	}
+	r.order.PutUint32(r.buf[start+2+uint64(len(kept))*12:], next)
+
+	return next, nil
+}
+
+// zero 将指定范围清零，越界部分忽略
+func (r *tiffRedactor) zero(offset, size uint64) {
+	if offset >= uint64(len(r.buf)) {
+		return
+	}
+	end := min(offset+size, uint64(len(r.buf)))
+	clear(r.buf[offset:end])
+}
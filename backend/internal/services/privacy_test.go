@@ -0,0 +1,329 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"picsite/internal/models"
+
+	"github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// privateEXIF 构造包含 GPS、序列号、相机型号和方向的 EXIF
+func privateEXIF(t *testing.T) func(root *exif.IfdBuilder) {
+	return func(root *exif.IfdBuilder) {
+		setEXIFTag(t, root, "IFD", "Model", "Test Camera")
+		setEXIFTag(t, root, "IFD", "Orientation", []uint16{6})
+		setEXIFTag(t, root, "IFD/Exif", "BodySerialNumber", "SN-SECRET-1234")
+		setEXIFTag(t, root, "IFD/GPSInfo", "GPSLatitudeRef", "N")
+		setEXIFTag(t, root, "IFD/GPSInfo", "GPSLatitude", []exifcommon.Rational{{Numerator: 39, Denominator: 1}, {Numerator: 54, Denominator: 1}, {Numerator: 0, Denominator: 1}})
+		setEXIFTag(t, root, "IFD/GPSInfo", "GPSLongitudeRef", "E")
+		setEXIFTag(t, root, "IFD/GPSInfo", "GPSLongitude", []exifcommon.Rational{{Numerator: 116, Denominator: 1}, {Numerator: 23, Denominator: 1}, {Numerator: 0, Denominator: 1}})
+	}
+}
+
+// exifTagNames 返回图片 EXIF 中所有标签的名称
+func exifTagNames(t *testing.T, data []byte) map[string]bool {
+	t.Helper()
+
+	names := make(map[string]bool)
+	raw, err := exif.SearchAndExtractExif(data)
+	if errors.Is(err, exif.ErrNoExif) {
+		return names
+	}
+	if err != nil {
+		t.Fatalf("Failed to find EXIF: %v", err)
+	}
+	tags, _, err := exif.GetFlatExifData(raw, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse EXIF: %v", err)
+	}
+	for _, tag := range tags {
+		names[tag.TagName] = true
+	}
+	return names
+}
+
+// withXMP 在 JPEG 的 SOI 之后插入 XMP 段
+func withXMP(data []byte, packet string) []byte {
+	payload := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), packet...)
+	length := len(payload) + 2
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// pngChunk 构造一个 PNG 块
+func pngChunk(chunkType string, body []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, body...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// riffChunk 构造一个 WebP 块
+func riffChunk(fourcc string, body []byte) []byte {
+	chunk := append([]byte(fourcc), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestScrubMetadataJPEG(t *testing.T) {
+	data := withXMP(withEXIF(t, testJPEG(t, 16, 16), privateEXIF(t)), "<x:xmpmeta>secret place</x:xmpmeta>")
+
+	t.Run("strip_gps removes location and serial numbers", func(t *testing.T) {
+		scrubbed, err := ScrubMetadata(data, ".jpg", MetadataStripGPS)
+		if err != nil {
+			t.Fatalf("ScrubMetadata failed: %v", err)
+		}
+
+		names := exifTagNames(t, scrubbed)
+		for _, name := range []string{"GPSLatitude", "GPSLongitude", "BodySerialNumber"} {
+			if names[name] {
+				t.Errorf("Expected %s to be removed", name)
+			}
+		}
+		for _, name := range []string{"Model", "Orientation"} {
+			if !names[name] {
+				t.Errorf("Expected %s to be kept", name)
+			}
+		}
+		if bytes.Contains(scrubbed, []byte("SN-SECRET-1234")) {
+			t.Error("Expected serial number bytes to be zeroed")
+		}
+		if bytes.Contains(scrubbed, []byte("secret place")) {
+			t.Error("Expected XMP to be removed")
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(scrubbed)); err != nil {
+			t.Errorf("Scrubbed JPEG does not decode: %v", err)
+		}
+	})
+
+	t.Run("strip_all keeps only orientation", func(t *testing.T) {
+		scrubbed, err := ScrubMetadata(data, ".jpeg", MetadataStripAll)
+		if err != nil {
+			t.Fatalf("ScrubMetadata failed: %v", err)
+		}
+
+		names := exifTagNames(t, scrubbed)
+		if len(names) != 1 || !names["Orientation"] {
+			t.Errorf("Expected only Orientation, got %v", names)
+		}
+		if bytes.Contains(scrubbed, []byte("Test Camera")) {
+			t.Error("Expected camera model bytes to be zeroed")
+		}
+	})
+
+	t.Run("keep returns data unchanged", func(t *testing.T) {
+		scrubbed, err := ScrubMetadata(data, ".jpg", MetadataKeep)
+		if err != nil || !bytes.Equal(scrubbed, data) {
+			t.Errorf("Expected data to be unchanged, err=%v", err)
+		}
+	})
+
+	t.Run("malformed JPEG", func(t *testing.T) {
+		if _, err := ScrubMetadata(data[:40], ".jpg", MetadataStripGPS); err == nil {
+			t.Error("Expected error for truncated JPEG")
+		}
+	})
+}
+
+func TestScrubMetadataPNG(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// 在 IHDR 之后插入 eXIf、XMP 和文本块
+	ihdrEnd := 8 + 8 + 13 + 4
+	var data []byte
+	data = append(data, encoded[:ihdrEnd]...)
+	data = append(data, pngChunk("eXIf", buildEXIF(t, privateEXIF(t)))...)
+	data = append(data, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))...)
+	data = append(data, pngChunk("tEXt", []byte("Comment\x00hello"))...)
+	data = append(data, encoded[ihdrEnd:]...)
+
+	scrubbed, err := ScrubMetadata(data, ".png", MetadataStripGPS)
+	if err != nil {
+		t.Fatalf("ScrubMetadata failed: %v", err)
+	}
+	names := exifTagNames(t, scrubbed)
+	if names["GPSLatitude"] || names["BodySerialNumber"] || !names["Model"] {
+		t.Errorf("Unexpected EXIF tags after strip_gps: %v", names)
+	}
+	if bytes.Contains(scrubbed, []byte("XML:com.adobe.xmp")) {
+		t.Error("Expected XMP chunk to be removed")
+	}
+	if !bytes.Contains(scrubbed, []byte("Comment")) {
+		t.Error("Expected text chunk to be kept with strip_gps")
+	}
+	// png.Decode 会校验每个块的 CRC
+	if _, err := png.Decode(bytes.NewReader(scrubbed)); err != nil {
+		t.Errorf("Scrubbed PNG does not decode: %v", err)
+	}
+
+	scrubbed, err = ScrubMetadata(data, ".png", MetadataStripAll)
+	if err != nil {
+		t.Fatalf("ScrubMetadata failed: %v", err)
+	}
+	if bytes.Contains(scrubbed, []byte("Comment")) {
+		t.Error("Expected text chunk to be removed with strip_all")
+	}
+}
+
+func TestScrubMetadataWebP(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | 0x04 // EXIF 和 XMP 标志位
+
+	var body []byte
+	body = append(body, "WEBP"...)
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, riffChunk("VP8L", []byte{0x2f, 0, 0, 0, 0})...)
+	body = append(body, riffChunk("EXIF", buildEXIF(t, privateEXIF(t)))...)
+	body = append(body, riffChunk("XMP ", []byte("<x:xmpmeta/>"))...)
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	data = append(data, body...)
+
+	scrubbed, err := ScrubMetadata(data, ".webp", MetadataStripGPS)
+	if err != nil {
+		t.Fatalf("ScrubMetadata failed: %v", err)
+	}
+
+	names := exifTagNames(t, scrubbed)
+	if names["GPSLatitude"] || !names["Model"] {
+		t.Errorf("Unexpected EXIF tags after strip_gps: %v", names)
+	}
+	if bytes.Contains(scrubbed, []byte("XMP ")) {
+		t.Error("Expected XMP chunk to be removed")
+	}
+	if size := binary.LittleEndian.Uint32(scrubbed[4:]); int(size) != len(scrubbed)-8 {
+		t.Errorf("Expected RIFF size %d, got %d", len(scrubbed)-8, size)
+	}
+	if flags := scrubbed[20]; flags&0x04 != 0 || flags&0x08 == 0 {
+		t.Errorf("Expected XMP flag cleared and EXIF flag kept, got %#x", flags)
+	}
+}
+
+func TestScrubMetadataUnsupported(t *testing.T) {
+	if _, err := ScrubMetadata([]byte("GIF89a"), ".gif", MetadataStripGPS); !errors.Is(err, ErrMetadataUnsupported) {
+		t.Errorf("Expected ErrMetadataUnsupported, got %v", err)
+	}
+	if _, err := ScrubMetadata(nil, ".jpg", "unknown"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+func TestScrubUpload(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	data := withEXIF(t, testJPEG(t, 16, 16), privateEXIF(t))
+
+	t.Run("uses global mode", func(t *testing.T) {
+		ImageConfig.MetadataPrivacy = MetadataStripGPS
+		scrubbed, ext, err := ScrubUpload(data, ".jpg")
+		if err != nil {
+			t.Fatalf("ScrubUpload failed: %v", err)
+		}
+		if ext != ".jpg" || exifTagNames(t, scrubbed)["GPSLatitude"] {
+			t.Errorf("Expected GPS to be removed without re-encoding, ext=%s", ext)
+		}
+	})
+
+	t.Run("keep returns data unchanged", func(t *testing.T) {
+		ImageConfig.MetadataPrivacy = MetadataKeep
+		scrubbed, _, err := ScrubUpload(data, ".jpg")
+		if err != nil || !bytes.Equal(scrubbed, data) {
+			t.Errorf("Expected data to be unchanged, err=%v", err)
+		}
+	})
+
+	t.Run("malformed JPEG", func(t *testing.T) {
+		ImageConfig.MetadataPrivacy = MetadataStripGPS
+		if _, _, err := ScrubUpload(data[:40], ".jpg"); err == nil {
+			t.Error("Expected error for truncated JPEG")
+		}
+	})
+}
+
+func TestPublishOriginal(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.MetadataPrivacy = MetadataStripGPS
+
+	store := NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+	data := withEXIF(t, testJPEG(t, 16, 16), privateEXIF(t))
+	if err := store.Put("photo.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("Failed to store image: %v", err)
+	}
+
+	photo := models.Photo{FileKey: "photo.jpg"}
+	updates, err := PublishOriginal(store, photo)
+	if err != nil {
+		t.Fatalf("PublishOriginal failed: %v", err)
+	}
+	if updates["public_key"] != "photo_public.jpg" || updates["file_path"] != "/uploads/photo_public.jpg" {
+		t.Fatalf("Unexpected updates: %v", updates)
+	}
+
+	public, err := ReadObject(store, "photo_public.jpg")
+	if err != nil {
+		t.Fatalf("Expected public copy: %v", err)
+	}
+	if ParseEXIF(public).Latitude != nil {
+		t.Error("Expected public copy without GPS")
+	}
+	master, _ := ReadObject(store, "photo.jpg")
+	if !bytes.Equal(master, data) {
+		t.Error("Expected master to be unchanged")
+	}
+
+	// 切换为原样公开后删除副本
+	photo.PublicKey = "photo_public.jpg"
+	photo.MetadataPrivacy = MetadataKeep
+	updates, err = PublishOriginal(store, photo)
+	if err != nil {
+		t.Fatalf("PublishOriginal failed: %v", err)
+	}
+	if updates["public_key"] != "" || updates["file_path"] != "/uploads/photo.jpg" {
+		t.Errorf("Unexpected updates: %v", updates)
+	}
+	if _, err := store.Stat("photo_public.jpg"); err == nil {
+		t.Error("Expected public copy to be deleted")
+	}
+}
+
+func TestRedactLocation(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.MetadataPrivacy = MetadataStripGPS
+
+	lat, lng := 39.9, 116.4
+	photos := []models.Photo{
+		{Latitude: &lat, Longitude: &lng},
+		{Latitude: &lat, Longitude: &lng, MetadataPrivacy: MetadataKeep},
+	}
+	RedactLocation(photos)
+
+	if photos[0].Latitude != nil || photos[0].Longitude != nil {
+		t.Error("Expected location to be redacted with global strip_gps")
+	}
+	if photos[1].Latitude == nil {
+		t.Error("Expected location to be kept for photo with keep")
+	}
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"picsite/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ProcessOptions 照片处理选项
+type ProcessOptions struct {
+	// OverwriteEXIF 使用 EXIF、IPTC 和 XMP 中的信息覆盖已有的值，默认只填充为空的字段
+	OverwriteEXIF bool
+}
+
+// ProcessPhoto 完成照片上传后的处理：读取图片信息和元数据、生成缩略图、占位预览，
+// eager 模式下还会生成各尺寸版本。可以重复执行，已有的生成文件会被覆盖。
+//
+// 默认 EXIF、IPTC 和 XMP 中的信息只填充照片中为空的字段，不会覆盖上传时填写的内容
+func ProcessPhoto(photoID uint, opts ProcessOptions) error {
+	var photo models.Photo
+	if err := DB.First(&photo, photoID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // 照片已被删除
+		}
+		return err
+	}
+
+	key := ResolveStorageKey(photo.FileKey, photo.FilePath)
+	if key == "" {
+		return fmt.Errorf("photo %d has no storage key", photo.ID)
+	}
+
+	if err := DB.Model(&photo).Update("processing_status", models.PhotoStatusProcessing).Error; err != nil {
+		return err
+	}
+
+	store := GetStorage()
+	data, err := ReadObject(store, key)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	ext := filepath.Ext(key)
+	info, err := InspectImage(data, ext)
+	if err != nil {
+		return err
+	}
+
+	// 原图只解码一次，缩略图、占位预览、尺寸版本和公开副本共用
+	img, err := decodeImage(bytes.NewReader(data), ext)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	// 视觉特征使用不带水印的缩略图计算
+	watermark := WatermarkEnabled(photo)
+	thumbnailKey, thumbnail, err := saveThumbnail(store, key, img, watermark)
+	if err != nil {
+		return err
+	}
+
+	placeholder, err := GeneratePlaceholder(img)
+	if err != nil {
+		return err
+	}
+
+	features := ComputeVisualFeatures(thumbnail)
+
+	var renditions []models.PhotoRendition
+	if ImageConfig.RenditionMode == RenditionModeEager {
+		if renditions, err = generateRenditions(store, key, img, watermark); err != nil {
+			return err
+		}
+	}
+
+	// 按元数据设置生成公开的原图，原图本身保持不变
+	updates, err := publishOriginal(store, photo, data, img)
+	if err != nil {
+		return err
+	}
+
+	colorInfo := InspectColor(data, ext)
+
+	for column, value := range map[string]interface{}{
+		"thumbnail_path":    store.URL(thumbnailKey),
+		"thumbnail_key":     thumbnailKey,
+		"width":             info.Width,
+		"height":            info.Height,
+		"aspect_ratio":      info.AspectRatio,
+		"file_size":         info.Size,
+		"mime_type":         info.MimeType,
+		"color_space":       colorInfo.ColorSpace,
+		"color_converted":   colorInfo.Converted,
+		"blur_hash":         placeholder.BlurHash,
+		"lqip":              placeholder.LQIP,
+		"dominant_color":    placeholder.DominantColor,
+		"perceptual_hash":   features.PerceptualHash,
+		"color_histogram":   features.ColorHistogram,
+		"processing_status": models.PhotoStatusReady,
+		"processing_error":  "",
+		"rendition_version": gorm.Expr("rendition_version + 1"),
+	} {
+		updates[column] = value
+	}
+	// 早期上传的照片没有记录内容哈希，重新处理时补上；与其他照片重复时不记录，避免违反唯一索引
+	if photo.ContentHash == "" {
+		hash := ContentHash(data)
+		var count int64
+		if err := DB.Model(&models.Photo{}).Where("content_hash = ? AND id <> ?", hash, photo.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			updates["content_hash"] = hash
+		} else {
+			fmt.Printf("Photo %d has the same content as another photo, content hash not recorded\n", photo.ID)
+		}
+	}
+	exifTarget := &photo
+	if opts.OverwriteEXIF {
+		exifTarget = &models.Photo{}
+	}
+	rawMetadata := ExtractRawMetadata(data)
+	if photo.SidecarKey != "" {
+		// .xmp 附属文件中的信息优先于图片中嵌入的 XMP
+		sidecar, err := ReadObject(store, photo.SidecarKey)
+		if err != nil {
+			return fmt.Errorf("failed to read xmp sidecar: %w", err)
+		}
+		rawMetadata.SetXMP(sidecar)
+	}
+	fillFromEXIF(updates, exifTarget, ParseEXIF(data))
+	// XMP 与 IPTC 同时存在时以 XMP 为准
+	fillFromIPTC(updates, exifTarget, IPTCFromProperties(rawMetadata.IPTC))
+	fillFromXMP(updates, exifTarget, XMPFromProperties(rawMetadata.XMP))
+
+	var oldRenditions []models.PhotoRendition
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if ImageConfig.RenditionMode == RenditionModeEager {
+			// 重新处理时替换之前的尺寸版本
+			var err error
+			if oldRenditions, err = replaceRenditions(tx, photo.ID, renditions); err != nil {
+				return err
+			}
+		}
+		// 重新处理时替换之前的调色板
+		if err := tx.Where("photo_id = ?", photo.ID).Delete(&models.PhotoColor{}).Error; err != nil {
+			return err
+		}
+		for i := range features.Palette {
+			features.Palette[i].PhotoID = photo.ID
+		}
+		if len(features.Palette) > 0 {
+			if err := tx.Create(&features.Palette).Error; err != nil {
+				return err
+			}
+		}
+		if err := SavePhotoMetadata(tx, photo.ID, rawMetadata); err != nil {
+			return err
+		}
+		return tx.Model(&photo).Updates(updates).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	deleteStaleRenditions(store, oldRenditions, renditions)
+	return nil
+}
+
+// replaceRenditions 用新生成的尺寸版本替换照片之前的记录，返回之前的记录
+func replaceRenditions(tx *gorm.DB, photoID uint, renditions []models.PhotoRendition) ([]models.PhotoRendition, error) {
+	var oldRenditions []models.PhotoRendition
+	if err := tx.Where("photo_id = ?", photoID).Find(&oldRenditions).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("photo_id = ?", photoID).Delete(&models.PhotoRendition{}).Error; err != nil {
+		return nil, err
+	}
+	for i := range renditions {
+		renditions[i].PhotoID = photoID
+	}
+	if len(renditions) > 0 {
+		if err := tx.Create(&renditions).Error; err != nil {
+			return nil, err
+		}
+	}
+	return oldRenditions, nil
+}
+
+// deleteStaleRenditions 删除配置变更后不再使用的尺寸版本文件
+func deleteStaleRenditions(store Storage, oldRenditions, renditions []models.PhotoRendition) {
+	current := make(map[string]bool, len(renditions))
+	for _, r := range renditions {
+		current[r.Key] = true
+	}
+	for _, r := range oldRenditions {
+		if !current[r.Key] {
+			if err := store.Delete(r.Key); err != nil {
+				fmt.Printf("Failed to delete stale rendition: %v\n", err)
+			}
+		}
+	}
+}
+
+// fillFromEXIF 将 EXIF 中的拍摄参数加入 updates，只填充照片中为空的字段
+func fillFromEXIF(updates map[string]interface{}, photo *models.Photo, exifData *EXIFData) {
+	if photo.CameraMake == "" && exifData.CameraMake != "" {
+		updates["camera_make"] = exifData.CameraMake
+	}
+	if photo.CameraModel == "" && exifData.CameraModel != "" {
+		updates["camera_model"] = exifData.CameraModel
+	}
+	if photo.LensMake == "" && exifData.LensMake != "" {
+		updates["lens_make"] = exifData.LensMake
+	}
+	if photo.Lens == "" && exifData.Lens != "" {
+		updates["lens"] = exifData.Lens
+	}
+	if photo.FocalLength == 0 && exifData.FocalLength != 0 {
+		updates["focal_length"] = exifData.FocalLength
+	}
+	if photo.FocalLength35mm == 0 && exifData.FocalLength35mm != 0 {
+		updates["focal_length_35mm"] = exifData.FocalLength35mm
+	}
+	if photo.Aperture == "" && exifData.Aperture != "" {
+		updates["aperture"] = exifData.Aperture
+	}
+	if photo.ShutterSpeed == "" && exifData.ShutterSpeed != "" {
+		updates["shutter_speed"] = exifData.ShutterSpeed
+	}
+	if photo.ISO == 0 && exifData.ISO != 0 {
+		updates["iso"] = exifData.ISO
+	}
+	if photo.ExposureBias == nil && exifData.ExposureBias != nil {
+		updates["exposure_bias"] = *exifData.ExposureBias
+	}
+	if photo.ExposureProgram == "" && exifData.ExposureProgram != "" {
+		updates["exposure_program"] = exifData.ExposureProgram
+	}
+	if photo.MeteringMode == "" && exifData.MeteringMode != "" {
+		updates["metering_mode"] = exifData.MeteringMode
+	}
+	if photo.WhiteBalance == "" && exifData.WhiteBalance != "" {
+		updates["white_balance"] = exifData.WhiteBalance
+	}
+	if photo.Flash == "" && exifData.Flash != "" {
+		updates["flash"] = exifData.Flash
+	}
+	if photo.Latitude == nil && photo.Longitude == nil && exifData.Latitude != nil {
+		updates["latitude"] = *exifData.Latitude
+		updates["longitude"] = *exifData.Longitude
+	}
+	if photo.Altitude == nil && exifData.Altitude != nil {
+		updates["altitude"] = *exifData.Altitude
+	}
+	if photo.ShotDate == nil && exifData.ShotDate != nil {
+		updates["shot_date"] = exifData.ShotDate
+		updates["shot_date_offset"] = exifData.ShotDateOffset
+		if photo.Year == 0 {
+			updates["year"] = exifData.ShotDate.Year()
+		}
+	}
+}
+
+// fillFromIPTC 将 IPTC 中的说明、地点、关键词、作者和版权加入 updates，只填充照片中为空的字段
+func fillFromIPTC(updates map[string]interface{}, photo *models.Photo, iptcData *IPTCData) {
+	if photo.Description == "" && iptcData.Caption != "" {
+		updates["description"] = iptcData.Caption
+	}
+	if location := iptcData.Location(); photo.Location == "" && location != "" {
+		updates["location"] = location
+	}
+	if photo.Tags == "" && len(iptcData.Keywords) > 0 {
+		updates["tags"] = strings.Join(iptcData.Keywords, ",")
+	}
+	if photo.Author == "" && len(iptcData.Byline) > 0 {
+		updates["author"] = strings.Join(iptcData.Byline, ", ")
+	}
+	if photo.Copyright == "" && iptcData.Copyright != "" {
+		updates["copyright"] = iptcData.Copyright
+	}
+}
+
+// fillFromXMP 将 XMP 中的标题、描述、关键词和评分加入 updates，只填充照片中为空的字段
+func fillFromXMP(updates map[string]interface{}, photo *models.Photo, xmpData *XMPData) {
+	if photo.Title == "" && xmpData.Title != "" {
+		updates["title"] = xmpData.Title
+	}
+	if photo.Description == "" && xmpData.Description != "" {
+		updates["description"] = xmpData.Description
+	}
+	if photo.Tags == "" && len(xmpData.Keywords) > 0 {
+		updates["tags"] = strings.Join(xmpData.Keywords, ",")
+	}
+	if photo.Rating == 0 && xmpData.Rating != 0 {
+		updates["rating"] = xmpData.Rating
+	}
+	if photo.Label == "" && xmpData.Label != "" {
+		updates["label"] = xmpData.Label
+	}
+}
+
+// MarkPhotoFailed 将照片标记为处理失败并记录原因
+//
+// 没有公开副本时 file_path 指向原图，一并清空，原图不对外提供
+func MarkPhotoFailed(photoID uint, cause error) error {
+	return DB.Model(&models.Photo{}).Where("id = ?", photoID).Updates(map[string]interface{}{
+		"processing_status": models.PhotoStatusFailed,
+		"processing_error":  cause.Error(),
+		"file_path":         gorm.Expr("CASE WHEN public_key = '' THEN '' ELSE file_path END"),
+	}).Error
+}
+
+// runProcessPhotoJob 执行 JobTypeProcessPhoto 任务
+func runProcessPhotoJob(job *models.Job) error {
+	return ProcessPhoto(job.PhotoID, ProcessOptions{})
+}
+
+// failProcessPhotoJob 重试次数用尽后将照片标记为处理失败
+func failProcessPhotoJob(job *models.Job, err error) {
+	if dbErr := MarkPhotoFailed(job.PhotoID, err); dbErr != nil {
+		fmt.Printf("Failed to mark photo %d as failed: %v\n", job.PhotoID, dbErr)
+	}
+}
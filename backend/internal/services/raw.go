@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime"
+	"strings"
+)
+
+// maxRAWIFDs 遍历 IFD 的数量上限，防止损坏的文件中出现循环引用
+const maxRAWIFDs = 64
+
+// ErrNoRAWPreview RAW 文件中没有可以解码的 JPEG 预览图
+var ErrNoRAWPreview = errors.New("no embedded jpeg preview in raw file")
+
+func init() {
+	mime.AddExtensionType(".dng", "image/x-adobe-dng")
+	mime.AddExtensionType(".cr2", "image/x-canon-cr2")
+	mime.AddExtensionType(".nef", "image/x-nikon-nef")
+	mime.AddExtensionType(".arw", "image/x-sony-arw")
+}
+
+// IsRAW 按扩展名判断是否为支持的 RAW 格式（DNG、CR2、NEF、ARW）
+//
+// 这些格式都基于 TIFF，缩略图和各尺寸版本使用其中内嵌的最大 JPEG 预览图生成，
+// 不解码传感器数据；EXIF 直接从 RAW 文件中读取
+func IsRAW(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".dng", ".cr2", ".nef", ".arw":
+		return true
+	}
+	return false
+}
+
+// ExtractRAWPreview 返回 RAW 文件中像素最多的 JPEG 预览图
+//
+// 会检查所有 IFD、SubIFD 和 EXIF IFD 中以 JPEGInterchangeFormat 或 JPEG 压缩的单条带保存的图片，
+// 无损 JPEG 编码的传感器数据无法解码，会被跳过
+func ExtractRAWPreview(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, ErrNoRAWPreview
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, ErrNoRAWPreview
+	}
+
+	w := &rawWalker{data: data, order: order, seen: make(map[uint32]bool)}
+	for offset := order.Uint32(data[4:]); offset != 0 && w.err == nil; {
+		offset = w.walk(offset)
+	}
+
+	var best []byte
+	bestPixels := 0
+	for _, candidate := range w.candidates {
+		if !bytes.HasPrefix(candidate, []byte{0xFF, 0xD8}) {
+			continue
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(candidate))
+		if err != nil {
+			continue
+		}
+		if pixels := cfg.Width * cfg.Height; pixels > bestPixels {
+			best, bestPixels = candidate, pixels
+		}
+	}
+	if best == nil {
+		return nil, ErrNoRAWPreview
+	}
+	return best, nil
+}
+
+// rawWalker 遍历 TIFF 的 IFD，收集可能是 JPEG 预览图的数据
+type rawWalker struct {
+	data       []byte
+	order      binary.ByteOrder
+	seen       map[uint32]bool
+	candidates [][]byte
+	err        error
+}
+
+// walk 读取一个 IFD 及其子 IFD，返回下一个 IFD 的偏移
+func (w *rawWalker) walk(offset uint32) uint32 {
+	if w.seen[offset] || len(w.seen) >= maxRAWIFDs {
+		return 0
+	}
+	w.seen[offset] = true
+
+	start := int(offset)
+	if start < 8 || start+2 > len(w.data) {
+		w.err = errMalformedTIFF
+		return 0
+	}
+	count := int(w.order.Uint16(w.data[start:]))
+	end := start + 2 + count*12
+	if end+4 > len(w.data) {
+		w.err = errMalformedTIFF
+		return 0
+	}
+
+	var (
+		compression            uint32
+		jpegOffset, jpegLength uint32
+		stripOffsets, strips   []uint32
+		children               []uint32
+	)
+	for i := 0; i < count; i++ {
+		entry := w.data[start+2+i*12:]
+		tag := w.order.Uint16(entry)
+		switch tag {
+		case tagCompression:
+			compression = w.value(entry, 0)
+		case tagJPEGInterchange:
+			jpegOffset = w.value(entry, 0)
+		case tagJPEGInterchangeLen:
+			jpegLength = w.value(entry, 0)
+		case tagStripOffsets:
+			stripOffsets = w.values(entry)
+		case tagStripByteCounts:
+			strips = w.values(entry)
+		case tagSubIFDs, tagExifIFD:
+			children = append(children, w.values(entry)...)
+		}
+	}
+
+	w.addCandidate(jpegOffset, jpegLength)
+	// CR2 的 IFD0 等以旧式（6）或标准（7）JPEG 压缩保存整张预览图
+	if (compression == 6 || compression == 7) && len(stripOffsets) == 1 && len(strips) == 1 {
+		w.addCandidate(stripOffsets[0], strips[0])
+	}
+
+	next := w.order.Uint32(w.data[end:])
+	for _, child := range children {
+		// 子 IFD 同样可能以 next 指针串联
+		for o := child; o != 0 && w.err == nil; {
+			o = w.walk(o)
+		}
+	}
+	return next
+}
+
+func (w *rawWalker) addCandidate(offset, length uint32) {
+	if offset == 0 || length == 0 || uint64(offset)+uint64(length) > uint64(len(w.data)) {
+		return
+	}
+	w.candidates = append(w.candidates, w.data[offset:offset+length])
+}
+
+// value 读取 SHORT 或 LONG 类型标签的第 i 个值
+func (w *rawWalker) value(entry []byte, i int) uint32 {
+	values := w.values(entry)
+	if i >= len(values) {
+		return 0
+	}
+	return values[i]
+}
+
+// values 读取 SHORT、LONG 或 IFD 类型标签的全部值，其他类型返回 nil
+func (w *rawWalker) values(entry []byte) []uint32 {
+	typ := w.order.Uint16(entry[2:])
+	count := w.order.Uint32(entry[4:])
+	size := 0
+	switch typ {
+	case 3: // SHORT
+		size = 2
+	case 4, 13: // LONG、IFD
+		size = 4
+	default:
+		return nil
+	}
+
+	raw := entry[8:12]
+	if total := uint64(count) * uint64(size); total > 4 {
+		offset := uint64(w.order.Uint32(entry[8:]))
+		if offset+total > uint64(len(w.data)) {
+			return nil
+		}
+		raw = w.data[offset : offset+total]
+	}
+
+	values := make([]uint32, 0, count)
+	for i := 0; i < int(count); i++ {
+		if size == 2 {
+			values = append(values, uint32(w.order.Uint16(raw[i*2:])))
+		} else {
+			values = append(values, w.order.Uint32(raw[i*4:]))
+		}
+	}
+	return values
+}
+
+// decodeRAWPreview 解码 RAW 文件中的 JPEG 预览图（不应用 EXIF 方向）
+func decodeRAWPreview(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := ExtractRAWPreview(data)
+	if err != nil {
+		return nil, err
+	}
+	return jpeg.Decode(bytes.NewReader(preview))
+}
+
+// rawPreviewConfig 读取 RAW 文件中 JPEG 预览图的尺寸
+func rawPreviewConfig(data []byte) (image.Config, error) {
+	preview, err := ExtractRAWPreview(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(preview))
+	if err != nil {
+		return image.Config{}, fmt.Errorf("failed to decode raw preview: %w", err)
+	}
+	return cfg, nil
+}
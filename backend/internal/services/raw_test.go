@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"picsite/internal/models"
+)
+
+// rawEntry TIFF IFD 条目，typ 为 3（SHORT）或 4（LONG）
+type rawEntry struct {
+	tag    uint16
+	typ    uint16
+	values []uint32
+}
+
+// buildRAW 构造类似 CR2/NEF 的小端 TIFF：IFD0 带方向和 JPEGInterchangeFormat 缩略图，
+// 两个 SubIFD 分别以 JPEG 压缩的条带保存较大的预览图和无法解码的无损 JPEG 数据
+func buildRAW(t *testing.T, thumbnail, preview []byte) []byte {
+	t.Helper()
+	// SOF3（无损）开头的数据，声明的尺寸大于预览图
+	lossless := []byte{0xFF, 0xD8, 0xFF, 0xC3, 0x00, 0x0B, 0x08, 0x0F, 0xA0, 0x17, 0x70, 0x01, 0x01, 0x11, 0x00, 0xFF, 0xD9}
+
+	// 数据区放在三个 IFD 之后
+	ifdSize := func(n int) int { return 2 + n*12 + 4 }
+	ifd0, sub1, sub2 := 8, 8+ifdSize(5), 8+ifdSize(5)+ifdSize(3)
+	subIFDs := sub2 + ifdSize(3)
+	thumbOffset := subIFDs + 8
+	previewOffset := thumbOffset + len(thumbnail)
+	losslessOffset := previewOffset + len(preview)
+
+	out := []byte("II*\x00")
+	out = binary.LittleEndian.AppendUint32(out, uint32(ifd0))
+	writeIFD := func(entries []rawEntry) {
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(entries)))
+		for _, e := range entries {
+			out = binary.LittleEndian.AppendUint16(out, e.tag)
+			out = binary.LittleEndian.AppendUint16(out, e.typ)
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(e.values)))
+			if e.typ == 3 && len(e.values) == 1 {
+				out = binary.LittleEndian.AppendUint16(out, uint16(e.values[0]))
+				out = append(out, 0, 0)
+			} else if len(e.values) == 1 {
+				out = binary.LittleEndian.AppendUint32(out, e.values[0])
+			} else {
+				out = binary.LittleEndian.AppendUint32(out, uint32(subIFDs)) // 多个值只用于 SubIFDs
+			}
+		}
+		out = binary.LittleEndian.AppendUint32(out, 0)
+	}
+
+	writeIFD([]rawEntry{
+		{tagCompression, 3, []uint32{6}},
+		{tagOrientation, 3, []uint32{6}},
+		{tagSubIFDs, 4, []uint32{uint32(sub1), uint32(sub2)}},
+		{tagJPEGInterchange, 4, []uint32{uint32(thumbOffset)}},
+		{tagJPEGInterchangeLen, 4, []uint32{uint32(len(thumbnail))}},
+	})
+	writeIFD([]rawEntry{
+		{tagCompression, 3, []uint32{7}},
+		{tagStripOffsets, 4, []uint32{uint32(previewOffset)}},
+		{tagStripByteCounts, 4, []uint32{uint32(len(preview))}},
+	})
+	writeIFD([]rawEntry{
+		{tagCompression, 3, []uint32{7}},
+		{tagStripOffsets, 4, []uint32{uint32(losslessOffset)}},
+		{tagStripByteCounts, 4, []uint32{uint32(len(lossless))}},
+	})
+	out = binary.LittleEndian.AppendUint32(out, uint32(sub1))
+	out = binary.LittleEndian.AppendUint32(out, uint32(sub2))
+
+	if len(out) != thumbOffset {
+		t.Fatalf("Unexpected layout: %d != %d", len(out), thumbOffset)
+	}
+	out = append(out, thumbnail...)
+	out = append(out, preview...)
+	return append(out, lossless...)
+}
+
+func TestExtractRAWPreview(t *testing.T) {
+	preview := testJPEG(t, 64, 48)
+	data := buildRAW(t, testJPEG(t, 16, 12), preview)
+
+	found, err := ExtractRAWPreview(data)
+	if err != nil {
+		t.Fatalf("ExtractRAWPreview failed: %v", err)
+	}
+	if !bytes.Equal(found, preview) {
+		t.Errorf("Expected largest decodable preview, got %d bytes", len(found))
+	}
+
+	t.Run("no preview", func(t *testing.T) {
+		if _, err := ExtractRAWPreview(buildEXIF(t, privateEXIF(t))); err != ErrNoRAWPreview {
+			t.Errorf("Expected ErrNoRAWPreview, got %v", err)
+		}
+		if _, err := ExtractRAWPreview(testJPEG(t, 10, 10)); err != ErrNoRAWPreview {
+			t.Errorf("Expected ErrNoRAWPreview for JPEG, got %v", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		if _, err := ExtractRAWPreview(data[:40]); err != ErrNoRAWPreview {
+			t.Errorf("Expected ErrNoRAWPreview, got %v", err)
+		}
+	})
+}
+
+func TestInspectRAW(t *testing.T) {
+	data := buildRAW(t, testJPEG(t, 16, 12), testJPEG(t, 64, 48))
+
+	// 预览图按 RAW 中的方向（顺时针 90 度）显示
+	info, err := InspectImage(data, ".CR2")
+	if err != nil {
+		t.Fatalf("InspectImage failed: %v", err)
+	}
+	if info.Width != 48 || info.Height != 64 || info.MimeType != "image/x-canon-cr2" {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+}
+
+func TestProcessPhotoRAW(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.RenditionMode = RenditionModeEager
+	ImageConfig.RenditionWidths = []int{32}
+
+	DB = setupJobsTestDB(t)
+	Store = NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+
+	data := buildRAW(t, testJPEG(t, 16, 12), testJPEG(t, 64, 48))
+	Store.Put("shot.nef", bytes.NewReader(data), int64(len(data)), "image/x-nikon-nef")
+
+	photo := models.Photo{FilePath: "/uploads/shot.nef", FileKey: "shot.nef", MetadataPrivacy: MetadataKeep}
+	DB.Create(&photo)
+	if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+		t.Fatalf("ProcessPhoto failed: %v", err)
+	}
+
+	DB.Preload("Renditions").First(&photo, photo.ID)
+	if photo.Width != 48 || photo.Height != 64 || photo.ThumbnailKey == "" || len(photo.Renditions) == 0 {
+		t.Errorf("Expected preview-based thumbnail and renditions, got %+v", photo)
+	}
+	if photo.FileKey != "shot.nef" || photo.PublicKey != "shot_public.jpg" {
+		t.Errorf("Expected RAW master and JPEG public copy, got %s, %s", photo.FileKey, photo.PublicKey)
+	}
+
+	public, err := ReadObject(Store, photo.PublicKey)
+	if err != nil {
+		t.Fatalf("Expected public copy: %v", err)
+	}
+	if mimeType := detectMimeType(public, ".jpg"); mimeType != "image/jpeg" {
+		t.Errorf("Expected JPEG public copy, got %s", mimeType)
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"sort"
+
+	"picsite/internal/config"
+	"picsite/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// ImageOptions 图片处理配置
+type ImageOptions struct {
+	// RenditionWidths 上传时生成的响应式图片宽度（像素）
+	RenditionWidths []int
+	// RenditionFormats 每个尺寸生成的格式，JPEG 总是会生成以作为兼容格式
+	RenditionFormats []string
+	// JPEGQuality JPEG 编码质量
+	JPEGQuality int
+	// WebPQuality WebP 编码质量
+	WebPQuality int
+	// AVIFQuality AVIF 编码质量
+	AVIFQuality int
+	// RenditionMode 尺寸版本生成方式：on_demand 或 eager
+	RenditionMode string
+	// SigningKey /img 接口参数签名密钥
+	SigningKey string
+	// MaxDimension /img 接口允许的最大宽高
+	MaxDimension int
+	// MetadataPrivacy 公开原图的默认元数据处理方式，见 MetadataKeep 等常量
+	MetadataPrivacy string
+	// MaxUploadPixels 上传图片允许的最大像素数，0 表示不限制
+	MaxUploadPixels int64
+	// MaxDecodedBytes 上传图片解码后允许占用的最大内存（字节），0 表示不限制
+	MaxDecodedBytes int64
+	// Watermark 公开图片的水印配置
+	Watermark WatermarkOptions
+}
+
+// ImageConfig 当前使用的图片处理配置
+var ImageConfig = ImageOptions{
+	RenditionWidths:  []int{200, 400, 800, 1600, 2400},
+	RenditionFormats: []string{FormatJPEG, FormatWebP},
+	JPEGQuality:      ThumbnailQuality,
+	WebPQuality:      80,
+	AVIFQuality:      60,
+	RenditionMode:    RenditionModeOnDemand,
+	MaxDimension:     4096,
+	MetadataPrivacy:  MetadataStripGPS,
+	MaxUploadPixels:  100_000_000,
+	MaxDecodedBytes:  512 << 20,
+	Watermark: WatermarkOptions{
+		Position: WatermarkBottomRight,
+		Opacity:  0.5,
+		Scale:    0.2,
+		MinSize:  600,
+	},
+}
+
+// InitImageProcessing 根据配置初始化图片处理参数
+func InitImageProcessing(cfg *config.Config) {
+	if len(cfg.RenditionWidths) > 0 {
+		ImageConfig.RenditionWidths = cfg.RenditionWidths
+	}
+	if len(cfg.RenditionFormats) > 0 {
+		ImageConfig.RenditionFormats = nil
+		for _, format := range cfg.RenditionFormats {
+			if !IsSupportedFormat(format) {
+				log.Printf("Ignoring unsupported rendition format: %s", format)
+				continue
+			}
+			ImageConfig.RenditionFormats = append(ImageConfig.RenditionFormats, format)
+		}
+	}
+	if cfg.JPEGQuality > 0 {
+		ImageConfig.JPEGQuality = cfg.JPEGQuality
+	}
+	if cfg.WebPQuality > 0 {
+		ImageConfig.WebPQuality = cfg.WebPQuality
+	}
+	if cfg.AVIFQuality > 0 {
+		ImageConfig.AVIFQuality = cfg.AVIFQuality
+	}
+	switch cfg.RenditionMode {
+	case RenditionModeOnDemand, RenditionModeEager:
+		ImageConfig.RenditionMode = cfg.RenditionMode
+	case "":
+	default:
+		log.Printf("Ignoring unsupported rendition mode: %s", cfg.RenditionMode)
+	}
+	// 未单独配置签名密钥时使用 JWT 密钥
+	ImageConfig.SigningKey = cfg.ImageSigningKey
+	if ImageConfig.SigningKey == "" {
+		ImageConfig.SigningKey = cfg.JWTSecret
+	}
+	if cfg.ImageMaxDimension > 0 {
+		ImageConfig.MaxDimension = cfg.ImageMaxDimension
+	}
+	switch {
+	case IsValidMetadataPrivacy(cfg.MetadataPrivacy):
+		ImageConfig.MetadataPrivacy = cfg.MetadataPrivacy
+	case cfg.MetadataPrivacy != "":
+		log.Printf("Ignoring unsupported metadata privacy mode: %s", cfg.MetadataPrivacy)
+	}
+	if cfg.UploadMaxPixels > 0 {
+		ImageConfig.MaxUploadPixels = int64(cfg.UploadMaxPixels)
+	}
+	if cfg.UploadMaxDecodedMB > 0 {
+		ImageConfig.MaxDecodedBytes = int64(cfg.UploadMaxDecodedMB) << 20
+	}
+	initWatermark(cfg)
+}
+
+// generateRenditions 从已解码的原图生成一组不同宽度、不同格式的图片
+//
+// 不会放大图片：大于原图宽度的尺寸会被跳过，并额外生成一张原图宽度的版本。
+// watermark 为 true 时按配置加水印，见 ApplyWatermark
+func generateRenditions(store Storage, key string, img image.Image, watermark bool) ([]models.PhotoRendition, error) {
+	srcWidth := img.Bounds().Dx()
+	var renditions []models.PhotoRendition
+
+	for _, width := range renditionWidths(srcWidth) {
+		resized := img
+		if width < srcWidth {
+			resized = imaging.Resize(img, width, 0, imaging.Lanczos)
+		}
+		if watermark {
+			resized = ApplyWatermark(resized)
+		}
+
+		for _, format := range renditionFormats() {
+			var buf bytes.Buffer
+			if err := encodeImage(&buf, resized, format, 0); err != nil {
+				return renditions, fmt.Errorf("failed to encode %s rendition: %w", format, err)
+			}
+
+			renditionKey := derivedKey(key, fmt.Sprintf("_w%d%s", width, FormatExtension(format)))
+			size := int64(buf.Len())
+			if err := store.Put(renditionKey, &buf, size, FormatContentType(format)); err != nil {
+				return renditions, fmt.Errorf("failed to save rendition: %w", err)
+			}
+
+			renditions = append(renditions, models.PhotoRendition{
+				Width:  resized.Bounds().Dx(),
+				Height: resized.Bounds().Dy(),
+				Format: format,
+				Size:   size,
+				Key:    renditionKey,
+				URL:    store.URL(renditionKey),
+			})
+		}
+	}
+
+	return renditions, nil
+}
+
+// renditionFormats 返回需要生成的格式列表，JPEG 始终排在第一位
+func renditionFormats() []string {
+	formats := []string{FormatJPEG}
+	for _, format := range ImageConfig.RenditionFormats {
+		if format != FormatJPEG {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// renditionWidths 返回针对指定原图宽度需要生成的尺寸列表（升序、去重）
+func renditionWidths(srcWidth int) []int {
+	configured := append([]int(nil), ImageConfig.RenditionWidths...)
+	sort.Ints(configured)
+
+	var widths []int
+	for _, w := range configured {
+		if w <= 0 || (len(widths) > 0 && widths[len(widths)-1] == w) {
+			continue
+		}
+		if w >= srcWidth {
+			widths = append(widths, srcWidth)
+			break
+		}
+		widths = append(widths, w)
+	}
+	return widths
+}
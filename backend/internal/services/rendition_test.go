@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"image/jpeg"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenditionWidths(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.RenditionWidths = []int{800, 200, 400, 1600, 400}
+
+	tests := []struct {
+		srcWidth int
+		want     []int
+	}{
+		{3000, []int{200, 400, 800, 1600}},
+		{1000, []int{200, 400, 800, 1000}},
+		{800, []int{200, 400, 800}},
+		{150, []int{150}},
+	}
+
+	for _, tt := range tests {
+		if got := renditionWidths(tt.srcWidth); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("renditionWidths(%d) = %v, want %v", tt.srcWidth, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateRenditions(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.RenditionWidths = []int{200, 400, 800}
+	ImageConfig.RenditionFormats = []string{FormatJPEG}
+
+	store := NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+	img, err := decodeImage(bytes.NewReader(testJPEG(t, 600, 300)), ".jpg")
+	if err != nil {
+		t.Fatalf("Failed to decode test image: %v", err)
+	}
+
+	renditions, err := generateRenditions(store, "photo.jpg", img, false)
+	if err != nil {
+		t.Fatalf("Failed to generate renditions: %v", err)
+	}
+
+	if len(renditions) != 3 {
+		t.Fatalf("Expected 3 renditions, got %d", len(renditions))
+	}
+
+	wantWidths := []int{200, 400, 600}
+	for i, r := range renditions {
+		if r.Width != wantWidths[i] || r.Height != wantWidths[i]/2 {
+			t.Errorf("Rendition %d: expected %dx%d, got %dx%d", i, wantWidths[i], wantWidths[i]/2, r.Width, r.Height)
+		}
+		if r.URL != store.URL(r.Key) {
+			t.Errorf("Rendition %d: unexpected url %s", i, r.URL)
+		}
+
+		stored, err := ReadObject(store, r.Key)
+		if err != nil {
+			t.Fatalf("Failed to read rendition %s: %v", r.Key, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(stored))
+		if err != nil || cfg.Width != r.Width {
+			t.Errorf("Rendition %s is not a %dpx JPEG", r.Key, r.Width)
+		}
+	}
+}
+
+func TestGenerateRenditionsModernFormats(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.RenditionWidths = []int{64}
+	ImageConfig.RenditionFormats = []string{FormatWebP, FormatAVIF}
+
+	store := NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+	img, err := decodeImage(bytes.NewReader(testJPEG(t, 128, 96)), ".jpg")
+	if err != nil {
+		t.Fatalf("Failed to decode test image: %v", err)
+	}
+
+	renditions, err := generateRenditions(store, "photo.jpg", img, false)
+	if err != nil {
+		t.Fatalf("Failed to generate renditions: %v", err)
+	}
+
+	// JPEG 始终作为兼容格式生成
+	wantFormats := []string{FormatJPEG, FormatWebP, FormatAVIF}
+	if len(renditions) != len(wantFormats) {
+		t.Fatalf("Expected %d renditions, got %d", len(wantFormats), len(renditions))
+	}
+
+	for i, r := range renditions {
+		if r.Format != wantFormats[i] {
+			t.Errorf("Rendition %d: expected format %s, got %s", i, wantFormats[i], r.Format)
+		}
+		if !strings.HasSuffix(r.Key, FormatExtension(r.Format)) {
+			t.Errorf("Rendition %d: key %s does not match format %s", i, r.Key, r.Format)
+		}
+
+		stored, err := ReadObject(store, r.Key)
+		if err != nil {
+			t.Fatalf("Failed to read rendition %s: %v", r.Key, err)
+		}
+		img, err := decodeImage(bytes.NewReader(stored), FormatExtension(r.Format))
+		if err != nil {
+			t.Fatalf("Failed to decode %s rendition: %v", r.Format, err)
+		}
+		if img.Bounds().Dx() != 64 || img.Bounds().Dy() != 48 {
+			t.Errorf("Rendition %s: expected 64x48, got %v", r.Key, img.Bounds().Size())
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"encoding/hex"
+	"image"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"picsite/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// 相似照片参数
+const (
+	DefaultSimilarLimit  = 12
+	MaxSimilarLimit      = 50
+	colorBinsPerChannel  = 4 // 颜色直方图每个通道的分级数，共 4x4x4 = 64 格
+	colorHistogramBins   = colorBinsPerChannel * colorBinsPerChannel * colorBinsPerChannel
+	similarityMaxSize    = 64
+	similarityHashWeight = 0.5 // 构图（感知哈希）在相似度中所占的比重，其余为颜色
+)
+
+// VisualFeatures 从缩略图计算的视觉特征，用于查找近似重复、相似的照片和按颜色筛选
+type VisualFeatures struct {
+	PerceptualHash string              // 差异哈希，见 PerceptualHash
+	ColorHistogram string              // 颜色直方图，见 ColorHistogram
+	Palette        []models.PhotoColor // 调色板，见 ExtractPalette
+}
+
+// ComputeVisualFeatures 计算图片的感知哈希、颜色直方图和调色板
+func ComputeVisualFeatures(img image.Image) VisualFeatures {
+	// 先缩小再计算，结果几乎没有差别但速度快得多
+	small := imaging.Fit(img, similarityMaxSize, similarityMaxSize, imaging.Box)
+	return VisualFeatures{
+		PerceptualHash: PerceptualHash(small),
+		ColorHistogram: ColorHistogram(small),
+		Palette:        ExtractPalette(small),
+	}
+}
+
+// ColorHistogram 计算图片的颜色分布，以十六进制返回
+//
+// 每个 RGB 通道分为 4 级，共 64 格，每格保存像素占比（0-255 表示 0-100%），透明像素不计入
+func ColorHistogram(img image.Image) string {
+	nrgba := imaging.Clone(img)
+	var counts [colorHistogramBins]int
+	total := 0
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i+3] < 128 {
+			continue
+		}
+		bin := 0
+		for _, v := range nrgba.Pix[i : i+3] {
+			bin = bin*colorBinsPerChannel + int(v)*colorBinsPerChannel/256
+		}
+		counts[bin]++
+		total++
+	}
+
+	histogram := make([]byte, colorHistogramBins)
+	if total > 0 {
+		for i, count := range counts {
+			histogram[i] = byte((count*255 + total/2) / total)
+		}
+	}
+	return hex.EncodeToString(histogram)
+}
+
+// PhotoFeatures 参与相似度比较的照片
+type PhotoFeatures struct {
+	ID             uint
+	PerceptualHash string
+	ColorHistogram string
+}
+
+// SimilarPhoto 相似照片及其相似度（0-1，越大越相似）
+type SimilarPhoto struct {
+	ID    uint
+	Score float64
+}
+
+// parsedFeatures 解析后的视觉特征
+type parsedFeatures struct {
+	hash      uint64
+	hasHash   bool
+	histogram []byte
+}
+
+func parseFeatures(photo PhotoFeatures) parsedFeatures {
+	var f parsedFeatures
+	if hash, err := strconv.ParseUint(photo.PerceptualHash, 16, 64); err == nil {
+		f.hash, f.hasHash = hash, true
+	}
+	if histogram, err := hex.DecodeString(photo.ColorHistogram); err == nil && len(histogram) == colorHistogramBins {
+		f.histogram = histogram
+	}
+	return f
+}
+
+// similarity 综合构图和颜色计算两张照片的相似度
+//
+// 构图相似度按感知哈希的汉明距离换算，距离达到 32（与随机图片相当）时为 0；
+// 颜色相似度为两个直方图的交集。缺少某一项特征时只按另一项计算，两项都缺少时为 0
+func (f parsedFeatures) similarity(other parsedFeatures) float64 {
+	var hashScore, colorScore float64
+	hasHash := f.hasHash && other.hasHash
+	hasColor := f.histogram != nil && other.histogram != nil
+	if hasHash {
+		distance := bits.OnesCount64(f.hash ^ other.hash)
+		hashScore = max(0, 1-float64(distance)/MaxDuplicateThreshold)
+	}
+	if hasColor {
+		var sum, total int
+		for i := range f.histogram {
+			sum += int(min(f.histogram[i], other.histogram[i]))
+			total += int(f.histogram[i])
+		}
+		if total > 0 {
+			colorScore = float64(sum) / float64(total)
+		}
+	}
+
+	switch {
+	case hasHash && hasColor:
+		return similarityHashWeight*hashScore + (1-similarityHashWeight)*colorScore
+	case hasHash:
+		return hashScore
+	case hasColor:
+		return colorScore
+	}
+	return 0
+}
+
+// RankSimilarPhotos 按与 target 的相似度从高到低返回最多 limit 张候选照片，
+// 相似度相同时 ID 小的在前，候选中的 target 本身会被忽略
+func RankSimilarPhotos(target PhotoFeatures, candidates []PhotoFeatures, limit int) []SimilarPhoto {
+	targetFeatures := parseFeatures(target)
+	results := make([]SimilarPhoto, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.ID == target.ID {
+			continue
+		}
+		score := targetFeatures.similarity(parseFeatures(candidate))
+		if score <= 0 {
+			continue
+		}
+		results = append(results, SimilarPhoto{ID: candidate.ID, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// FindSimilarPhotos 在公开且处理完成的照片中查找与 photo 最相似的 limit 张
+func FindSimilarPhotos(photo models.Photo, limit int) ([]SimilarPhoto, error) {
+	target := PhotoFeatures{ID: photo.ID, PerceptualHash: photo.PerceptualHash, ColorHistogram: photo.ColorHistogram}
+	if target.PerceptualHash == "" && target.ColorHistogram == "" {
+		return []SimilarPhoto{}, nil
+	}
+
+	condition, args := PublicPhotoCondition()
+	var candidates []PhotoFeatures
+	if err := DB.Model(&models.Photo{}).
+		Select("id", "perceptual_hash", "color_histogram").
+		Where("id <> ? AND processing_status = ?", photo.ID, models.PhotoStatusReady).
+		Where("perceptual_hash <> '' OR color_histogram <> ''").
+		Where(condition, args...).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	return RankSimilarPhotos(target, candidates, limit), nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"encoding/hex"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestColorHistogram(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.NRGBA{255, 0, 0, 255}
+			if x == 0 {
+				c = color.NRGBA{0, 0, 255, 255}
+			}
+			if y == 0 && x == 3 {
+				c.A = 0 // 透明像素不计入
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	histogram, err := hex.DecodeString(ColorHistogram(img))
+	if err != nil || len(histogram) != colorHistogramBins {
+		t.Fatalf("Expected %d bins, got %v (%v)", colorHistogramBins, len(histogram), err)
+	}
+	// 15 个不透明像素中 11 个红色、4 个蓝色
+	red, blue := 3*colorBinsPerChannel*colorBinsPerChannel, 3
+	if histogram[red] != 187 || histogram[blue] != 68 {
+		t.Errorf("Unexpected histogram: red %d, blue %d", histogram[red], histogram[blue])
+	}
+}
+
+func TestRankSimilarPhotos(t *testing.T) {
+	base := patternImage(400, 300, 1)
+	features := func(id uint, img image.Image) PhotoFeatures {
+		f := ComputeVisualFeatures(img)
+		return PhotoFeatures{ID: id, PerceptualHash: f.PerceptualHash, ColorHistogram: f.ColorHistogram}
+	}
+
+	target := features(1, base)
+	candidates := []PhotoFeatures{
+		target,
+		features(2, patternImage(400, 300, 2.3)),
+		features(3, imaging.Resize(base, 200, 0, imaging.Lanczos)),
+		features(4, imaging.Invert(base)),
+		features(5, imaging.FlipH(base)),
+		{ID: 6},
+	}
+
+	results := RankSimilarPhotos(target, candidates, 3)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %+v", results)
+	}
+	if results[0].ID != 3 || results[0].Score < 0.9 {
+		t.Errorf("Expected resized copy first, got %+v", results)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Errorf("Expected descending scores, got %+v", results)
+		}
+	}
+	for _, r := range results {
+		if r.ID == 1 || r.ID == 6 {
+			t.Errorf("Expected target and featureless photos to be skipped, got %+v", results)
+		}
+	}
+
+	t.Run("color only", func(t *testing.T) {
+		colorOnly := PhotoFeatures{ID: 7, ColorHistogram: target.ColorHistogram}
+		results := RankSimilarPhotos(target, []PhotoFeatures{colorOnly}, 10)
+		if len(results) != 1 || results[0].Score != 1 {
+			t.Errorf("Expected identical colors to score 1, got %+v", results)
+		}
+	})
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"picsite/internal/config"
+)
+
+// LocalStorageURLPrefix 本地存储对外访问的 URL 前缀
+const LocalStorageURLPrefix = "/uploads"
+
+// PrivatePrefix 不对外公开的对象（照片原图和 .xmp 附属文件）所在的目录
+//
+// 本地存储不通过 /uploads 提供该目录下的文件；S3 存储可以将其保存在单独的私有存储桶中，
+// 或通过存储桶策略禁止公开读取
+const PrivatePrefix = "private/"
+
+// ErrObjectNotFound 对象不存在
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo 存储对象信息
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// Storage 文件存储后端
+//
+// key 使用 "/" 分隔的相对路径，例如 "1700000000_photo.jpg" 或 "thumbs/1.jpg"
+type Storage interface {
+	// Put 写入对象，size 为 -1 时表示未知长度
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，对象不存在时返回 ErrObjectNotFound
+	Get(key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(key string) error
+	// Stat 获取对象信息，对象不存在时返回 ErrObjectNotFound
+	Stat(key string) (*ObjectInfo, error)
+	// List 列出指定前缀下的所有对象
+	List(prefix string) ([]ObjectInfo, error)
+	// URL 返回对象的公开访问地址
+	URL(key string) string
+}
+
+// PresignedStorage 支持生成临时访问地址的存储后端，私有对象通过临时地址下载
+type PresignedStorage interface {
+	// PresignedURL 返回对象在 expiry 内有效的访问地址，filename 不为空时作为附件下载
+	PresignedURL(key string, expiry time.Duration, filename string) (string, error)
+}
+
+// IsPrivateKey 对象是否位于 PrivatePrefix 下
+func IsPrivateKey(key string) bool {
+	return strings.HasPrefix(key, PrivatePrefix)
+}
+
+// derivedKey 返回由原图 key 派生的文件（缩略图、尺寸版本、公开副本）的 key，
+// 派生文件需要公开访问，不放在 PrivatePrefix 下
+func derivedKey(key, suffix string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(key, path.Ext(key)), PrivatePrefix) + suffix
+}
+
+// Store 当前使用的存储后端
+var Store Storage
+
+// InitStorage 根据配置初始化存储后端
+func InitStorage(cfg *config.Config) error {
+	switch cfg.StorageBackend {
+	case "", "local":
+		Store = NewLocalStorage(cfg.UploadPath, LocalStorageURLPrefix)
+	case "s3":
+		s3, err := NewS3Storage(S3Options{
+			Endpoint:      cfg.S3Endpoint,
+			Region:        cfg.S3Region,
+			Bucket:        cfg.S3Bucket,
+			AccessKey:     cfg.S3AccessKey,
+			SecretKey:     cfg.S3SecretKey,
+			UseSSL:        cfg.S3UseSSL,
+			PublicURL:     cfg.S3PublicURL,
+			PrivateBucket: cfg.S3PrivateBucket,
+		})
+		if err != nil {
+			return err
+		}
+		Store = s3
+	default:
+		return fmt.Errorf("unsupported storage backend: %s", cfg.StorageBackend)
+	}
+	return nil
+}
+
+func GetStorage() Storage {
+	return Store
+}
+
+// ReadObject 读取整个对象内容
+func ReadObject(store Storage, key string) ([]byte, error) {
+	rc, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// ResolveStorageKey 返回照片文件对应的存储 key
+//
+// 早期的记录只保存了 "/uploads/xxx.jpg" 形式的路径，没有 key，
+// 此时从路径中推导出本地存储的 key
+func ResolveStorageKey(key, filePath string) string {
+	if key != "" {
+		return key
+	}
+	if strings.HasPrefix(filePath, LocalStorageURLPrefix+"/") {
+		return strings.TrimPrefix(filePath, LocalStorageURLPrefix+"/")
+	}
+	return ""
+}
+
+// LocalStorage 本地文件系统存储
+type LocalStorage struct {
+	root    string
+	baseURL string
+}
+
+// NewLocalStorage 创建本地文件系统存储，root 为存储根目录，baseURL 为对外访问前缀
+func NewLocalStorage(root, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Root 返回存储根目录
+func (s *LocalStorage) Root() string {
+	return s.root
+}
+
+// path 将 key 转换为文件路径，拒绝跳出根目录的 key
+func (s *LocalStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid storage key: %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *LocalStorage) Put(key string, r io.Reader, size int64, contentType string) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// 先写入临时文件再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) Get(key string) (io.ReadCloser, error) {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(fullPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (s *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.baseURL + "/" + strings.TrimPrefix(key, "/")
+}
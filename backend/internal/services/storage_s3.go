@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options S3 兼容存储配置
+type S3Options struct {
+	Endpoint  string // 例如 "s3.amazonaws.com" 或 "localhost:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	PublicURL string // 对外访问前缀（如 CDN 地址），为空时使用 endpoint/bucket
+	// PrivateBucket 保存 PrivatePrefix 下对象的私有存储桶，为空时与 Bucket 相同
+	PrivateBucket string
+}
+
+// S3Storage S3 兼容对象存储（AWS S3、MinIO、R2 等）
+//
+// PrivatePrefix 下的对象（原图和附属文件）保存在 PrivateBucket 中，不应允许公开读取，
+// 只能通过 PresignedURL 生成的临时地址下载
+type S3Storage struct {
+	client        *minio.Client
+	bucket        string
+	privateBucket string
+	publicURL     string
+}
+
+// NewS3Storage 创建 S3 兼容存储
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage requires endpoint and bucket")
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	publicURL := opts.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if opts.UseSSL {
+			scheme = "https"
+		}
+		publicURL = fmt.Sprintf("%s://%s/%s", scheme, opts.Endpoint, opts.Bucket)
+	}
+
+	privateBucket := opts.PrivateBucket
+	if privateBucket == "" {
+		privateBucket = opts.Bucket
+	}
+
+	return &S3Storage{
+		client:        client,
+		bucket:        opts.Bucket,
+		privateBucket: privateBucket,
+		publicURL:     strings.TrimSuffix(publicURL, "/"),
+	}, nil
+}
+
+func (s *S3Storage) Put(key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(context.Background(), s.bucketFor(key), key, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucketFor(key), key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.mapError(err)
+	}
+
+	// GetObject 是惰性的，先 Stat 一次以便及时发现对象不存在
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s.mapError(err)
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	err := s.client.RemoveObject(context.Background(), s.bucketFor(key), key, minio.RemoveObjectOptions{})
+	if err != nil && s.mapError(err) != ErrObjectNotFound {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucketFor(key), key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.mapError(err)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// List 列出指定前缀下的对象，PrivatePrefix 下的对象需要以其为前缀单独列出
+func (s *S3Storage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	for obj := range s.client.ListObjects(context.Background(), s.bucketFor(prefix), minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", obj.Err)
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			ContentType:  obj.ContentType,
+			LastModified: obj.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+func (s *S3Storage) URL(key string) string {
+	return s.publicURL + "/" + strings.TrimPrefix(key, "/")
+}
+
+// PresignedURL 返回私有对象的临时下载地址，filename 不为空时作为附件下载
+func (s *S3Storage) PresignedURL(key string, expiry time.Duration, filename string) (string, error) {
+	params := url.Values{}
+	if filename != "" {
+		params.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	u, err := s.client.PresignedGetObject(context.Background(), s.bucketFor(key), key, expiry, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object: %w", err)
+	}
+	return u.String(), nil
+}
+
+// bucketFor 返回对象所在的存储桶
+func (s *S3Storage) bucketFor(key string) string {
+	if IsPrivateKey(key) {
+		return s.privateBucket
+	}
+	return s.bucket
+}
+
+// mapError 将对象不存在的错误统一转换为 ErrObjectNotFound
+func (s *S3Storage) mapError(err error) error {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return ErrObjectNotFound
+	}
+	return err
+}
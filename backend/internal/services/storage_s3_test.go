@@ -0,0 +1,311 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 最小化的 S3 兼容服务，仅支持 path-style 的对象读写和 ListObjectsV2，不校验签名
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeS3Object
+}
+
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func newFakeS3(t *testing.T, buckets ...string) *httptest.Server {
+	t.Helper()
+	f := &fakeS3{buckets: make(map[string]map[string]fakeS3Object)}
+	for _, bucket := range buckets {
+		f.buckets[bucket] = make(map[string]fakeS3Object)
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	objects, ok := f.buckets[bucket]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, bucket, objects, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data = decodeAWSChunked(data)
+		}
+		objects[key] = fakeS3Object{data: data, contentType: r.Header.Get("Content-Type"), modified: time.Now()}
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, objects map[string]fakeS3Object, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int64
+		ETag         string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucket, Prefix: prefix}
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj := objects[key]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: obj.modified.UTC().Format(time.RFC3339),
+			Size:         int64(len(obj.data)),
+			ETag:         `"etag"`,
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// decodeAWSChunked 解码 aws-chunked 流式签名上传的请求体
+func decodeAWSChunked(body []byte) []byte {
+	var out []byte
+	for len(body) > 0 {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			break
+		}
+		sizeHex, _, _ := strings.Cut(string(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 || int64(len(rest)) < size {
+			break
+		}
+		out = append(out, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+	return out
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
+
+func TestS3Storage(t *testing.T) {
+	server := newFakeS3(t, "photos")
+
+	store, err := NewS3Storage(S3Options{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "photos",
+		AccessKey: "access",
+		SecretKey: "secret",
+		PublicURL: "https://cdn.example.com/",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create S3 storage: %v", err)
+	}
+
+	t.Run("put and get object", func(t *testing.T) {
+		content := []byte("image-bytes")
+		if err := store.Put("originals/1.jpg", bytes.NewReader(content), int64(len(content)), "image/jpeg"); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+
+		rc, err := store.Get("originals/1.jpg")
+		if err != nil {
+			t.Fatalf("Failed to get object: %v", err)
+		}
+		defer rc.Close()
+
+		got, _ := io.ReadAll(rc)
+		if !bytes.Equal(got, content) {
+			t.Errorf("Expected content %q, got %q", content, got)
+		}
+	})
+
+	t.Run("stat object", func(t *testing.T) {
+		info, err := store.Stat("originals/1.jpg")
+		if err != nil {
+			t.Fatalf("Failed to stat object: %v", err)
+		}
+		if info.Size != int64(len("image-bytes")) {
+			t.Errorf("Expected size %d, got %d", len("image-bytes"), info.Size)
+		}
+		if info.ContentType != "image/jpeg" {
+			t.Errorf("Expected content type image/jpeg, got %s", info.ContentType)
+		}
+	})
+
+	t.Run("list objects by prefix", func(t *testing.T) {
+		if err := store.Put("thumbs/1.jpg", bytes.NewReader([]byte("t")), 1, "image/jpeg"); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+
+		objects, err := store.List("originals/")
+		if err != nil {
+			t.Fatalf("Failed to list objects: %v", err)
+		}
+		if len(objects) != 1 || objects[0].Key != "originals/1.jpg" {
+			t.Errorf("Expected only originals/1.jpg, got %+v", objects)
+		}
+	})
+
+	t.Run("url uses public base", func(t *testing.T) {
+		if url := store.URL("originals/1.jpg"); url != "https://cdn.example.com/originals/1.jpg" {
+			t.Errorf("Unexpected url: %s", url)
+		}
+	})
+
+	t.Run("delete object", func(t *testing.T) {
+		if err := store.Delete("originals/1.jpg"); err != nil {
+			t.Fatalf("Failed to delete object: %v", err)
+		}
+		if _, err := store.Stat("originals/1.jpg"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Expected ErrObjectNotFound after delete, got %v", err)
+		}
+		if _, err := store.Get("originals/1.jpg"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Expected ErrObjectNotFound from Get after delete, got %v", err)
+		}
+	})
+
+	t.Run("missing endpoint", func(t *testing.T) {
+		if _, err := NewS3Storage(S3Options{Bucket: "photos"}); err == nil {
+			t.Error("Expected error for missing endpoint")
+		}
+	})
+}
+
+func TestS3StoragePrivateBucket(t *testing.T) {
+	server := newFakeS3(t, "photos", "vault")
+	endpoint := strings.TrimPrefix(server.URL, "http://")
+
+	store, err := NewS3Storage(S3Options{
+		Endpoint:      endpoint,
+		Region:        "us-east-1",
+		Bucket:        "photos",
+		AccessKey:     "access",
+		SecretKey:     "secret",
+		PrivateBucket: "vault",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create S3 storage: %v", err)
+	}
+	public, _ := NewS3Storage(S3Options{Endpoint: endpoint, Region: "us-east-1", Bucket: "photos"})
+
+	key := PrivatePrefix + "originals/1.jpg"
+	content := []byte("master")
+	if err := store.Put(key, bytes.NewReader(content), int64(len(content)), "image/jpeg"); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if err := store.Put("originals/1_public.jpg", bytes.NewReader(content), int64(len(content)), "image/jpeg"); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	// 私有对象只保存在私有存储桶中
+	if _, err := store.Stat(key); err != nil {
+		t.Errorf("Expected private object to be readable: %v", err)
+	}
+	if _, err := public.Stat(key); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected private object to be missing from public bucket, got %v", err)
+	}
+	if _, err := public.Stat("originals/1_public.jpg"); err != nil {
+		t.Errorf("Expected public copy in public bucket: %v", err)
+	}
+	if objects, err := store.List(PrivatePrefix); err != nil || len(objects) != 1 || objects[0].Key != key {
+		t.Errorf("Expected only %s in private listing, got %+v (%v)", key, objects, err)
+	}
+
+	t.Run("presigned url", func(t *testing.T) {
+		raw, err := store.PresignedURL(key, time.Minute, "IMG 1.jpg")
+		if err != nil {
+			t.Fatalf("PresignedURL failed: %v", err)
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("Invalid presigned url %q: %v", raw, err)
+		}
+		if u.Host != endpoint || u.Path != "/vault/"+key {
+			t.Errorf("Expected url for %s in private bucket, got %s", key, raw)
+		}
+		query := u.Query()
+		if query.Get("X-Amz-Signature") == "" || query.Get("X-Amz-Expires") != "60" {
+			t.Errorf("Expected signed url valid for 60 seconds, got %s", raw)
+		}
+		if disposition := query.Get("response-content-disposition"); !strings.HasPrefix(disposition, "attachment") || !strings.Contains(disposition, "IMG 1.jpg") {
+			t.Errorf("Expected attachment disposition, got %q", disposition)
+		}
+
+		resp, err := http.Get(raw)
+		if err != nil {
+			t.Fatalf("Failed to download presigned url: %v", err)
+		}
+		defer resp.Body.Close()
+		got, _ := io.ReadAll(resp.Body)
+		if !bytes.Equal(got, content) {
+			t.Errorf("Expected content %q, got %q", content, got)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocalStorage(root, LocalStorageURLPrefix)
+
+	t.Run("put and get object", func(t *testing.T) {
+		content := []byte("hello")
+		if err := store.Put("a/b/file.jpg", bytes.NewReader(content), int64(len(content)), "image/jpeg"); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(root, "a", "b", "file.jpg")); err != nil {
+			t.Errorf("Expected file to be written under upload path: %v", err)
+		}
+
+		rc, err := store.Get("a/b/file.jpg")
+		if err != nil {
+			t.Fatalf("Failed to get object: %v", err)
+		}
+		defer rc.Close()
+
+		got, _ := io.ReadAll(rc)
+		if !bytes.Equal(got, content) {
+			t.Errorf("Expected content %q, got %q", content, got)
+		}
+	})
+
+	t.Run("stat object", func(t *testing.T) {
+		info, err := store.Stat("a/b/file.jpg")
+		if err != nil {
+			t.Fatalf("Failed to stat object: %v", err)
+		}
+		if info.Size != 5 {
+			t.Errorf("Expected size 5, got %d", info.Size)
+		}
+		if info.ContentType != "image/jpeg" {
+			t.Errorf("Expected content type image/jpeg, got %s", info.ContentType)
+		}
+	})
+
+	t.Run("list objects by prefix", func(t *testing.T) {
+		if err := store.Put("a/other.jpg", bytes.NewReader(nil), 0, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+		if err := store.Put("c.jpg", bytes.NewReader(nil), 0, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+
+		objects, err := store.List("a/")
+		if err != nil {
+			t.Fatalf("Failed to list objects: %v", err)
+		}
+		if len(objects) != 2 {
+			t.Fatalf("Expected 2 objects, got %d", len(objects))
+		}
+		if objects[0].Key != "a/b/file.jpg" || objects[1].Key != "a/other.jpg" {
+			t.Errorf("Unexpected keys: %s, %s", objects[0].Key, objects[1].Key)
+		}
+	})
+
+	t.Run("url", func(t *testing.T) {
+		if url := store.URL("a/b/file.jpg"); url != "/uploads/a/b/file.jpg" {
+			t.Errorf("Expected /uploads/a/b/file.jpg, got %s", url)
+		}
+	})
+
+	t.Run("delete object", func(t *testing.T) {
+		if err := store.Delete("a/b/file.jpg"); err != nil {
+			t.Fatalf("Failed to delete object: %v", err)
+		}
+		if _, err := store.Stat("a/b/file.jpg"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Expected ErrObjectNotFound, got %v", err)
+		}
+
+		// 删除不存在的对象不是错误
+		if err := store.Delete("a/b/file.jpg"); err != nil {
+			t.Errorf("Expected no error deleting missing object, got %v", err)
+		}
+	})
+
+	t.Run("get missing object", func(t *testing.T) {
+		if _, err := store.Get("missing.jpg"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Expected ErrObjectNotFound, got %v", err)
+		}
+	})
+
+	t.Run("keys cannot escape root", func(t *testing.T) {
+		if err := store.Put("../../escape.jpg", bytes.NewReader([]byte("x")), 1, ""); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "escape.jpg")); err != nil {
+			t.Errorf("Expected traversal key to be confined to root: %v", err)
+		}
+	})
+}
+
+func TestResolveStorageKey(t *testing.T) {
+	tests := []struct {
+		key, filePath, want string
+	}{
+		{"photos/1.jpg", "https://cdn.example.com/photos/1.jpg", "photos/1.jpg"},
+		{"", "/uploads/1700000000_a.jpg", "1700000000_a.jpg"},
+		{"", "https://cdn.example.com/a.jpg", ""},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ResolveStorageKey(tt.key, tt.filePath); got != tt.want {
+			t.Errorf("ResolveStorageKey(%q, %q) = %q, want %q", tt.key, tt.filePath, got, tt.want)
+		}
+	}
+}
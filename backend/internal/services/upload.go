@@ -0,0 +1,408 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/gen2brain/webp"
+)
+
+// 上传文件的大小限制
+const (
+	MaxUploadSize    = 10 << 20
+	MaxRAWUploadSize = 100 << 20
+)
+
+// 上传校验的错误码，随错误信息一起返回，供前端区分失败原因
+const (
+	UploadErrUnsupportedType = "unsupported_type"  // 扩展名不在允许的列表中
+	UploadErrInvalidContent  = "invalid_content"   // 文件内容不是支持的图片格式
+	UploadErrTypeMismatch    = "type_mismatch"     // 文件内容与扩展名不符
+	UploadErrTooLarge        = "file_too_large"    // 文件大小超过限制
+	UploadErrCorrupt         = "corrupt_image"     // 无法读取图片头部信息
+	UploadErrTooManyPixels   = "too_many_pixels"   // 像素数超过限制
+	UploadErrDecodedTooLarge = "decoded_too_large" // 解码后占用的内存超过限制
+	UploadErrPolyglot        = "polyglot_file"     // 图片中夹带了网页、脚本或压缩包等其他格式的内容
+	UploadErrDuplicate       = "duplicate"         // 内容完全相同的照片已存在
+)
+
+// uploadExtensions 允许上传的扩展名及对应的内容格式，按错误提示中的顺序排列
+var uploadExtensions = []struct {
+	ext    string
+	format string
+}{
+	{".jpg", "jpeg"}, {".jpeg", "jpeg"}, {".png", "png"}, {".webp", "webp"},
+	{".heic", "heif"}, {".heif", "heif"},
+	{".dng", "tiff"}, {".cr2", "tiff"}, {".nef", "tiff"}, {".arw", "tiff"},
+}
+
+// polyglotHeadMarkers 出现在文件开头时会被浏览器或 PDF 阅读器按其他格式解析的特征
+var polyglotHeadMarkers = [][]byte{
+	[]byte("<!doctype html"), []byte("<html"), []byte("<script"), []byte("<svg"),
+	[]byte("<iframe"), []byte("<?php"), []byte("%pdf-"),
+}
+
+// polyglotScriptMarkers 不应出现在元数据块和文件尾部的脚本特征
+var polyglotScriptMarkers = [][]byte{[]byte("<?php"), []byte("<script"), []byte("<html"), []byte("<iframe")}
+
+// polyglotArchiveMarkers 紧跟在图片结束标记之后的压缩包、可执行文件和 PDF 特征（区分大小写）
+var polyglotArchiveMarkers = [][]byte{
+	[]byte("PK\x03\x04"), []byte("Rar!\x1a\x07"), []byte("7z\xbc\xaf\x27\x1c"), []byte("\x7fELF"), []byte("MZ"), []byte("%PDF-"),
+}
+
+// zipEndOfCentralDirectory ZIP 目录结束记录，压缩包阅读器会从文件末尾查找，不要求位于文件开头
+var zipEndOfCentralDirectory = []byte("PK\x05\x06")
+
+// UploadError 上传校验失败
+type UploadError struct {
+	Code    string
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	return e.Message
+}
+
+// UploadInfo 校验通过的上传图片
+type UploadInfo struct {
+	Ext      string // 小写扩展名
+	MimeType string
+	Width    int // 像素宽高（未按 EXIF 方向旋转；RAW 为内嵌预览图的尺寸）
+	Height   int
+}
+
+const (
+	originalsPrefix = PrivatePrefix + "originals/" // 照片原图所在的目录，不对外公开
+	filesPrefix     = "files/"                     // 通用上传（封面等）所在的目录，与照片原图分开，避免相同内容被当作私有原图
+)
+
+// ContentHash 返回文件内容的 SHA-256（十六进制小写）
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ContentKey 返回保存原图的 key，按内容哈希前两位分目录，文件名带有随机后缀，
+// 例如 "private/originals/3a/3a7bd3e2...c1-9f86d081884c7d65.jpg"，无法仅凭内容哈希推算出来
+func ContentKey(hash, ext string) string {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(fmt.Sprintf("failed to generate random key: %v", err))
+	}
+	return contentKey(originalsPrefix, hash+"-"+hex.EncodeToString(suffix), ext)
+}
+
+// FileContentKey 返回按内容哈希保存通用上传文件的 key
+func FileContentKey(hash, ext string) string {
+	return contentKey(filesPrefix, hash, ext)
+}
+
+func contentKey(prefix, hash, ext string) string {
+	return prefix + hash[:2] + "/" + hash + strings.ToLower(ext)
+}
+
+// UploadSizeLimit 返回该扩展名允许的最大文件大小，RAW 文件为 100MB，其他为 10MB
+func UploadSizeLimit(ext string) int64 {
+	if IsRAW(ext) {
+		return MaxRAWUploadSize
+	}
+	return MaxUploadSize
+}
+
+// ReadUpload 读取并校验上传的图片，不信任文件名和客户端声明的大小
+//
+// 依次检查扩展名、实际大小、文件头的魔数是否与扩展名一致、图片头部声明的像素数和解码后的内存占用，
+// 以及是否夹带其他格式的内容；不解码像素数据。校验失败时返回 *UploadError
+func ReadUpload(r io.Reader, filename string) ([]byte, *UploadInfo, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	format := uploadFormat(ext)
+	if format == "" {
+		names := make([]string, len(uploadExtensions))
+		for i, e := range uploadExtensions {
+			names[i] = strings.TrimPrefix(e.ext, ".")
+		}
+		return nil, nil, &UploadError{UploadErrUnsupportedType, "不支持的文件类型，仅支持 " + strings.Join(names, ", ")}
+	}
+
+	limit := UploadSizeLimit(ext)
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, nil, &UploadError{UploadErrTooLarge, fmt.Sprintf("文件大小超过限制 (最大 %dMB)", limit>>20)}
+	}
+
+	info, err := validateUpload(data, ext, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+// uploadFormat 返回扩展名对应的内容格式，不支持时返回空字符串
+func uploadFormat(ext string) string {
+	for _, e := range uploadExtensions {
+		if e.ext == ext {
+			return e.format
+		}
+	}
+	return ""
+}
+
+// sniffUploadFormat 按文件头的魔数判断内容格式
+func sniffUploadFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, pngSignature):
+		return "png"
+	case len(data) >= 16 && string(data[:4]) == "RIFF" && string(data[8:15]) == "WEBPVP8":
+		return "webp"
+	case isHEIFData(data):
+		return "heif"
+	case bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "tiff"
+	}
+	return ""
+}
+
+// validateUpload 校验文件内容，format 为扩展名对应的内容格式
+func validateUpload(data []byte, ext, format string) (*UploadInfo, error) {
+	sniffed := sniffUploadFormat(data)
+	if sniffed == "" {
+		return nil, &UploadError{UploadErrInvalidContent, "文件内容不是支持的图片格式"}
+	}
+	if sniffed != format {
+		return nil, &UploadError{UploadErrTypeMismatch, fmt.Sprintf("文件内容 (%s) 与扩展名 (%s) 不符", sniffed, ext)}
+	}
+
+	cfg, err := decodeUploadConfig(data, format)
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, &UploadError{UploadErrCorrupt, "无法读取图片信息，文件可能已损坏"}
+	}
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if ImageConfig.MaxUploadPixels > 0 && pixels > ImageConfig.MaxUploadPixels {
+		return nil, &UploadError{UploadErrTooManyPixels, fmt.Sprintf("图片像素数超过限制 (%dx%d，最多 %d 万像素)", cfg.Width, cfg.Height, ImageConfig.MaxUploadPixels/10000)}
+	}
+	if memory := pixels * decodedBytesPerPixel(cfg.ColorModel); ImageConfig.MaxDecodedBytes > 0 && memory > ImageConfig.MaxDecodedBytes {
+		return nil, &UploadError{UploadErrDecodedTooLarge, fmt.Sprintf("图片解码后占用内存超过限制 (最大 %dMB)", ImageConfig.MaxDecodedBytes>>20)}
+	}
+
+	if isPolyglot(data, format) {
+		return nil, &UploadError{UploadErrPolyglot, "文件中包含图片以外的内容"}
+	}
+
+	return &UploadInfo{
+		Ext:      ext,
+		MimeType: detectMimeType(data, ext),
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+	}, nil
+}
+
+// decodeUploadConfig 只读取图片头部的尺寸和颜色模型
+func decodeUploadConfig(data []byte, format string) (image.Config, error) {
+	r := bytes.NewReader(data)
+	switch format {
+	case "jpeg":
+		return jpeg.DecodeConfig(r)
+	case "png":
+		return png.DecodeConfig(r)
+	case "webp":
+		return webp.DecodeConfig(r)
+	case "heif":
+		return decodeHEIFConfig(data)
+	case "tiff":
+		// RAW 只会解码内嵌的 JPEG 预览图
+		return rawPreviewConfig(data)
+	}
+	return image.Config{}, fmt.Errorf("unsupported format: %s", format)
+}
+
+// decodedBytesPerPixel 按颜色模型估算解码后每个像素占用的字节数
+func decodedBytesPerPixel(model color.Model) int64 {
+	switch model {
+	case color.GrayModel:
+		return 1
+	case color.Gray16Model:
+		return 2
+	case color.YCbCrModel:
+		return 3
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// isPolyglot 判断图片是否同时是其他格式的有效文件
+//
+// 检查文件开头（浏览器和 PDF 阅读器按内容判断类型时读取的范围）、元数据块以及图片结束标记之后的数据，
+// 像素数据本身不检查，以免压缩数据偶然匹配。
+// 无法定位结束标记的格式（HEIF、RAW 等）检查文件末尾是否有 ZIP 目录结束记录，这部分可能包含像素数据
+func isPolyglot(data []byte, format string) bool {
+	head := lowerASCII(data[:min(len(data), 1024)])
+	if containsAny(head, polyglotHeadMarkers) {
+		return true
+	}
+
+	var segments [][]byte
+	end := -1
+	switch format {
+	case "jpeg":
+		segments, end = jpegLayout(data)
+	case "png":
+		segments, end = pngLayout(data)
+	case "webp":
+		segments, end = webpLayout(data)
+	}
+	if end < 0 {
+		tail := data[max(0, len(data)-(0xFFFF+22)):]
+		if bytes.Contains(tail, zipEndOfCentralDirectory) {
+			return true
+		}
+	}
+	for _, segment := range segments {
+		if containsAny(lowerASCII(segment), polyglotScriptMarkers) || bytes.Contains(segment, zipEndOfCentralDirectory) {
+			return true
+		}
+	}
+	if end >= 0 && end < len(data) {
+		// 结束标记之后允许有其他数据（例如动态照片附带的视频），只拒绝脚本、ZIP 和紧跟其后的其他格式
+		trailer := data[end:]
+		if containsAny(lowerASCII(trailer), polyglotScriptMarkers) || bytes.Contains(trailer, zipEndOfCentralDirectory) {
+			return true
+		}
+		start := bytes.TrimLeft(trailer[:min(len(trailer), 1024)], "\x00\r\n\t ")
+		for _, marker := range polyglotArchiveMarkers {
+			if bytes.HasPrefix(start, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lowerASCII 返回只将 ASCII 大写字母转为小写的副本，其他字节保持不变
+func lowerASCII(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		out[i] = b
+	}
+	return out
+}
+
+func containsAny(data []byte, markers [][]byte) bool {
+	for _, marker := range markers {
+		if bytes.Contains(data, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// jpegLayout 返回 JPEG 中 APPn 和注释段的内容，以及 EOI 之后的偏移（找不到时为 -1）
+func jpegLayout(data []byte) ([][]byte, int) {
+	var segments [][]byte
+	for pos := 2; pos+2 <= len(data); {
+		if data[pos] != 0xFF {
+			return segments, -1
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF: // 填充字节
+			pos++
+			continue
+		case marker == 0xD9:
+			return segments, pos + 2
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // 没有长度的标记
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			return segments, -1
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return segments, -1
+		}
+		if (marker >= 0xE0 && marker <= 0xEF) || marker == 0xFE {
+			segments = append(segments, data[pos+4:end])
+		}
+		pos = end
+
+		if marker == 0xDA {
+			// 跳过熵编码数据：其中的 0xFF 后面只会是 0x00（转义）或 RST 标记
+			for pos+1 < len(data) {
+				next := data[pos+1]
+				if data[pos] == 0xFF && next != 0x00 && next != 0xFF && (next < 0xD0 || next > 0xD7) {
+					break
+				}
+				pos++
+			}
+		}
+	}
+	return segments, -1
+}
+
+// pngLayout 返回 PNG 中除图像数据以外的块的内容，以及 IEND 之后的偏移（找不到时为 -1）
+func pngLayout(data []byte) ([][]byte, int) {
+	var segments [][]byte
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return segments, -1
+		}
+		if chunkType == "IEND" {
+			return segments, end
+		}
+		if chunkType != "IDAT" {
+			segments = append(segments, data[pos+8:end-4])
+		}
+		pos = end
+	}
+	return segments, -1
+}
+
+// webpLayout 返回 WebP 中除图像数据以外的块的内容，以及 RIFF 结束的偏移
+func webpLayout(data []byte) ([][]byte, int) {
+	riffEnd := 8 + int(binary.LittleEndian.Uint32(data[4:]))
+	if riffEnd > len(data) {
+		return nil, -1
+	}
+
+	var segments [][]byte
+	for pos := 12; pos+8 <= riffEnd; {
+		fourcc := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size
+		if size < 0 || end > riffEnd {
+			return segments, -1
+		}
+		switch fourcc {
+		case "VP8 ", "VP8L", "ALPH", "ANMF":
+		default:
+			segments = append(segments, data[pos+8:end])
+		}
+		pos = end + size%2
+	}
+	return segments, riffEnd
+}
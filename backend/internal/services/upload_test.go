@@ -0,0 +1,125 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// jpegComment 在 SOI 之后插入一个 COM 段
+func jpegComment(data []byte, comment string) []byte {
+	segment := []byte{0xFF, 0xFE, byte((len(comment) + 2) >> 8), byte(len(comment) + 2)}
+	segment = append(segment, comment...)
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+// testZIP 生成只包含一个文件的 ZIP 压缩包
+func testZIP(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("shell.php")
+	w.Write([]byte("hello"))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestReadUpload(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+
+	jpegData := testJPEG(t, 64, 48)
+	var png16 bytes.Buffer
+	png.Encode(&png16, image.NewNRGBA64(image.Rect(0, 0, 60, 60)))
+	var png8 bytes.Buffer
+	png.Encode(&png8, image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+
+	t.Run("valid", func(t *testing.T) {
+		tests := map[string][]byte{
+			"photo.JPG":   jpegData,
+			"scan.png":    png16.Bytes(),
+			"iphone.heic": readSampleHEIF(t),
+			"shot.nef":    buildRAW(t, testJPEG(t, 16, 12), jpegData),
+			// 动态照片在 EOI 之后附带视频
+			"motion.jpg": append(append([]byte{}, jpegData...), isoBoxBytes("ftyp", []byte("mp42\x00\x00\x00\x00isom"))...),
+		}
+		for filename, data := range tests {
+			got, info, err := ReadUpload(bytes.NewReader(data), filename)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", filename, err)
+				continue
+			}
+			if !bytes.Equal(got, data) || info.Width == 0 || info.MimeType == "" {
+				t.Errorf("%s: unexpected result %+v", filename, info)
+			}
+		}
+	})
+
+	// 16 位 PNG 为 3600 像素、解码后约 28KB
+	ImageConfig.MaxUploadPixels = 5000
+	ImageConfig.MaxDecodedBytes = 20000
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		code     string
+	}{
+		{"unsupported extension", "anim.gif", []byte("GIF89a"), UploadErrUnsupportedType},
+		{"too large", "big.jpg", make([]byte, MaxUploadSize+1), UploadErrTooLarge},
+		{"renamed executable", "setup.jpg", append([]byte("MZ\x90\x00"), make([]byte, 100)...), UploadErrInvalidContent},
+		{"html", "page.png", []byte("<html><script>alert(1)</script></html>"), UploadErrInvalidContent},
+		{"extension mismatch", "photo.jpg", png16.Bytes(), UploadErrTypeMismatch},
+		{"raw extension on jpeg", "shot.cr2", jpegData, UploadErrTypeMismatch},
+		{"truncated header", "broken.jpg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00}, UploadErrCorrupt},
+		{"raw without preview", "plain.dng", buildEXIF(t, privateEXIF(t)), UploadErrCorrupt},
+		{"too many pixels", "huge.jpg", testJPEG(t, 100, 60), UploadErrTooManyPixels},
+		{"decoded too large", "deep.png", png16.Bytes(), UploadErrDecodedTooLarge},
+		{"html in header", "xss.jpg", jpegComment(testJPEG(t, 10, 10), "<SCRIPT>alert(1)</SCRIPT>"), UploadErrPolyglot},
+		{"php in metadata", "shell.jpg", jpegComment(testJPEG(t, 10, 10), strings.Repeat(" ", 2000)+"<?php system($_GET['c']); ?>"), UploadErrPolyglot},
+		{"zip appended", "archive.jpg", append(append([]byte{}, testJPEG(t, 10, 10)...), testZIP(t)...), UploadErrPolyglot},
+		{"pdf appended", "doc.png", append(append([]byte{}, png8.Bytes()...), "\n%PDF-1.4\n"...), UploadErrPolyglot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ReadUpload(bytes.NewReader(tt.data), tt.filename)
+			var uploadErr *UploadError
+			if !errors.As(err, &uploadErr) || uploadErr.Code != tt.code {
+				t.Errorf("Expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestIsPolyglotPixelData(t *testing.T) {
+	// 压缩的像素数据中偶然出现 ZIP 目录结束记录不算多重格式
+	data := testJPEG(t, 10, 10)
+	inPixels := append(append(append([]byte{}, data[:len(data)-2]...), zipEndOfCentralDirectory...), data[len(data)-2:]...)
+	if isPolyglot(inPixels, "jpeg") {
+		t.Error("Expected ZIP marker inside pixel data to be ignored")
+	}
+	if !isPolyglot(jpegComment(data, string(zipEndOfCentralDirectory)), "jpeg") {
+		t.Error("Expected ZIP marker in metadata to be rejected")
+	}
+}
+
+func TestContentKey(t *testing.T) {
+	hash := ContentHash([]byte("hello"))
+	if hash != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Unexpected hash %s", hash)
+	}
+	key := ContentKey(hash, ".JPG")
+	if !strings.HasPrefix(key, "private/originals/2c/"+hash+"-") || !strings.HasSuffix(key, ".jpg") || len(key) != len("private/originals/2c/"+hash+"-0123456789abcdef.jpg") {
+		t.Errorf("Unexpected key %s", key)
+	}
+	if ContentKey(hash, ".jpg") == key {
+		t.Error("Expected keys for the same content to differ")
+	}
+	if key := FileContentKey(hash, ".png"); key != "files/2c/"+hash+".png" {
+		t.Errorf("Unexpected file key %s", key)
+	}
+}
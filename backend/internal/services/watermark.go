@@ -0,0 +1,304 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+
+	"picsite/internal/config"
+	"picsite/internal/models"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"gorm.io/gorm"
+)
+
+// 水印位置
+const (
+	WatermarkBottomRight = "bottom-right"
+	WatermarkBottomLeft  = "bottom-left"
+	WatermarkTopRight    = "top-right"
+	WatermarkTopLeft     = "top-left"
+	WatermarkCenter      = "center"
+)
+
+const (
+	watermarkTextSize = 96   // 文字水印的渲染字号（像素），使用时再按比例缩放
+	watermarkMargin   = 0.02 // 水印与图片边缘的距离占短边的比例
+)
+
+// WatermarkOptions 水印配置
+type WatermarkOptions struct {
+	// Mark 水印图片（文字水印已渲染为图片），为 nil 时不加水印
+	Mark image.Image
+	// Position 水印位置，见 WatermarkBottomRight 等常量
+	Position string
+	// Opacity 不透明度（0-1）
+	Opacity float64
+	// Scale 水印宽度占图片宽度的比例（0-1）
+	Scale float64
+	// MinSize 长边小于该值的图片不加水印，缩略图除外
+	MinSize int
+}
+
+// IsValidWatermarkPosition 判断是否为支持的水印位置
+func IsValidWatermarkPosition(position string) bool {
+	switch position {
+	case WatermarkBottomRight, WatermarkBottomLeft, WatermarkTopRight, WatermarkTopLeft, WatermarkCenter:
+		return true
+	}
+	return false
+}
+
+// initWatermark 根据配置加载水印图片或渲染文字水印，配置有误时记录日志并不加水印
+func initWatermark(cfg *config.Config) {
+	opts := &ImageConfig.Watermark
+	switch {
+	case IsValidWatermarkPosition(cfg.WatermarkPosition):
+		opts.Position = cfg.WatermarkPosition
+	case cfg.WatermarkPosition != "":
+		log.Printf("Ignoring unsupported watermark position: %s", cfg.WatermarkPosition)
+	}
+	if cfg.WatermarkOpacity > 0 && cfg.WatermarkOpacity <= 1 {
+		opts.Opacity = cfg.WatermarkOpacity
+	}
+	if cfg.WatermarkScale > 0 && cfg.WatermarkScale <= 1 {
+		opts.Scale = cfg.WatermarkScale
+	}
+	if cfg.WatermarkMinSize >= 0 {
+		opts.MinSize = cfg.WatermarkMinSize
+	}
+
+	var mark image.Image
+	var err error
+	switch {
+	case cfg.WatermarkImage != "":
+		mark, err = LoadWatermarkImage(cfg.WatermarkImage)
+	case cfg.WatermarkText != "":
+		var fontData []byte
+		if cfg.WatermarkFont != "" {
+			if fontData, err = os.ReadFile(cfg.WatermarkFont); err != nil {
+				err = fmt.Errorf("failed to read watermark font: %w", err)
+			}
+		}
+		if err == nil {
+			mark, err = RenderWatermarkText(cfg.WatermarkText, fontData)
+		}
+	}
+	if err != nil {
+		log.Printf("Watermark disabled: %v", err)
+		mark = nil
+	}
+	opts.Mark = mark
+}
+
+// LoadWatermarkImage 读取 PNG 格式的水印图片，透明部分在叠加时保持透明
+func LoadWatermarkImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open watermark image: %w", err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode watermark image (PNG required): %w", err)
+	}
+	return img, nil
+}
+
+// RenderWatermarkText 将文字渲染为透明背景的白色水印，带半透明阴影以便在浅色背景上辨认
+//
+// fontData 为 TrueType/OpenType 字体文件内容，为空时使用内置的 Go Bold 字体（只包含拉丁字母等字形）
+func RenderWatermarkText(text string, fontData []byte) (image.Image, error) {
+	if fontData == nil {
+		fontData = gobold.TTF
+	}
+	f, err := opentype.Parse(fontData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse watermark font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: watermarkTextSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watermark font: %w", err)
+	}
+	defer face.Close()
+
+	shadow := watermarkTextSize / 24
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	if width <= 0 {
+		return nil, errors.New("watermark text is empty")
+	}
+	ascent := metrics.Ascent.Ceil()
+	img := image.NewNRGBA(image.Rect(0, 0, width+shadow, ascent+metrics.Descent.Ceil()+shadow))
+
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.NRGBA{A: 128}),
+		Face: face,
+		Dot:  fixed.P(shadow, ascent+shadow),
+	}
+	drawer.DrawString(text)
+	drawer.Src = image.White
+	drawer.Dot = fixed.P(0, ascent)
+	drawer.DrawString(text)
+	return img, nil
+}
+
+// WatermarkConfigured 是否配置了水印
+func WatermarkConfigured() bool {
+	return ImageConfig.Watermark.Mark != nil
+}
+
+// WatermarkEnabled 照片公开的图片是否加水印：配置了水印，且照片和照片所在的任何一个相册都没有关闭水印
+func WatermarkEnabled(photo models.Photo) bool {
+	if !WatermarkConfigured() || photo.WatermarkDisabled {
+		return false
+	}
+	var count int64
+	DB.Model(&models.AlbumPhoto{}).
+		Joins("JOIN albums ON albums.id = album_photos.album_id").
+		Where("album_photos.photo_id = ? AND albums.watermark_disabled = ? AND albums.deleted_at IS NULL", photo.ID, true).
+		Count(&count)
+	return count == 0
+}
+
+// ApplyWatermark 按配置在图片上叠加水印，没有配置水印或图片长边小于 MinSize 时原样返回
+func ApplyWatermark(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if max(bounds.Dx(), bounds.Dy()) < ImageConfig.Watermark.MinSize {
+		return img
+	}
+	return drawWatermark(img)
+}
+
+// drawWatermark 按配置在图片上叠加水印，不检查 MinSize，缩略图始终加水印。没有配置水印时原样返回
+//
+// 水印宽度按图片宽度的 Scale 缩放，超出图片高度时改为按可用高度缩放
+func drawWatermark(img image.Image) image.Image {
+	opts := ImageConfig.Watermark
+	bounds := img.Bounds()
+	if opts.Mark == nil {
+		return img
+	}
+
+	margin := int(math.Round(float64(min(bounds.Dx(), bounds.Dy())) * watermarkMargin))
+	mark := imaging.Resize(opts.Mark, max(int(math.Round(float64(bounds.Dx())*opts.Scale)), 1), 0, imaging.Lanczos)
+	if maxHeight := max(bounds.Dy()-2*margin, 1); mark.Bounds().Dy() > maxHeight {
+		mark = imaging.Resize(opts.Mark, 0, maxHeight, imaging.Lanczos)
+	}
+
+	w, h := mark.Bounds().Dx(), mark.Bounds().Dy()
+	left, top := bounds.Min.X+margin, bounds.Min.Y+margin
+	right, bottom := bounds.Max.X-margin-w, bounds.Max.Y-margin-h
+	var pos image.Point
+	switch opts.Position {
+	case WatermarkTopLeft:
+		pos = image.Pt(left, top)
+	case WatermarkTopRight:
+		pos = image.Pt(right, top)
+	case WatermarkBottomLeft:
+		pos = image.Pt(left, bottom)
+	case WatermarkCenter:
+		pos = image.Pt(bounds.Min.X+(bounds.Dx()-w)/2, bounds.Min.Y+(bounds.Dy()-h)/2)
+	default:
+		pos = image.Pt(right, bottom)
+	}
+	return imaging.Overlay(img, mark, pos, opts.Opacity)
+}
+
+// RewatermarkPhoto 按当前的水印设置重新生成照片公开的图片
+//
+// 清除按需生成的缓存，重新生成缩略图和公开的原图副本，eager 模式下重新生成各尺寸版本。
+// 水印配置或照片、相册的水印开关变更后调用；尚未处理完成的照片跳过，处理时会使用新的设置
+func RewatermarkPhoto(photoID uint) error {
+	var photo models.Photo
+	if err := DB.First(&photo, photoID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // 照片已被删除
+		}
+		return err
+	}
+	if photo.ProcessingStatus != models.PhotoStatusReady {
+		return nil
+	}
+
+	key := ResolveStorageKey(photo.FileKey, photo.FilePath)
+	if key == "" {
+		return fmt.Errorf("photo %d has no storage key", photo.ID)
+	}
+
+	store := GetStorage()
+	if err := ClearImageCache(store, photo.ID); err != nil {
+		return err
+	}
+
+	data, err := ReadObject(store, key)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	img, err := decodeImage(bytes.NewReader(data), filepath.Ext(key))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	updates, err := publishOriginal(store, photo, data, img)
+	if err != nil {
+		return err
+	}
+	// 按需生成的地址随之改变，客户端缓存的旧图片不再使用
+	updates["rendition_version"] = gorm.Expr("rendition_version + 1")
+
+	watermark := WatermarkEnabled(photo)
+	thumbnailKey, _, err := saveThumbnail(store, key, img, watermark)
+	if err != nil {
+		return err
+	}
+	updates["thumbnail_key"] = thumbnailKey
+	updates["thumbnail_path"] = store.URL(thumbnailKey)
+
+	eager := ImageConfig.RenditionMode == RenditionModeEager
+	var renditions []models.PhotoRendition
+	if eager {
+		if renditions, err = generateRenditions(store, key, img, watermark); err != nil {
+			return err
+		}
+	}
+
+	var oldRenditions []models.PhotoRendition
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if eager {
+			if oldRenditions, err = replaceRenditions(tx, photo.ID, renditions); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&photo).Updates(updates).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if oldKey := ResolveStorageKey(photo.ThumbnailKey, photo.ThumbnailPath); oldKey != "" && oldKey != thumbnailKey {
+		if err := store.Delete(oldKey); err != nil {
+			fmt.Printf("Failed to delete old thumbnail: %v\n", err)
+		}
+	}
+	deleteStaleRenditions(store, oldRenditions, renditions)
+	return nil
+}
+
+// runWatermarkPhotoJob 执行 JobTypeWatermarkPhoto 任务
+func runWatermarkPhotoJob(job *models.Job) error {
+	return RewatermarkPhoto(job.PhotoID)
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"picsite/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// useTestWatermark 使用 100x50 的白色方块作为水印，宽度为图片的 1/4，完全不透明
+func useTestWatermark(position string) {
+	ImageConfig.Watermark = WatermarkOptions{
+		Mark:     imaging.New(100, 50, color.White),
+		Position: position,
+		Opacity:  1,
+		Scale:    0.25,
+		MinSize:  300,
+	}
+}
+
+// blackJPEG 生成指定尺寸的黑色 JPEG 图片
+func blackJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, imaging.New(width, height, color.Black), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// isWhite 判断像素是否接近白色（允许 JPEG 压缩误差）
+func isWhite(img image.Image, x, y int) bool {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return r>>8 > 200 && g>>8 > 200 && b>>8 > 200
+}
+
+func TestRenderWatermarkText(t *testing.T) {
+	mark, err := RenderWatermarkText("© Picsite", nil)
+	if err != nil {
+		t.Fatalf("RenderWatermarkText failed: %v", err)
+	}
+	bounds := mark.Bounds()
+	if bounds.Dx() <= bounds.Dy() {
+		t.Errorf("Expected a wide text image, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	var white, transparent int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(mark.At(x, y)).(color.NRGBA)
+			switch {
+			case c.A == 0:
+				transparent++
+			case c == color.NRGBA{255, 255, 255, 255}:
+				white++
+			}
+		}
+	}
+	if white == 0 || transparent == 0 {
+		t.Errorf("Expected white text on transparent background, got %d white and %d transparent pixels", white, transparent)
+	}
+
+	if _, err := RenderWatermarkText("", nil); err == nil {
+		t.Error("Expected error for empty text")
+	}
+	if _, err := RenderWatermarkText("x", []byte("not a font")); err == nil {
+		t.Error("Expected error for invalid font")
+	}
+}
+
+func TestApplyWatermark(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+
+	// 800x600 的图片上水印为 200x100，与边缘相距 12 像素
+	tests := []struct {
+		position string
+		x, y     int // 水印中心
+	}{
+		{WatermarkBottomRight, 688, 538},
+		{WatermarkBottomLeft, 112, 538},
+		{WatermarkTopRight, 688, 62},
+		{WatermarkTopLeft, 112, 62},
+		{WatermarkCenter, 400, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.position, func(t *testing.T) {
+			useTestWatermark(tt.position)
+			img := ApplyWatermark(imaging.New(800, 600, color.Black))
+
+			if !isWhite(img, tt.x, tt.y) {
+				t.Errorf("Expected watermark at (%d, %d)", tt.x, tt.y)
+			}
+			if isWhite(img, tt.x-105, tt.y) {
+				t.Error("Expected watermark to be 200 pixels wide")
+			}
+			if tt.position != WatermarkCenter && isWhite(img, 800-tt.x, 600-tt.y) {
+				t.Error("Expected no watermark at the opposite corner")
+			}
+		})
+	}
+
+	t.Run("opacity", func(t *testing.T) {
+		useTestWatermark(WatermarkCenter)
+		ImageConfig.Watermark.Opacity = 0.5
+		r, _, _, _ := ApplyWatermark(imaging.New(800, 600, color.Black)).At(400, 300).RGBA()
+		if v := r >> 8; v < 120 || v > 135 {
+			t.Errorf("Expected half transparent watermark, got %d", v)
+		}
+	})
+
+	t.Run("below min size", func(t *testing.T) {
+		useTestWatermark(WatermarkCenter)
+		img := imaging.New(280, 200, color.Black)
+		if out := ApplyWatermark(img); out != image.Image(img) {
+			t.Error("Expected small image to be returned unchanged")
+		}
+	})
+
+	t.Run("tall mark", func(t *testing.T) {
+		useTestWatermark(WatermarkCenter)
+		ImageConfig.Watermark.Mark = imaging.New(50, 200, color.White)
+		ImageConfig.Watermark.Scale = 1
+		out := ApplyWatermark(imaging.New(800, 300, color.Black))
+		if isWhite(out, 400, 2) || !isWhite(out, 400, 150) {
+			t.Error("Expected tall watermark to fit inside the image height")
+		}
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		ImageConfig.Watermark = WatermarkOptions{}
+		img := imaging.New(800, 600, color.Black)
+		if out := ApplyWatermark(img); out != image.Image(img) {
+			t.Error("Expected image without watermark to be returned unchanged")
+		}
+	})
+}
+
+func TestWatermarkEnabled(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	DB = setupJobsTestDB(t)
+
+	photo := models.Photo{Title: "photo", FilePath: "/uploads/photo.jpg"}
+	DB.Create(&photo)
+
+	ImageConfig.Watermark = WatermarkOptions{}
+	if WatermarkEnabled(photo) {
+		t.Error("Expected no watermark without configuration")
+	}
+
+	useTestWatermark(WatermarkBottomRight)
+	if !WatermarkEnabled(photo) {
+		t.Error("Expected watermark when configured")
+	}
+
+	optedOut := photo
+	optedOut.WatermarkDisabled = true
+	if WatermarkEnabled(optedOut) {
+		t.Error("Expected no watermark for photo with watermark disabled")
+	}
+
+	// 任意一个相册关闭水印即不加水印
+	enabled := models.Album{Name: "enabled"}
+	disabled := models.Album{Name: "disabled", WatermarkDisabled: true}
+	DB.Create(&enabled)
+	DB.Create(&disabled)
+	DB.Create(&models.AlbumPhoto{AlbumID: enabled.ID, PhotoID: photo.ID})
+	if !WatermarkEnabled(photo) {
+		t.Error("Expected watermark for photo in album with watermark enabled")
+	}
+	DB.Create(&models.AlbumPhoto{AlbumID: disabled.ID, PhotoID: photo.ID})
+	if WatermarkEnabled(photo) {
+		t.Error("Expected no watermark for photo in album with watermark disabled")
+	}
+
+	DB.Delete(&disabled)
+	if !WatermarkEnabled(photo) {
+		t.Error("Expected deleted album to be ignored")
+	}
+}
+
+func TestRewatermarkPhoto(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	ImageConfig.RenditionMode = RenditionModeEager
+	ImageConfig.RenditionWidths = []int{400, 800}
+	ImageConfig.RenditionFormats = []string{FormatJPEG}
+	useTestWatermark(WatermarkBottomRight)
+	ImageConfig.Watermark.MinSize = 600
+
+	DB = setupJobsTestDB(t)
+	Store = NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+
+	data := blackJPEG(t, 800, 600)
+	Store.Put("photo.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg")
+	cached := ImageParams{Width: 320, Fit: FitInside, Format: FormatJPEG}.CacheKey(1)
+	Store.Put(cached, bytes.NewReader(data), int64(len(data)), "image/jpeg")
+
+	photo := models.Photo{Title: "photo", FilePath: "/uploads/photo.jpg", FileKey: "photo.jpg", MetadataPrivacy: MetadataKeep}
+	DB.Create(&photo)
+	if photo.ID != 1 {
+		t.Fatalf("Expected photo 1, got %d", photo.ID)
+	}
+
+	if err := RewatermarkPhoto(photo.ID); err != nil {
+		t.Fatalf("RewatermarkPhoto failed: %v", err)
+	}
+	if _, err := Store.Stat(cached); err == nil {
+		t.Error("Expected cached image to be cleared")
+	}
+	DB.First(&photo, photo.ID)
+	if photo.RenditionVersion != 1 {
+		t.Errorf("Expected rendition version to be bumped, got %d", photo.RenditionVersion)
+	}
+
+	// 原样公开的原图加水印后也另存副本
+	DB.Preload("Renditions").First(&photo, photo.ID)
+	if photo.PublicKey != "photo_public.jpg" {
+		t.Fatalf("Expected watermarked public copy, got %q", photo.PublicKey)
+	}
+	public, err := ReadObject(Store, photo.PublicKey)
+	if err != nil {
+		t.Fatalf("Expected public copy: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(public))
+	if err != nil {
+		t.Fatalf("Failed to decode public copy: %v", err)
+	}
+	if !isWhite(img, 688, 538) {
+		t.Error("Expected watermark on public copy")
+	}
+
+	// 小于 MinSize 的尺寸版本不加水印
+	if len(photo.Renditions) != 2 {
+		t.Fatalf("Expected 2 renditions, got %d", len(photo.Renditions))
+	}
+	for _, r := range photo.Renditions {
+		data, _ := ReadObject(Store, r.Key)
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to decode rendition %s: %v", r.Key, err)
+		}
+		b := img.Bounds()
+		want := r.Width >= ImageConfig.Watermark.MinSize
+		if got := isWhite(img, b.Dx()*86/100, b.Dy()*90/100); got != want {
+			t.Errorf("Rendition %s: watermark = %v, want %v", r.Key, got, want)
+		}
+	}
+
+	// 缩略图宽 400，小于 MinSize 也加水印
+	thumbnail, err := ReadObject(Store, "photo_thumb.jpg")
+	if err != nil {
+		t.Fatalf("Expected regenerated thumbnail: %v", err)
+	}
+	if img, _ := jpeg.Decode(bytes.NewReader(thumbnail)); img == nil || !isWhite(img, 344, 269) {
+		t.Error("Expected watermark on thumbnail below min size")
+	}
+	if photo.ThumbnailKey != "photo_thumb.jpg" {
+		t.Errorf("Expected thumbnail key to be updated, got %q", photo.ThumbnailKey)
+	}
+
+	// 关闭水印后恢复原样公开
+	DB.Model(&photo).Update("watermark_disabled", true)
+	if err := RewatermarkPhoto(photo.ID); err != nil {
+		t.Fatalf("RewatermarkPhoto failed: %v", err)
+	}
+	DB.First(&photo, photo.ID)
+	if photo.PublicKey != "" || !strings.HasSuffix(photo.FilePath, "/photo.jpg") {
+		t.Errorf("Expected original to be public again, got %s (%s)", photo.FilePath, photo.PublicKey)
+	}
+	if _, err := Store.Stat("photo_public.jpg"); err == nil {
+		t.Error("Expected public copy to be deleted")
+	}
+}
+
+func TestSaveThumbnailWatermark(t *testing.T) {
+	original := ImageConfig
+	defer func() { ImageConfig = original }()
+	useTestWatermark(WatermarkBottomRight)
+	store := NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+	src := imaging.New(800, 600, color.Black)
+
+	// 缩略图为 400x300，水印 100x50，中心位于 (344, 269)
+	key, thumbnail, err := saveThumbnail(store, "photo.jpg", src, true)
+	if err != nil {
+		t.Fatalf("saveThumbnail failed: %v", err)
+	}
+	data, _ := ReadObject(store, key)
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode thumbnail: %v", err)
+	}
+	if !isWhite(img, 344, 269) {
+		t.Error("Expected watermark on thumbnail")
+	}
+	if isWhite(thumbnail, 344, 269) {
+		t.Error("Expected returned thumbnail without watermark for visual features")
+	}
+
+	// 缩略图不受 MinSize 限制
+	ImageConfig.Watermark.MinSize = 600
+	key, _, _ = saveThumbnail(store, "photo.jpg", src, true)
+	data, _ = ReadObject(store, key)
+	if img, _ := jpeg.Decode(bytes.NewReader(data)); img == nil || !isWhite(img, 344, 269) {
+		t.Error("Expected watermark on thumbnail below min size")
+	}
+}
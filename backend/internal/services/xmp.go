@@ -0,0 +1,284 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// xmpPrefixes 常用 XMP 命名空间的标准前缀，属性名统一使用这些前缀，与文件中实际使用的前缀无关
+var xmpPrefixes = map[string]string{
+	"http://purl.org/dc/elements/1.1/":                     "dc",
+	"http://ns.adobe.com/xap/1.0/":                         "xmp",
+	"http://ns.adobe.com/xap/1.0/mm/":                      "xmpMM",
+	"http://ns.adobe.com/xap/1.0/rights/":                  "xmpRights",
+	"http://ns.adobe.com/photoshop/1.0/":                   "photoshop",
+	"http://ns.adobe.com/lightroom/1.0/":                   "lr",
+	"http://ns.adobe.com/camera-raw-settings/1.0/":         "crs",
+	"http://ns.adobe.com/exif/1.0/":                        "exif",
+	"http://ns.adobe.com/exif/1.0/aux/":                    "aux",
+	"http://ns.adobe.com/tiff/1.0/":                        "tiff",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/":          "Iptc4xmpCore",
+	"http://iptc.org/std/Iptc4xmpExt/2008-02-29/":          "Iptc4xmpExt",
+	"http://cipa.jp/exif/1.0/":                             "exifEX",
+	"http://ns.useplus.org/ldf/xmp/1.0/":                   "plus",
+	"http://ns.adobe.com/xmp/note/":                        "xmpNote",
+	"http://ns.google.com/photos/1.0/camera/":              "GCamera",
+	"http://ns.adobe.com/xap/1.0/sType/ResourceEvent#":     "stEvt",
+	"http://ns.adobe.com/xap/1.0/sType/ResourceRef#":       "stRef",
+	"http://ns.adobe.com/xmp/1.0/DynamicMedia/":            "xmpDM",
+	"http://www.metadataworkinggroup.com/schemas/regions/": "mwg-rs",
+}
+
+var (
+	xmpPacketStart = []byte("<x:xmpmeta")
+	xmpPacketEnd   = []byte("</x:xmpmeta>")
+)
+
+// XMPData 从 XMP 中读取的照片信息（Lightroom 等软件写入）
+type XMPData struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Keywords    []string `json:"keywords"`
+	Rating      int      `json:"rating"` // 0-5，-1 表示已拒绝
+	Label       string   `json:"label"`  // 颜色标签，如 "Red"
+}
+
+// FindXMPPacket 查找图片中嵌入的 XMP 数据包（JPEG APP1、PNG iTXt、WebP XMP 块等），没有时返回 nil
+func FindXMPPacket(data []byte) []byte {
+	start := bytes.Index(data, xmpPacketStart)
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(data[start:], xmpPacketEnd)
+	if end < 0 {
+		return nil
+	}
+	return data[start : start+end+len(xmpPacketEnd)]
+}
+
+// ParseXMPProperties 将 XMP 中的简单属性展开为 "前缀:名称" 形式的键
+//
+// 文本值为 string，rdf:Bag/rdf:Seq 为 []string，rdf:Alt 取 x-default（没有时取第一个）语言的文本；
+// 结构类型的属性会被跳过
+func ParseXMPProperties(packet []byte) (map[string]interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	dec.Strict = false
+	prefixes := make(map[string]string)
+	props := make(map[string]interface{})
+
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return props, nil
+		}
+		if err != nil {
+			return props, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		recordPrefixes(prefixes, start)
+		if start.Name.Space != rdfNamespace || start.Name.Local != "Description" {
+			continue
+		}
+
+		// rdf:Description 的属性即为简写形式的 XMP 属性
+		for _, attr := range start.Attr {
+			if attr.Name.Space == "xmlns" || attr.Name.Space == "" || attr.Name.Space == rdfNamespace {
+				continue
+			}
+			props[xmpName(prefixes, attr.Name)] = attr.Value
+		}
+
+		if err := parseXMPDescription(dec, prefixes, props); err != nil {
+			return props, err
+		}
+	}
+}
+
+// parseXMPDescription 读取 rdf:Description 的子元素，直到该元素结束
+func parseXMPDescription(dec *xml.Decoder, prefixes map[string]string, props map[string]interface{}) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			recordPrefixes(prefixes, t)
+			value, err := parseXMPProperty(dec, prefixes)
+			if err != nil {
+				return err
+			}
+			if value != nil {
+				props[xmpName(prefixes, t.Name)] = value
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// parseXMPProperty 读取一个属性元素的值，结构类型返回 nil
+func parseXMPProperty(dec *xml.Decoder, prefixes map[string]string) (interface{}, error) {
+	var (
+		text      strings.Builder
+		container string
+		items     []string
+		alt       string
+		hasAlt    bool
+		complex   bool
+	)
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			recordPrefixes(prefixes, t)
+			switch {
+			case t.Name.Space == rdfNamespace && (t.Name.Local == "Bag" || t.Name.Local == "Seq" || t.Name.Local == "Alt"):
+				container = t.Name.Local
+			case t.Name.Space == rdfNamespace && t.Name.Local == "li":
+				value, err := readXMLText(dec)
+				if err != nil {
+					return nil, err
+				}
+				if container == "Alt" {
+					if !hasAlt || xmlLang(t) == "x-default" {
+						alt, hasAlt = value, true
+					}
+				} else {
+					items = append(items, value)
+				}
+			default:
+				complex = true
+				if err := dec.Skip(); err != nil {
+					return nil, err
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Space == rdfNamespace {
+				continue // 容器结束
+			}
+			switch {
+			case complex:
+				return nil, nil
+			case container == "Alt":
+				return alt, nil
+			case container != "":
+				return items, nil
+			default:
+				return strings.TrimSpace(text.String()), nil
+			}
+		}
+	}
+}
+
+// readXMLText 读取当前元素内的全部文本，直到该元素结束
+func readXMLText(dec *xml.Decoder) (string, error) {
+	var text strings.Builder
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+func xmlLang(start xml.StartElement) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "lang" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// recordPrefixes 记录元素上声明的命名空间前缀，用于未知命名空间的属性名
+func recordPrefixes(prefixes map[string]string, start xml.StartElement) {
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" {
+			if _, ok := prefixes[attr.Value]; !ok {
+				prefixes[attr.Value] = attr.Name.Local
+			}
+		}
+	}
+}
+
+// xmpName 返回 "前缀:名称" 形式的属性名，常用命名空间使用标准前缀
+func xmpName(prefixes map[string]string, name xml.Name) string {
+	if prefix, ok := xmpPrefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	if prefix, ok := prefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	return name.Local
+}
+
+// XMPFromProperties 从 ParseXMPProperties 的结果中读取照片信息
+func XMPFromProperties(props map[string]interface{}) *XMPData {
+	xmpData := &XMPData{
+		Title:       xmpString(props["dc:title"]),
+		Description: xmpString(props["dc:description"]),
+		Label:       xmpString(props["xmp:Label"]),
+	}
+
+	switch v := props["dc:subject"].(type) {
+	case []string:
+		for _, keyword := range v {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				xmpData.Keywords = append(xmpData.Keywords, keyword)
+			}
+		}
+	case string:
+		// 非标准写法：逗号或分号分隔的字符串
+		for _, keyword := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' }) {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				xmpData.Keywords = append(xmpData.Keywords, keyword)
+			}
+		}
+	}
+
+	// 评分可能写成 "4" 或 "4.0"
+	if rating, err := strconv.ParseFloat(xmpString(props["xmp:Rating"]), 64); err == nil {
+		xmpData.Rating = int(math.Max(-1, math.Min(5, math.Round(rating))))
+	}
+
+	return xmpData
+}
+
+func xmpString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ", ")
+	}
+	return ""
+}
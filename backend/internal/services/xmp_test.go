@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"picsite/internal/models"
+)
+
+// lightroomXMP Lightroom 导出的 XMP 数据包（评分和标签以属性形式写入）
+const lightroomXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="Adobe XMP Core 7.0">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xap="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/"
+    xap:Rating="4"
+    xap:Label="Red">
+   <dc:title>
+    <rdf:Alt>
+     <rdf:li xml:lang="zh-CN">日落</rdf:li>
+     <rdf:li xml:lang="x-default">Sunset</rdf:li>
+    </rdf:Alt>
+   </dc:title>
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Golden hour at the beach</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>beach</rdf:li>
+     <rdf:li>sunset</rdf:li>
+     <rdf:li> </rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <xmpMM:History>
+    <rdf:Seq>
+     <rdf:li rdf:parseType="Resource"><stEvt:action xmlns:stEvt="http://ns.adobe.com/xap/1.0/sType/ResourceEvent#">saved</stEvt:action></rdf:li>
+    </rdf:Seq>
+   </xmpMM:History>
+   <xmpMM:DerivedFrom rdf:parseType="Resource">
+    <stRef:documentID xmlns:stRef="http://ns.adobe.com/xap/1.0/sType/ResourceRef#">abc</stRef:documentID>
+   </xmpMM:DerivedFrom>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestParseXMPProperties(t *testing.T) {
+	props, err := ParseXMPProperties([]byte(lightroomXMP))
+	if err != nil {
+		t.Fatalf("ParseXMPProperties failed: %v", err)
+	}
+
+	// 文件中使用的前缀 xap 统一为标准前缀 xmp
+	if props["xmp:Rating"] != "4" || props["xmp:Label"] != "Red" {
+		t.Errorf("Expected rating and label attributes, got %v", props)
+	}
+	if props["dc:title"] != "Sunset" {
+		t.Errorf("Expected x-default title, got %v", props["dc:title"])
+	}
+	if _, ok := props["xmpMM:DerivedFrom"]; ok {
+		t.Error("Expected struct property to be skipped")
+	}
+
+	xmpData := XMPFromProperties(props)
+	want := &XMPData{
+		Title:       "Sunset",
+		Description: "Golden hour at the beach",
+		Keywords:    []string{"beach", "sunset"},
+		Rating:      4,
+		Label:       "Red",
+	}
+	if !reflect.DeepEqual(xmpData, want) {
+		t.Errorf("Expected %+v, got %+v", want, xmpData)
+	}
+}
+
+func TestXMPFromProperties(t *testing.T) {
+	t.Run("rejected and out of range ratings", func(t *testing.T) {
+		if rating := XMPFromProperties(map[string]interface{}{"xmp:Rating": "-1"}).Rating; rating != -1 {
+			t.Errorf("Expected rejected rating -1, got %d", rating)
+		}
+		if rating := XMPFromProperties(map[string]interface{}{"xmp:Rating": "7.0"}).Rating; rating != 5 {
+			t.Errorf("Expected rating capped at 5, got %d", rating)
+		}
+	})
+
+	t.Run("keywords as delimited string", func(t *testing.T) {
+		keywords := XMPFromProperties(map[string]interface{}{"dc:subject": "a; b,c"}).Keywords
+		if !reflect.DeepEqual(keywords, []string{"a", "b", "c"}) {
+			t.Errorf("Unexpected keywords: %v", keywords)
+		}
+	})
+
+	t.Run("no properties", func(t *testing.T) {
+		if xmpData := XMPFromProperties(nil); !reflect.DeepEqual(xmpData, &XMPData{}) {
+			t.Errorf("Expected empty data, got %+v", xmpData)
+		}
+	})
+}
+
+func TestFindXMPPacket(t *testing.T) {
+	data := withXMP(testJPEG(t, 10, 10), lightroomXMP)
+	if packet := FindXMPPacket(data); !bytes.Equal(packet, []byte(lightroomXMP)) {
+		t.Errorf("Expected embedded packet, got %q", packet)
+	}
+	if packet := FindXMPPacket(testJPEG(t, 10, 10)); packet != nil {
+		t.Errorf("Expected no packet, got %q", packet)
+	}
+
+	meta := ExtractRawMetadata(data)
+	if meta.XMP["dc:title"] != "Sunset" {
+		t.Errorf("Expected XMP in raw metadata, got %v", meta.XMP)
+	}
+}
+
+func TestProcessPhotoXMP(t *testing.T) {
+	DB = setupJobsTestDB(t)
+	Store = NewLocalStorage(t.TempDir(), LocalStorageURLPrefix)
+
+	data := withXMP(testJPEG(t, 64, 48), lightroomXMP)
+	Store.Put("embedded.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg")
+
+	t.Run("embedded XMP fills blank fields", func(t *testing.T) {
+		photo := models.Photo{FilePath: "/uploads/embedded.jpg", FileKey: "embedded.jpg", Description: "From form"}
+		DB.Create(&photo)
+		if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+			t.Fatalf("ProcessPhoto failed: %v", err)
+		}
+
+		DB.First(&photo, photo.ID)
+		if photo.Title != "Sunset" || photo.Tags != "beach,sunset" || photo.Rating != 4 || photo.Label != "Red" {
+			t.Errorf("Expected XMP values, got title=%q tags=%q rating=%d label=%q", photo.Title, photo.Tags, photo.Rating, photo.Label)
+		}
+		if photo.Description != "From form" {
+			t.Errorf("Expected form description to be kept, got %q", photo.Description)
+		}
+	})
+
+	t.Run("sidecar overrides embedded XMP", func(t *testing.T) {
+		sidecar := []byte(`<?xpacket begin=""?><x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="2">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">From Sidecar</rdf:li></rdf:Alt></dc:title>
+</rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`)
+		Store.Put("embedded.xmp", bytes.NewReader(sidecar), int64(len(sidecar)), "application/rdf+xml")
+
+		photo := models.Photo{FilePath: "/uploads/embedded.jpg", FileKey: "embedded.jpg", SidecarKey: "embedded.xmp"}
+		DB.Create(&photo)
+		if err := ProcessPhoto(photo.ID, ProcessOptions{}); err != nil {
+			t.Fatalf("ProcessPhoto failed: %v", err)
+		}
+
+		DB.First(&photo, photo.ID)
+		if photo.Title != "From Sidecar" || photo.Rating != 2 || photo.Tags != "" {
+			t.Errorf("Expected sidecar values, got title=%q tags=%q rating=%d", photo.Title, photo.Tags, photo.Rating)
+		}
+	})
+}